@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides the custom configuration of the device service,
+// loaded from the 'OVMS' section of configuration.yaml.
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ServiceConfig wraps the custom configuration, its single field matches the top level section name
+type ServiceConfig struct {
+	OVMS OVMSConfig
+}
+
+// OVMSConfig holds the service level defaults, devices may override them by protocol properties
+type OVMSConfig struct {
+	// timeout of a single inference and model metadata request
+	InferTimeout    string
+	MetadataTimeout string
+	// attempts and interval to connect to an OVMS endpoint, 0 keeps the default
+	DialRetries       int
+	DialRetryInterval string
+	// attempts to reopen a broken video source, 0 keeps the default. The interval grows by
+	// ReconnectMultiplier up to ReconnectMaxInterval, randomized by +/- ReconnectJitter
+	ReconnectAttempts    int
+	ReconnectInterval    string
+	ReconnectMaxInterval string
+	ReconnectMultiplier  float64
+	ReconnectJitter      float64
+	// address and port of the live streaming server, serving the live streams, status and metrics
+	LiveHost string
+	LivePort int
+	// time given to the live streaming clients to disconnect when the service stops
+	LiveShutdownTimeout string
+	// HTTPS of the live streaming server, the certificate and key are read from files or from the secret store
+	LiveTLS           bool
+	LiveCert          string
+	LiveKey           string
+	LiveTLSSecretName string
+	// authentication of the live streaming clients: none, token, basic or jwt. The tokens, or the passwords
+	// of the basic auth users, are read from the secret store keyed by client name
+	LiveAuth           string
+	LiveAuthSecretName string
+	// address and port of the RTSP server re-streaming the annotated frames of the devices enabling it,
+	// started with the first of them. Its clients are authenticated as the live streaming clients.
+	RTSPHost string
+	RTSPPort int
+}
+
+// UpdateFromRaw updates the service's full configuration from raw data received from
+// the Configuration Provider.
+func (c *ServiceConfig) UpdateFromRaw(rawConfig interface{}) bool {
+	configuration, ok := rawConfig.(*ServiceConfig)
+	if !ok {
+		return false
+	}
+
+	*c = *configuration
+
+	return true
+}
+
+// Validate ensures the custom configuration has proper values
+func (c *OVMSConfig) Validate() error {
+	durations := map[string]string{
+		"InferTimeout":         c.InferTimeout,
+		"MetadataTimeout":      c.MetadataTimeout,
+		"DialRetryInterval":    c.DialRetryInterval,
+		"ReconnectInterval":    c.ReconnectInterval,
+		"ReconnectMaxInterval": c.ReconnectMaxInterval,
+		"LiveShutdownTimeout":  c.LiveShutdownTimeout,
+	}
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("OVMS.%s configuration setting '%s' is not a valid duration", name, value)
+		}
+	}
+
+	if c.DialRetries < 0 {
+		return fmt.Errorf("OVMS.DialRetries configuration setting can not be negative")
+	}
+	if c.ReconnectAttempts < 0 {
+		return fmt.Errorf("OVMS.ReconnectAttempts configuration setting can not be negative")
+	}
+	if c.ReconnectMultiplier != 0 && c.ReconnectMultiplier < 1 {
+		return fmt.Errorf("OVMS.ReconnectMultiplier configuration setting must be at least 1")
+	}
+	if c.ReconnectJitter < 0 || c.ReconnectJitter >= 1 {
+		return fmt.Errorf("OVMS.ReconnectJitter configuration setting must be between 0 and 1")
+	}
+	if c.LivePort < 0 || c.LivePort > 65535 {
+		return fmt.Errorf("OVMS.LivePort configuration setting must be between 0 and 65535")
+	}
+	if c.RTSPPort < 0 || c.RTSPPort > 65535 {
+		return fmt.Errorf("OVMS.RTSPPort configuration setting must be between 0 and 65535")
+	}
+	switch strings.ToLower(c.LiveAuth) {
+	case "", "none", "jwt":
+	case "token", "basic":
+		if c.LiveAuthSecretName == "" {
+			return fmt.Errorf("OVMS.LiveAuthSecretName configuration setting is required by %s authentication", c.LiveAuth)
+		}
+	default:
+		return fmt.Errorf("OVMS.LiveAuth configuration setting '%s' must be none, token, basic or jwt", c.LiveAuth)
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// TensorMetadata describes an input or output of a model
+type TensorMetadata struct {
+	Name     string
+	Datatype string
+	Shape    []int64
+}
+
+// ModelMetadata describes the inputs and outputs of a version of a model
+type ModelMetadata struct {
+	Name     string
+	Versions []string
+	Platform string
+	Inputs   []*TensorMetadata
+	Outputs  []*TensorMetadata
+}
+
+// InferTensor is an input or output tensor of an inference. Its data is the little endian
+// binary representation of its datatype, as in the KServe v2 binary tensor extension: BYTES
+// elements are prefixed with their 4 bytes length.
+type InferTensor struct {
+	Name     string
+	Datatype string
+	Shape    []int64
+	Data     []byte
+}
+
+// InferRequest feeds input tensors to a version of a model
+type InferRequest struct {
+	Id           string
+	ModelName    string
+	ModelVersion string
+	Inputs       []*InferTensor
+}
+
+// InferResponse holds the output tensors of an inference request
+type InferResponse struct {
+	Id           string
+	ModelName    string
+	ModelVersion string
+	Outputs      []*InferTensor
+}
+
+// InferenceBackend is the model server as seen by the capture pipeline, independent of
+// the transport. Errors are gRPC status errors whatever the transport, so failover and
+// metrics classify them the same.
+type InferenceBackend interface {
+	// ServerReady reports whether the server is ready for inference
+	ServerReady(ctx context.Context) (bool, error)
+	// ModelReady reports whether a version of a model is ready for inference
+	ModelReady(ctx context.Context, model string, version string) (bool, error)
+	// ModelMetadata returns the inputs and outputs of a version of a model
+	ModelMetadata(ctx context.Context, model string, version string) (*ModelMetadata, error)
+	// ModelInfer runs an inference request
+	ModelInfer(ctx context.Context, request *InferRequest) (*InferResponse, error)
+}
+
+// size in bytes of an element of a numeric datatype
+func datatypeSize(datatype string) (int, error) {
+	switch datatype {
+	case "BOOL", "INT8", "UINT8":
+		return 1, nil
+	case "INT16", "UINT16", "FP16":
+		return 2, nil
+	case "INT32", "UINT32", "FP32":
+		return 4, nil
+	case "INT64", "UINT64", "FP64":
+		return 8, nil
+	}
+	return 0, fmt.Errorf("unsupported datatype '%s'", datatype)
+}
+
+// encode float32 data to little endian FP32 raw content
+func encodeFP32(data []float32) []byte {
+	raw := make([]byte, 0, len(data)*4)
+	for _, v := range data {
+		raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(v))
+	}
+	return raw
+}
@@ -0,0 +1,425 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// default wait for a batch to fill up
+const defaultBatchWait = 10 * time.Millisecond
+
+// interval of the batch fill rate log
+const batchStatsInterval = time.Minute
+
+// BatchConfig is the batching of the frames of a device, disabled with a size of 1
+type BatchConfig struct {
+	Size int
+	Wait time.Duration
+}
+
+// Enabled reports whether frames are batched
+func (c BatchConfig) Enabled() bool {
+	return c.Size > 1
+}
+
+// parse 'BatchSize' and 'BatchWait' of a device
+func parseBatchConfig(protocol models.ProtocolProperties) (BatchConfig, error) {
+	config := BatchConfig{Size: 1, Wait: defaultBatchWait}
+
+	if str, _ := cast.ToStringE(protocol[BatchSize]); str != "" {
+		size, err := cast.ToIntE(str)
+		if err != nil || size < 1 {
+			return config, fmt.Errorf("'%s' must be a positive number of frames", BatchSize)
+		}
+		config.Size = size
+	}
+	if err := overrideDuration(&config.Wait, BatchWait, protocol[BatchWait]); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// batch key of a device, devices share a batcher when they send the same input to the same model
+// over the same connections: the same servers, transport, TLS identity and streaming mode
+func batchKey(protocol models.ProtocolProperties, model string, version string, input *ModelInput) string {
+	host, _ := cast.ToStringE(protocol["Host"])
+	port, _ := cast.ToStringE(protocol["Port"])
+	endpoints, _ := cast.ToStringE(protocol[Endpoints])
+	addresses, _ := parseEndpoints(host, port, endpoints)
+	tlsInfo, _ := parseTLSInfo(protocol)
+	var conns []string
+	for _, address := range addresses {
+		conns = append(conns, grpcConnKey(address, tlsInfo))
+	}
+	transport, _ := cast.ToStringE(protocol[Transport])
+	transport, _ = parseTransport(transport)
+	streamInfer, _ := cast.ToBoolE(protocol[StreamInfer])
+	return fmt.Sprintf("%s|%s|stream=%t|%s:%s|%s|%s|%dx%dx%d", strings.Join(conns, ","), transport, streamInfer, model, version, input.Name, input.Layout, input.Width, input.Height, input.Channels)
+}
+
+// check the model accepts batches of 'size' frames on its image input
+func validateBatchSize(metadata *ModelMetadata, input *ModelInput, size int) error {
+	for _, tensor := range metadata.Inputs {
+		if tensor.Name != input.Name {
+			continue
+		}
+		shape := tensor.Shape
+		if len(shape) > 0 && shape[0] >= 0 && shape[0] < int64(size) {
+			return fmt.Errorf("input '%s' of shape %v has a batch size of %d, '%s' %d requires a dynamic or larger batch dimension", input.Name, shape, shape[0], BatchSize, size)
+		}
+	}
+	return nil
+}
+
+// BatchStats are the counters of a batcher
+type BatchStats struct {
+	Model   string
+	Version string
+	Batches int64
+	Frames  int64
+	MaxSize int
+}
+
+// FillRate is the average share of the batch size used by the batches sent
+func (s BatchStats) FillRate() float64 {
+	if s.Batches == 0 || s.MaxSize == 0 {
+		return 0
+	}
+	return float64(s.Frames) / float64(s.Batches*int64(s.MaxSize))
+}
+
+type batchItem struct {
+	backend InferenceBackend
+	inputs  []*InferTensor
+	result  chan inferFrameResult
+}
+
+// frameBatcher collects the frames of the devices sharing a model and server into batched
+// inference requests, sent when the batch is full or its first frame waited long enough
+type frameBatcher struct {
+	lc      logger.LoggingClient
+	model   string
+	version string
+	config  BatchConfig
+	timeout time.Duration
+
+	items chan *batchItem
+	stop  chan struct{}
+	done  chan struct{}
+	refs  int
+
+	batches atomic.Int64
+	frames  atomic.Int64
+}
+
+func newFrameBatcher(lc logger.LoggingClient, model string, version string, config BatchConfig, timeout time.Duration) *frameBatcher {
+	b := &frameBatcher{
+		lc:      lc,
+		model:   model,
+		version: version,
+		config:  config,
+		timeout: timeout,
+		items:   make(chan *batchItem),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Stats returns the counters of the batcher
+func (b *frameBatcher) Stats() BatchStats {
+	return BatchStats{
+		Model:   b.model,
+		Version: b.version,
+		Batches: b.batches.Load(),
+		Frames:  b.frames.Load(),
+		MaxSize: b.config.Size,
+	}
+}
+
+// Infer adds the inputs of a frame to the next batch and waits for its own response
+func (b *frameBatcher) Infer(backend InferenceBackend, inputs []*InferTensor) (*InferResponse, error) {
+	item := &batchItem{
+		backend: backend,
+		inputs:  inputs,
+		result:  make(chan inferFrameResult, 1),
+	}
+	select {
+	case b.items <- item:
+	case <-b.stop:
+		return nil, status.Error(codes.Canceled, "batcher stopped")
+	}
+	result := <-item.result
+	return result.response, result.err
+}
+
+// collect batches until stopped
+func (b *frameBatcher) run() {
+	defer close(b.done)
+
+	lastStats := time.Now()
+	for {
+		var batch []*batchItem
+		select {
+		case item := <-b.items:
+			batch = append(batch, item)
+		case <-b.stop:
+			return
+		}
+
+		timer := time.NewTimer(b.config.Wait)
+	collect:
+		for len(batch) < b.config.Size {
+			select {
+			case item := <-b.items:
+				batch = append(batch, item)
+			case <-timer.C:
+				break collect
+			case <-b.stop:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		// sent in the background so the next batch fills up meanwhile
+		b.batches.Add(1)
+		b.frames.Add(int64(len(batch)))
+		go b.send(batch)
+
+		if time.Since(lastStats) >= batchStatsInterval {
+			stats := b.Stats()
+			b.lc.Infof("Batching of model %s: %d frames in %d batches, fill rate %.0f%%", b.model, stats.Frames, stats.Batches, stats.FillRate()*100)
+			lastStats = time.Now()
+		}
+	}
+}
+
+// send a batch with the backend of its first frame and fan the response out
+func (b *frameBatcher) send(batch []*batchItem) {
+	fail := func(err error) {
+		for _, item := range batch {
+			item.result <- inferFrameResult{err: err}
+		}
+	}
+
+	inputs, err := mergeBatchInputs(batch)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	response, err := batch[0].backend.ModelInfer(ctx, &InferRequest{
+		ModelName:    b.model,
+		ModelVersion: b.version,
+		Inputs:       inputs,
+	})
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	responses, err := splitBatchResponse(response, len(batch))
+	if err != nil {
+		fail(fmt.Errorf("model %s: %v", b.model, err))
+		return
+	}
+	for i, item := range batch {
+		item.result <- inferFrameResult{response: responses[i]}
+	}
+}
+
+// merge the inputs of the frames of a batch along their first dimension
+func mergeBatchInputs(batch []*batchItem) ([]*InferTensor, error) {
+	if len(batch) == 1 {
+		return batch[0].inputs, nil
+	}
+
+	var merged []*InferTensor
+	for i, first := range batch[0].inputs {
+		shape := append([]int64(nil), first.Shape...)
+		if len(shape) == 0 {
+			return nil, fmt.Errorf("input '%s' has no batch dimension", first.Name)
+		}
+		shape[0] = 0
+		tensor := &InferTensor{
+			Name:     first.Name,
+			Datatype: first.Datatype,
+			Shape:    shape,
+		}
+		for _, item := range batch {
+			if len(item.inputs) != len(batch[0].inputs) || item.inputs[i].Name != first.Name {
+				return nil, fmt.Errorf("frames of a batch have different inputs")
+			}
+			// the elements of the frames follow each other in the binary representation
+			input := item.inputs[i]
+			tensor.Shape[0] += input.Shape[0]
+			tensor.Data = append(tensor.Data, input.Data...)
+		}
+		merged = append(merged, tensor)
+	}
+	return merged, nil
+}
+
+// split a batched response into one FP32 response per frame. Outputs with a batch dimension
+// are sliced, detection outputs of [1, 1, N, 7] rows are split by their image_id column.
+func splitBatchResponse(response *InferResponse, size int) ([]*InferResponse, error) {
+	if size == 1 {
+		return []*InferResponse{response}, nil
+	}
+
+	responses := make([]*InferResponse, size)
+	for i := range responses {
+		responses[i] = &InferResponse{
+			ModelName:    response.ModelName,
+			ModelVersion: response.ModelVersion,
+			Id:           response.Id,
+		}
+	}
+
+	for index := range response.Outputs {
+		tensor, err := DecodeOutputTensor(response, index)
+		if err != nil {
+			return nil, err
+		}
+		shape := tensor.Shape
+
+		switch {
+		case len(shape) > 0 && shape[0] == int64(size):
+			chunk := len(tensor.Data) / size
+			itemShape := append([]int64{1}, shape[1:]...)
+			for i, item := range responses {
+				item.Outputs = append(item.Outputs, &InferTensor{Name: tensor.Name, Datatype: "FP32", Shape: itemShape, Data: encodeFP32(tensor.Data[i*chunk : (i+1)*chunk])})
+			}
+
+		case len(shape) >= 2 && shape[len(shape)-1] == 7:
+			rows := make([][]float32, size)
+			for offset := 0; offset+7 <= len(tensor.Data); offset += 7 {
+				row := tensor.Data[offset : offset+7]
+				imageId := int(row[0])
+				// rows after an image_id of -1 are padding
+				if imageId < 0 {
+					break
+				}
+				if imageId >= size {
+					return nil, fmt.Errorf("output '%s' has image_id %d in a batch of %d", tensor.Name, imageId, size)
+				}
+				rows[imageId] = append(rows[imageId], 0)
+				rows[imageId] = append(rows[imageId], row[1:]...)
+			}
+			for i, item := range responses {
+				itemShape := make([]int64, len(shape))
+				for j := range itemShape {
+					itemShape[j] = 1
+				}
+				itemShape[len(shape)-2] = int64(len(rows[i]) / 7)
+				itemShape[len(shape)-1] = 7
+				item.Outputs = append(item.Outputs, &InferTensor{Name: tensor.Name, Datatype: "FP32", Shape: itemShape, Data: encodeFP32(rows[i])})
+			}
+
+		default:
+			return nil, fmt.Errorf("output '%s' of shape %v cannot be split into a batch of %d", tensor.Name, shape, size)
+		}
+	}
+	return responses, nil
+}
+
+// frameBatcherPool shares batchers between devices by batch key
+type frameBatcherPool struct {
+	lc       logger.LoggingClient
+	mu       sync.Mutex
+	batchers map[string]*frameBatcher
+}
+
+func newFrameBatcherPool(lc logger.LoggingClient) *frameBatcherPool {
+	return &frameBatcherPool{
+		lc:       lc,
+		batchers: make(map[string]*frameBatcher),
+	}
+}
+
+// Acquire returns the batcher for key, created with the settings of its first device.
+// A device sharing it with other settings is warned they do not apply.
+func (p *frameBatcherPool) Acquire(deviceName string, key string, model string, version string, config BatchConfig, timeout time.Duration) *frameBatcher {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if batcher, ok := p.batchers[key]; ok {
+		batcher.refs++
+		p.lc.Debugf("Reusing batcher of model %s, %d device(s) sharing it", model, batcher.refs)
+		if batcher.config != config || batcher.timeout != timeout {
+			p.lc.Warnf("Device %s batches up to %d frames every %s with a timeout of %s, the batcher of model %s it shares keeps up to %d frames every %s with a timeout of %s",
+				deviceName, config.Size, config.Wait, timeout, model, batcher.config.Size, batcher.config.Wait, batcher.timeout)
+		}
+		return batcher
+	}
+
+	batcher := newFrameBatcher(p.lc, model, version, config, timeout)
+	batcher.refs = 1
+	p.batchers[key] = batcher
+	p.lc.Infof("New batcher of model %s, up to %d frames every %s", model, config.Size, config.Wait)
+	return batcher
+}
+
+// Stats returns the counters of the batchers by key
+func (p *frameBatcherPool) Stats() map[string]BatchStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make(map[string]BatchStats, len(p.batchers))
+	for key, batcher := range p.batchers {
+		stats[key] = batcher.Stats()
+	}
+	return stats
+}
+
+// Release drops one reference to key, the batcher stops when the last device leaves
+func (p *frameBatcherPool) Release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	batcher, ok := p.batchers[key]
+	if !ok {
+		return
+	}
+	batcher.refs--
+	if batcher.refs > 0 {
+		return
+	}
+	close(batcher.stop)
+	<-batcher.done
+	delete(p.batchers, key)
+}
+
+// Close stops all batchers regardless of their users
+func (p *frameBatcherPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, batcher := range p.batchers {
+		close(batcher.stop)
+		<-batcher.done
+		delete(p.batchers, key)
+	}
+}
@@ -0,0 +1,284 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBatchConfig(t *testing.T) {
+	config, err := parseBatchConfig(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.Equal(t, BatchConfig{Size: 1, Wait: defaultBatchWait}, config)
+	assert.False(t, config.Enabled())
+
+	config, err = parseBatchConfig(models.ProtocolProperties{BatchSize: "8", BatchWait: "50ms"})
+	require.NoError(t, err)
+	assert.Equal(t, BatchConfig{Size: 8, Wait: 50 * time.Millisecond}, config)
+	assert.True(t, config.Enabled())
+
+	_, err = parseBatchConfig(models.ProtocolProperties{BatchSize: "0"})
+	assert.Error(t, err)
+	_, err = parseBatchConfig(models.ProtocolProperties{BatchWait: "soon"})
+	assert.Error(t, err)
+}
+
+func TestBatchKey(t *testing.T) {
+	d := newTestDriver(t)
+	input := &ModelInput{Name: "image_tensor", Datatype: "BYTES", Layout: "NHWC", Width: 300, Height: 300, Channels: 3}
+	deviceKey := func(settings map[string]string) string {
+		protocol := testProtocols()[Protocol]
+		for name, value := range settings {
+			protocol[name] = value
+		}
+		return batchKey(protocol, testModel, "1", input)
+	}
+
+	plain := deviceKey(nil)
+	assert.Equal(t, plain, deviceKey(map[string]string{Transport: TransportGRPC}))
+	assert.Equal(t, plain, deviceKey(map[string]string{Endpoints: "localhost:9000"}))
+
+	// devices differing only in their connections never share a batcher, a batch is sent
+	// over the backend of one of its devices
+	secure := deviceKey(map[string]string{TLS: "true", CACert: "/certs/ca.pem", ClientCert: "/certs/left.pem", ClientKey: "/certs/left.key"})
+	other := deviceKey(map[string]string{TLS: "true", CACert: "/certs/ca.pem", ClientCert: "/certs/right.pem", ClientKey: "/certs/right.key"})
+	keys := []string{
+		plain, secure, other,
+		deviceKey(map[string]string{TLS: "true", CACert: "/certs/ca.pem", ClientCert: "/certs/left.pem", ClientKey: "/certs/left.key", ServerName: "ovms.local"}),
+		deviceKey(map[string]string{TLS: "true", TLSSecretName: "ovms-tls"}),
+		deviceKey(map[string]string{Transport: TransportREST}),
+		deviceKey(map[string]string{StreamInfer: "true"}),
+		deviceKey(map[string]string{Endpoints: "localhost:9000,localhost:9001"}),
+	}
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			assert.NotEqual(t, keys[i], keys[j], "keys %d and %d", i, j)
+		}
+	}
+
+	config := BatchConfig{Size: 2, Wait: time.Millisecond}
+	left := d.batchers.Acquire("left-camera", secure, testModel, "1", config, time.Second)
+	defer d.batchers.Release(secure)
+	right := d.batchers.Acquire("right-camera", other, testModel, "1", config, time.Second)
+	defer d.batchers.Release(other)
+	assert.NotSame(t, left, right)
+}
+
+func TestValidateBatchSize(t *testing.T) {
+	input := &ModelInput{Name: "image"}
+	assert.NoError(t, validateBatchSize(detectionMetadata(tensorMetadata("image", "FP32", -1, 300, 300, 3)), input, 8))
+	assert.NoError(t, validateBatchSize(detectionMetadata(tensorMetadata("image", "FP32", 8, 300, 300, 3)), input, 8))
+	assert.ErrorContains(t, validateBatchSize(detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 300, 3)), input, 8), "has a batch size of 1")
+}
+
+func TestMergeBatchInputs(t *testing.T) {
+	batch := []*batchItem{
+		{inputs: append(bytesInput("image", "a"), &InferTensor{
+			Name: "image_info", Datatype: "FP32", Shape: []int64{1, 3}, Data: encodeFP32([]float32{1, 2, 1})})},
+		{inputs: append(bytesInput("image", "b"), &InferTensor{
+			Name: "image_info", Datatype: "FP32", Shape: []int64{1, 3}, Data: encodeFP32([]float32{3, 4, 1})})},
+	}
+	merged, err := mergeBatchInputs(batch)
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+	assert.Equal(t, []int64{2}, merged[0].Shape)
+	assert.Equal(t, bytesInput("image", "a", "b")[0].Data, merged[0].Data)
+	assert.Equal(t, []int64{2, 3}, merged[1].Shape)
+	assert.Equal(t, encodeFP32([]float32{1, 2, 1, 3, 4, 1}), merged[1].Data)
+
+	batch[1].inputs = bytesInput("other", "b")
+	_, err = mergeBatchInputs(batch)
+	assert.Error(t, err)
+}
+
+func TestSplitBatchResponse(t *testing.T) {
+	t.Run("image id", func(t *testing.T) {
+		// rows of image 1, image 0, then padding
+		data := []float32{
+			1, 3, 0.9, 0.1, 0.1, 0.2, 0.2,
+			0, 5, 0.8, 0.3, 0.3, 0.4, 0.4,
+			1, 7, 0.7, 0.5, 0.5, 0.6, 0.6,
+			-1, 0, 0, 0, 0, 0, 0,
+		}
+		response := &InferResponse{
+			Outputs: []*InferTensor{{Name: "detection_out", Datatype: "FP32", Shape: []int64{1, 1, 4, 7}, Data: encodeFP32(data)}},
+		}
+		responses, err := splitBatchResponse(response, 3)
+		require.NoError(t, err)
+		require.Len(t, responses, 3)
+
+		tensor, err := DecodeOutputTensor(responses[0], 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 1, 1, 7}, tensor.Shape)
+		assert.Equal(t, []float32{0, 5, 0.8, 0.3, 0.3, 0.4, 0.4}, tensor.Data)
+		tensor, err = DecodeOutputTensor(responses[1], 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 1, 2, 7}, tensor.Shape)
+		assert.Equal(t, []float32{3, 7}, []float32{tensor.Data[1], tensor.Data[8]})
+		tensor, err = DecodeOutputTensor(responses[2], 0)
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 1, 0, 7}, tensor.Shape)
+	})
+
+	t.Run("batch dimension", func(t *testing.T) {
+		var data []byte
+		for _, v := range []int32{1, 2, 3, 4, 5, 6} {
+			data = binary.LittleEndian.AppendUint32(data, uint32(v))
+		}
+		response := &InferResponse{
+			Outputs: []*InferTensor{{Name: "scores", Datatype: "INT32", Shape: []int64{2, 3}, Data: data}},
+		}
+		responses, err := splitBatchResponse(response, 2)
+		require.NoError(t, err)
+		tensor, err := DecodeOutputTensor(responses[1], 0)
+		require.NoError(t, err)
+		assert.Equal(t, &Tensor{Name: "scores", Datatype: "FP32", Shape: []int64{1, 3}, Data: []float32{4, 5, 6}}, tensor)
+	})
+
+	t.Run("not splittable", func(t *testing.T) {
+		response := &InferResponse{
+			Outputs: []*InferTensor{{Name: "scores", Datatype: "FP32", Shape: []int64{1, 5}, Data: encodeFP32(make([]float32, 5))}},
+		}
+		_, err := splitBatchResponse(response, 2)
+		assert.ErrorContains(t, err, "cannot be split")
+
+		response.Outputs[0].Shape = []int64{1, 1, 1, 7}
+		response.Outputs[0].Data = encodeFP32([]float32{4, 1, 1, 0, 0, 1, 1})
+		_, err = splitBatchResponse(response, 2)
+		assert.ErrorContains(t, err, "image_id 4")
+	})
+}
+
+func TestFrameBatcher(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "batch-camera"
+	server := startFakeServer(t, d, deviceName)
+	backend := d.backends[deviceName]
+
+	t.Run("full batch", func(t *testing.T) {
+		batcher := d.batchers.Acquire(deviceName, "full", testModel, "1", BatchConfig{Size: 3, Wait: time.Minute}, time.Second)
+		defer d.batchers.Release("full")
+
+		var wg sync.WaitGroup
+		responses := make([]*InferResponse, 3)
+		for i := range responses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var err error
+				responses[i], err = batcher.Infer(backend, bytesInput("image_tensor", "frame"))
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, []int{3}, server.BatchSizes())
+		for _, response := range responses {
+			require.NotNil(t, response)
+			tensor, err := DecodeOutputTensor(response, 0)
+			require.NoError(t, err)
+			results, err := d.readData(tensor)
+			require.NoError(t, err)
+			require.Len(t, results, 1)
+			assert.Equal(t, testDetection.Confidence, results[0].Confidence)
+		}
+		assert.Equal(t, BatchStats{Model: testModel, Version: "1", Batches: 1, Frames: 3, MaxSize: 3}, batcher.Stats())
+		assert.Equal(t, 1.0, batcher.Stats().FillRate())
+		metrics := scrapeMetrics(t, d)
+		assert.Contains(t, metrics, `device_ovms_batch_fill_ratio{batcher="full",model="`+testModel+`",version="1"} 1`)
+		assert.Contains(t, metrics, `device_ovms_batch_frames_total{batcher="full",model="`+testModel+`",version="1"} 3`)
+	})
+
+	t.Run("partial batch after wait", func(t *testing.T) {
+		batcher := d.batchers.Acquire(deviceName, "partial", testModel, "1", BatchConfig{Size: 4, Wait: 10 * time.Millisecond}, time.Second)
+		defer d.batchers.Release("partial")
+
+		response, err := batcher.Infer(backend, bytesInput("image_tensor", "frame"))
+		require.NoError(t, err)
+		assert.Len(t, response.Outputs, 1)
+		assert.Equal(t, 0.25, batcher.Stats().FillRate())
+
+		// a device with other settings shares the batcher of the first one
+		assert.Same(t, batcher, d.batchers.Acquire("other-camera", "partial", testModel, "1", BatchConfig{Size: 2, Wait: time.Second}, time.Minute))
+		d.batchers.Release("partial")
+		assert.Equal(t, BatchConfig{Size: 4, Wait: 10 * time.Millisecond}, batcher.config)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		batcher := d.batchers.Acquire(deviceName, "error", "unknown", "1", BatchConfig{Size: 2, Wait: time.Millisecond}, time.Second)
+		defer d.batchers.Release("error")
+
+		_, err := batcher.Infer(backend, bytesInput("image_tensor", "frame"))
+		assert.Error(t, err)
+	})
+
+	t.Run("released", func(t *testing.T) {
+		batcher := d.batchers.Acquire(deviceName, "released", testModel, "1", BatchConfig{Size: 2, Wait: time.Millisecond}, time.Second)
+		assert.Same(t, batcher, d.batchers.Acquire(deviceName, "released", testModel, "1", BatchConfig{Size: 2, Wait: time.Millisecond}, time.Second))
+		d.batchers.Release("released")
+		d.batchers.Release("released")
+
+		_, err := batcher.Infer(backend, bytesInput("image_tensor", "frame"))
+		assert.Error(t, err)
+	})
+}
+
+func TestProcessMjpegStreamBatched(t *testing.T) {
+	d := newTestDriver(t)
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return ovmstest.NewReplaySource(uri, false)
+	}
+	server := ovmstest.NewServer(testModel)
+	server.Inputs[0].Shape = []int64{-1, 300, 300, 3}
+	server.SetDetections(testDetection)
+	server.Start()
+	t.Cleanup(server.Stop)
+	conn, err := server.Dial()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	// two cameras sharing the model fill batches of two frames
+	protocols := testProtocols()
+	protocols[Protocol][BatchSize] = "2"
+	protocols[Protocol][BatchWait] = "1s"
+	deviceNames := []string{"batch-left", "batch-right"}
+	for _, deviceName := range deviceNames {
+		d.backends[deviceName] = newGRPCBackend(grpc_client.NewGRPCInferenceServiceClient(conn))
+		d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+	}
+	var wg sync.WaitGroup
+	for _, deviceName := range deviceNames {
+		wg.Add(1)
+		go func(deviceName string, backend InferenceBackend) {
+			defer wg.Done()
+			err := d.processMjpegStream(deviceName, backend, protocols, d.policy, make(chan struct{}))
+			assert.ErrorContains(t, err, "failed to read image")
+		}(deviceName, d.backends[deviceName])
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{2, 2, 2}, server.BatchSizes())
+	for _, deviceName := range deviceNames {
+		result := <-d.ovmsCh[deviceName]
+		assert.Equal(t, []float32{0.9}, result.Scores)
+	}
+
+	// a model with a fixed batch size of 1 cannot batch
+	server.Inputs[0].Shape = []int64{1, 300, 300, 3}
+	err = d.processMjpegStream("batch-left", d.backends["batch-left"], protocols, d.policy, make(chan struct{}))
+	var modelErr *ModelError
+	assert.ErrorAs(t, err, &modelErr)
+}
@@ -11,45 +11,136 @@ package driver
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
 	"math"
 	"time"
 
-	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v4/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
 	"github.com/spf13/cast"
 	"gocv.io/x/gocv"
 )
 
 // imageCapture continuously captures frames from the webcam, performs inference,
-// and writes the results to video and stream
-func (d *Driver) imageCapture(deviceName string, grpcClient *grpc_client.GRPCInferenceServiceClient, protocols map[string]models.ProtocolProperties) {
+// and writes the results to video and stream until 'stop' is closed
+func (d *Driver) imageCapture(deviceName string, backend InferenceBackend, protocols map[string]models.ProtocolProperties, stop <-chan struct{}) {
 
-	// reconnectInterval is the time to wait before attempting to reconnect to the device,
-	// maxReconnectAttempts is the number of times to attempt to reconnect
-	reconnectInterval := time.Duration(5) * time.Second
-	maxReconnectAttempts := 60
-
-	for attempt := 0; attempt < maxReconnectAttempts; attempt++ {
-		err := d.processMjpegStream(deviceName, grpcClient, protocols)
-		if err != nil {
-			d.lc.Errorf("Error processing device: %s, error: %v", deviceName, err)
-			d.lc.Errorf("Attempting to reconnect in %v seconds (attempt %d/%d)...", reconnectInterval.Seconds(), attempt+1, maxReconnectAttempts)
-			time.Sleep(reconnectInterval) // sleep for reconnectInterval seconds
-		} else {
-			d.lc.Infof("Successfully reconnected to device: %s", deviceName)
-			// reset attempt counter
-			attempt = 0
+	// timeouts and reconnect policy of the device, see DevicePolicy
+	policy, err := d.DevicePolicy(protocols[Protocol])
+	if err != nil {
+		d.lc.Errorf("Invalid timeout or retry settings for device %s, using service defaults: %v", deviceName, err)
+		policy = d.policy
+	}
+	reconnect := policy.Reconnect
+	health := d.deviceHealth(deviceName)
+
+	d.setCaptureState(deviceName, CaptureConnecting)
+	for attempt := 1; ; attempt++ {
+		err := d.processMjpegStream(deviceName, backend, protocols, policy, stop)
+		if err == nil {
+			return
+		}
+		// a model not matching the configuration is not retried
+		health.failed(err)
+		var modelErr *ModelError
+		if errors.As(err, &modelErr) {
+			d.lc.Errorf("Model of device %s does not match its configuration, capture failed: %v", deviceName, err)
+			d.setCaptureState(deviceName, CaptureFailed)
+			return
+		}
+		// a source that was streaming before it failed gets a fresh series of attempts
+		if d.captureState(deviceName) == CaptureRunning {
+			attempt = 1
+		}
+		d.lc.Errorf("Error processing device: %s, error: %v", deviceName, err)
+		if reconnect.Exhausted(attempt) {
 			break
 		}
+		health.reconnecting(attempt)
+		d.setCaptureState(deviceName, CaptureReconnecting)
+		d.metrics.reconnects.WithLabelValues(deviceName).Inc()
+		delay := reconnect.Delay(attempt)
+		d.lc.Errorf("Attempting to reconnect in %.1f seconds (attempt %s)...", delay.Seconds(), reconnect.Progress(attempt))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
 	}
-	d.lc.Errorf("Maximum number of reconnect attempts reached, exiting...")
+	d.setCaptureState(deviceName, CaptureFailed)
+	d.lc.Errorf("Maximum number of reconnect attempts reached for device %s, capture failed", deviceName)
 }
 
-// processMjpegStream continuously captures frames from the webcam, performs inference, and writes the results to video and stream
-func (d *Driver) processMjpegStream(deviceName string, grpcClient *grpc_client.GRPCInferenceServiceClient, protocols map[string]models.ProtocolProperties) error {
+// set the capture state of a device, the operating state of the device follows it
+// so a failed capture is visible in core metadata, and every transition is pushed as a status reading
+func (d *Driver) setCaptureState(deviceName string, state string) {
+	d.stateMu.Lock()
+	previous := d.captureStates[deviceName]
+	d.captureStates[deviceName] = state
+	d.stateMu.Unlock()
+
+	if previous == state {
+		return
+	}
+	if state == CaptureRunning {
+		d.deviceHealth(deviceName).reconnecting(0)
+	}
+	d.pushStatus(deviceName)
+	if d.sdk == nil {
+		return
+	}
+	var operatingState models.OperatingState
+	switch state {
+	case CaptureRunning:
+		operatingState = models.Up
+	case CaptureFailed:
+		operatingState = models.Down
+	default:
+		return
+	}
+	if err := d.sdk.UpdateDeviceOperatingState(deviceName, operatingState); err != nil {
+		d.lc.Errorf("Failed to update operating state of device %s: %v", deviceName, err)
+	}
+}
+
+// set the motion state of a device, a change is pushed as an async reading of the motion resource
+func (d *Driver) setMotionState(deviceName string, moving bool) {
+	d.stateMu.Lock()
+	previous, known := d.motionStates[deviceName]
+	d.motionStates[deviceName] = moving
+	d.stateMu.Unlock()
+
+	if (known && previous == moving) || d.asyncCh == nil {
+		return
+	}
+	cv, err := sdkModel.NewCommandValue(MotionResource, common.ValueTypeBool, moving)
+	if err != nil {
+		d.lc.Errorf("Failed to create motion reading of device %s: %v", deviceName, err)
+		return
+	}
+	d.pushReading(deviceName, MotionResource, cv)
+}
+
+// get the motion state of a device, false when motion is not detected for the device
+func (d *Driver) motionState(deviceName string) (moving bool, ok bool) {
+	d.stateMu.RLock()
+	defer d.stateMu.RUnlock()
+	moving, ok = d.motionStates[deviceName]
+	return
+}
+
+// get the capture state of a device
+func (d *Driver) captureState(deviceName string) string {
+	d.stateMu.RLock()
+	defer d.stateMu.RUnlock()
+	return d.captureStates[deviceName]
+}
+
+// processMjpegStream continuously captures frames from the webcam, performs inference, and writes the results to video and stream.
+// It returns nil once 'stop' is closed.
+func (d *Driver) processMjpegStream(deviceName string, backend InferenceBackend, protocols map[string]models.ProtocolProperties, policy DevicePolicy, stop <-chan struct{}) error {
 
 	// get parameters from protocols
 	d.lc.Debugf("processOutput()")
@@ -60,249 +151,398 @@ func (d *Driver) processMjpegStream(deviceName string, grpcClient *grpc_client.G
 	version, _ := cast.ToStringE(protocol["Version"])
 	snapshot, _ := cast.ToStringE(protocol["Snapshot"])
 
-	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
-
-	// define input and output bytes
-	var scores []float32
-	var tipsColor = color.RGBA{R: 255, G: 0, B: 255, A: 128} // green color
-	var fontScale = 1.0
-	var fontThinkness = 1
-	var fontStyle = gocv.FontHersheyPlain
-
 	// validate score, set default value if invalid
 	if score <= 0.0 || score > 1.0 {
 		score = 0.6
 	}
 
-	cap, err := gocv.VideoCaptureFile(uri)
+	cap, err := d.OpenFrameSource(uri)
 	if err != nil {
 		d.lc.Errorf("Error opening video capture device: %s, error: %v", uri, err)
 		return err
 	}
 	defer cap.Close()
 
-	modelMeata, err := d.ModelMetadataRequest(*grpcClient, model, version)
+	// a model still loading is retried by the reconnect policy
+	health := d.deviceHealth(deviceName)
+	ready, err := d.ModelReadyRequest(backend, model, version, policy.MetadataTimeout)
+	health.setModelReady(model, ready)
+	if err != nil {
+		d.lc.Errorf("Error getting model readiness: %s", err)
+		return err
+	}
+	if !ready {
+		return fmt.Errorf("model %s version %s is not ready", model, version)
+	}
+
+	modelMeata, err := d.ModelMetadataRequest(backend, model, version, policy.MetadataTimeout)
 	if err != nil {
 		d.lc.Errorf("Error getting model metadata: %s", err)
 		return err
 	}
-	inputs := modelMeata.GetInputs()
-	inputName := inputs[0].Name
+	// the image input and the detection output must match what the driver feeds and decodes
+	input, err := NewModelInput(modelMeata, protocol)
+	if err == nil {
+		err = ValidateDetectionOutputs(modelMeata)
+	}
+	if err != nil {
+		return &ModelError{Model: model, Err: err}
+	}
+	d.lc.Infof("Device %s feeds input '%s' of model %s: %s %s %dx%d", deviceName, input.Name, model, input.Datatype, input.Layout, input.Width, input.Height)
 
-	dim := inputs[0].GetShape()
-	inputHeight := int(dim[1])
-	if inputHeight < 0 {
-		inputHeight = 640
+	// frames of the devices sharing the model and server may be inferred in batches
+	batchConfig, err := parseBatchConfig(protocol)
+	if err != nil {
+		return err
 	}
-	inputWidth := int(dim[2])
-	if inputWidth < 0 {
-		inputWidth = 640
+	var batcher *frameBatcher
+	if batchConfig.Enabled() {
+		if err := validateBatchSize(modelMeata, input, batchConfig.Size); err != nil {
+			return &ModelError{Model: model, Err: err}
+		}
+		key := batchKey(protocol, model, version, input)
+		batcher = d.batchers.Acquire(deviceName, key, model, version, batchConfig, policy.InferTimeout)
+		defer d.batchers.Release(key)
 	}
 
-	for {
+	overlayConfig, err := parseOverlayConfig(protocol)
+	if err != nil {
+		return err
+	}
+	renderer := newOverlayRenderer(overlayConfig)
+	// frames are inferred unmasked, and masked before they leave the device
+	privacyConfig, err := parsePrivacyConfig(protocol)
+	if err != nil {
+		return err
+	}
+	masker := newPrivacyMasker(privacyConfig, score)
+	defer masker.Close()
 
-		img := gocv.NewMat()
-		img_resized := gocv.NewMat()
+	// frames skipped by sampling still feed the live stream, at source frame rate
+	samplingConfig, err := parseSamplingConfig(protocol)
+	if err != nil {
+		return err
+	}
+	sampler := newFrameSampler(samplingConfig)
+	defer sampler.Close()
+	// detections of the last inferred frame, drawn on the skipped frames following it
+	var overlay []ObjectDetectionResutl
+	var overlayTime time.Time
+
+	// frames in flight, more than one only with streaming inference
+	depth := 1
+	if stream, ok := backend.(*streamInferClient); ok {
+		depth = stream.Inflight()
+	}
+	// frames are read in the background, see 'FrameBuffer'
+	latest, err := parseFrameBuffer(protocol)
+	if err != nil {
+		return err
+	}
+	frames := d.startFrameBuffer(deviceName, cap, latest)
+	defer frames.Close()
+	stats := frames.stats
+
+	var pending []*inferFrame
+	defer func() {
+		for _, frame := range pending {
+			<-frame.result
+			frame.img.Close()
+		}
+	}()
+
+	finish := func(frame *inferFrame, result inferFrameResult) {
+		defer frame.img.Close()
+		if result.err != nil {
+			d.lc.Debugf("Error predicting: %s", result.err)
+			health.failed(result.err)
+			if !masker.Withholds() {
+				d.maskFrame(deviceName, masker, &frame.img, nil)
+				d.writeStream(deviceName, StreamAnnotated, frame.img)
+			}
+			return
+		}
+		health.inferred(time.Now())
+		if sampler.Observe(result.latency, time.Now()) {
+			d.lc.Infof("Inference rate of device %s adapted to %.1f fps, latency %s", deviceName, sampler.Rate(), result.latency)
+		}
+		detections := d.processInferResponse(deviceName, frame, result.response, renderer, masker, model, score, snapshot)
+		observeSince(d.metrics.endToEndLatency.WithLabelValues(deviceName), frame.start)
+		// the frame is drawn with its detections
+		d.writeStream(deviceName, StreamAnnotated, frame.img)
+		if len(detections) > 0 {
+			d.results.Publish(newDetectionEvent(deviceName, model, time.Now(), detections))
+		}
+		overlay, overlayTime = detections, time.Now()
+	}
 
-		scores = scores[:0]
+	// finish the frames already inferred, without waiting for the others
+	collect := func() {
+		for len(pending) > 0 {
+			select {
+			case result := <-pending[0].result:
+				frame := pending[0]
+				pending = pending[1:]
+				finish(frame, result)
+			default:
+				return
+			}
+		}
+	}
 
-		// invoke inference
-		time_start_inference := time.Now()
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
 
-		if ok := cap.Read(&img); !ok {
-			err = fmt.Errorf("failed to read image from MJPEG stream, device: %s", deviceName)
-			img.Close()
-			img_resized.Close()
+		// the newest frame, the inference time counts from its read
+		buffered, err := frames.Next(stop)
+		if errors.Is(err, errCaptureStopped) {
+			return nil
+		}
+		if err != nil {
 			return err
 		}
+		img := buffered.img
+		time_start_inference := buffered.read
+		health.frameRead(time_start_inference)
+		// the raw frame is inferred, a masked copy goes to the raw stream unless it waits for its detections
+		if !masker.Withholds() {
+			raw, err := masker.Masked(img)
+			if err != nil {
+				d.lc.Errorf("Error masking frame of device %s: %v", deviceName, err)
+			}
+			d.writeStream(deviceName, StreamRaw, raw)
+		}
 
 		// skip process if image is empty
 		if img.Empty() {
 			d.lc.Errorf("Image is empty")
 			img.Close()
-			img_resized.Close()
 			continue
 		}
-		imgHeight, imgWidth := img.Rows(), img.Cols()
-		d.lc.Debugf("Image size: %d x %d", imgWidth, imgHeight)
+		d.lc.Debugf("Image size: %d x %d", img.Cols(), img.Rows())
+		d.setCaptureState(deviceName, CaptureRunning)
+
+		// frames not sampled, or read while all inferences are in flight, only go to the live stream
+		if sampler.Enabled() {
+			collect()
+			sampled := sampler.Sample(img, time.Now(), len(pending) < depth)
+			if samplingConfig.Strategy == SkipMotion {
+				d.setMotionState(deviceName, sampler.Motion(time.Now()))
+			}
+			if !sampled {
+				if time.Since(overlayTime) > overlayHold {
+					overlay = nil
+				}
+				if !masker.Withholds() {
+					d.maskFrame(deviceName, masker, &img, nil)
+					d.writeOverlay(deviceName, renderer, img, overlay)
+				}
+				img.Close()
+				continue
+			}
+		}
 
-		// predict image
-		inferResponse, err := d.Predict(grpcClient, img, model, version, inputWidth, inputHeight, inputName)
-		if err != nil {
-			d.lc.Debugf("Error predicting: %s", err)
-			img.Close()
-			img_resized.Close()
+		// predict image in the background, results are processed in capture order
+		frame := &inferFrame{
+			img:    img,
+			start:  time_start_inference,
+			result: make(chan inferFrameResult, 1),
+		}
+		go func(frame *inferFrame) {
+			var inferResponse *InferResponse
+			var err error
+			sent := time.Now()
+			if batcher != nil {
+				inferResponse, err = d.PredictBatched(batcher, backend, frame.img, input)
+			} else {
+				inferResponse, err = d.Predict(backend, frame.img, model, version, input, policy.InferTimeout)
+			}
+			latency := time.Since(sent)
+			if err == nil {
+				stats.Inferred.Add(1)
+				d.metrics.inferLatency.WithLabelValues(deviceName).Observe(latency.Seconds())
+			} else {
+				d.metrics.inferError(deviceName, err)
+			}
+			frame.result <- inferFrameResult{response: inferResponse, err: err, latency: latency}
+		}(frame)
+		pending = append(pending, frame)
+		if sampler.Enabled() || len(pending) < depth {
 			continue
 		}
 
-		time_end_inference := time.Now()
-		inferTime := time_end_inference.Sub(time_start_inference)
-		d.lc.Debugf("Object Detection Inference time: %s", inferTime)
+		frame = pending[0]
+		pending = pending[1:]
+		finish(frame, <-frame.result)
+	}
+}
 
-		modelOutputs := inferResponse.Outputs
-		var inferResult = make(map[string][]ObjectDetectionResutl)
-		for i, modelOutput := range modelOutputs {
-			rawOutputContent := inferResponse.RawOutputContents[i]
-			outLen := len(rawOutputContent)
-			outShapre := modelOutput.Shape
-			outName := modelOutput.Name
-			d.lc.Debugf("Inference reuslt, Name: %s, Datatype: %s, Shape: %d, Data len: %d", outName, modelOutput.Datatype, outShapre, outLen)
+// write a frame skipped by sampling to the annotated stream, with the detections of the last inferred frame
+func (d *Driver) writeOverlay(deviceName string, renderer *overlayRenderer, img gocv.Mat, detections []ObjectDetectionResutl) {
+	for i, row := range detections {
+		renderer.DrawDetection(&img, row, i)
+	}
+	d.writeStream(deviceName, StreamAnnotated, img)
+}
 
-			inferResult[modelOutput.Name] = d.readData(rawOutputContent, outShapre)
-		}
+// mask a frame in place with its detections before it leaves the device, logging errors
+func (d *Driver) maskFrame(deviceName string, masker *privacyMasker, img *gocv.Mat, detections []ObjectDetectionResutl) {
+	if err := masker.Mask(img, detections); err != nil {
+		d.lc.Errorf("Error masking frame of device %s: %v", deviceName, err)
+	}
+}
 
-		// write original image to base64 string
-		var base64StrOri string = ""
-		if snapshot == "true" {
-			base64StrOri = "data:image/jpeg;base64,"
-			image_bytes, _ := gocv.IMEncode(gocv.JPEGFileExt, img)
-			imgBytes := image_bytes.GetBytes()
-			base64StrOri += base64.StdEncoding.EncodeToString(imgBytes)
-			image_bytes.Close()
-		}
+// write a frame to a live stream of a device, logging errors
+func (d *Driver) writeStream(deviceName string, variant string, img gocv.Mat) {
+	if err := d.WriteStream(deviceName, variant, img); err != nil {
+		d.lc.Errorf("Error updating %s stream of device %s: %v", variant, deviceName, err)
+	}
+}
 
-		var matched bool = false
-
-		// // draw results on image
-		for _, result := range inferResult {
-
-			for _, row := range result {
-				inferScore := row.Confidence
-				if inferScore > score {
-					matched = true
-					scores = append(scores, float32(math.Round(float64(inferScore)*100)/100))
-
-					// set face bounding box
-					x_min := int(row.X_min * float32(imgWidth))
-					if x_min < 0 {
-						x_min = 0
-					}
-					y_min := int(row.Y_min * float32(imgHeight))
-					if y_min < 0 {
-						y_min = 0
-					}
-					x_max := int(row.X_max * float32(imgWidth))
-					if x_max > imgWidth {
-						x_max = imgWidth
-					}
-					y_max := int(row.Y_max * float32(imgHeight))
-					if y_max > imgHeight {
-						y_max = imgHeight
-					}
-					rect := image.Rect(x_min, y_min, x_max, y_max)
-					d.lc.Debugf("Cropped face size: %d x %d, rect: %d,%d %d,%d", x_max-x_min, y_max-y_min, x_min, y_min, x_max, y_max)
-
-					// draw rectangle
-					gocv.Rectangle(&img, rect, tipsColor, fontThinkness)
-
-					// put label text to image
-					score_str := fmt.Sprintf("%.2f", inferScore)
-					label_name := coco_classes[int(row.Label)]
-					gocv.PutText(
-						&img,
-						label_name+":"+score_str,
-						image.Point{X: x_min, Y: y_min - 5},
-						gocv.FontHersheyDuplex,
-						fontScale,
-						tipsColor,
-						fontThinkness)
+// processInferResponse masks and draws the detections of an inferred frame, and writes the results to stream and channel.
+// It returns the detections above the score.
+func (d *Driver) processInferResponse(deviceName string, frame *inferFrame, inferResponse *InferResponse, renderer *overlayRenderer, masker *privacyMasker, model string, score float32, snapshot string) []ObjectDetectionResutl {
 
-				}
-			}
+	// define input and output bytes
+	var scores []float32
+
+	img := frame.img
 
+	time_start_inference := frame.start
+	time_end_inference := time.Now()
+	inferTime := time_end_inference.Sub(time_start_inference)
+	d.lc.Debugf("Object Detection Inference time: %s", inferTime)
+
+	modelOutputs := inferResponse.Outputs
+	var inferResult = make(map[string][]ObjectDetectionResutl)
+	var inferred []ObjectDetectionResutl
+	for i, modelOutput := range modelOutputs {
+		tensor, err := DecodeOutputTensor(inferResponse, i)
+		if err != nil {
+			d.lc.Errorf("Error decoding inference result of device %s: %v", deviceName, err)
+			continue
 		}
+		d.lc.Debugf("Inference reuslt, Name: %s, Datatype: %s, Shape: %d, Data len: %d", tensor.Name, tensor.Datatype, tensor.Shape, len(tensor.Data))
 
-		if matched {
-
-			// put timestamp text to image
-			currentTime := time.Now().Format("2006-01-02 15:04:05")
-			gocv.PutText(
-				&img,
-				currentTime,
-				image.Point{X: 10, Y: 30},
-				fontStyle,
-				fontScale,
-				tipsColor,
-				fontThinkness)
-			infer_second := 1.0 / inferTime.Seconds()
-			infer_fps := fmt.Sprintf("%.1f", infer_second)
-			d.lc.Debugf("Inference FPS: %s", infer_fps)
-
-			// calculate inference time, put fps text to image
-			time_end_process := time.Now()
-			inferTime = time_end_process.Sub(time_start_inference)
-			infer_second = 1.0 / inferTime.Seconds()
-			infer_fps = fmt.Sprintf("%.1f", infer_second)
-			gocv.PutText(
-				&img, "fps: "+infer_fps,
-				image.Point{X: 10, Y: 50},
-				fontStyle,
-				fontScale,
-				tipsColor,
-				fontThinkness)
-			d.lc.Debugf("Total Inference time: %s, FPS: %s", inferTime, infer_fps)
-
-			// write infer snapshot to base64 string
-			base64Str := "data:image/jpeg;base64,"
-			image_bytes, err := gocv.IMEncode(gocv.JPEGFileExt, img)
-			if err != nil {
-				d.lc.Errorf("Error encoding image: %s", err)
-				img.Close()
-				continue
-			}
-			imgBytes := image_bytes.GetBytes()
-			base64Str += base64.StdEncoding.EncodeToString(imgBytes)
+		detections, err := d.readData(tensor)
+		if err != nil {
+			d.lc.Errorf("Error reading inference result of device %s: %v", deviceName, err)
+			continue
+		}
+		inferResult[modelOutput.Name] = detections
+		inferred = append(inferred, detections...)
+	}
 
-			// write to live stream
-			if err := d.WriteStream(deviceName, img); err != nil {
-				d.lc.Errorf("Error updating stream: %v", err)
-			}
+	// the frame was inferred unmasked, it is masked with its detections before it leaves the device
+	d.maskFrame(deviceName, masker, &img, inferred)
+	if masker.Withholds() {
+		d.writeStream(deviceName, StreamRaw, img)
+	}
 
-			// write ovms result to channel
-			ovmsResult := OVMSResult{
-				ModelName: model,
-				InferFPS:  infer_fps,
-				Snapshot:  base64Str,
-				Scores:    scores,
-				Original:  base64StrOri,
-			}
-			ch := d.ovmsCh[deviceName]
-			select {
-			case ch <- ovmsResult:
-			default:
-				d.lc.Debugf("OVMS channel is error, drop result.")
-			}
+	// write original image to base64 string
+	var base64StrOri string = ""
+	if snapshot == "true" {
+		base64StrOri = "data:image/jpeg;base64,"
+		image_bytes, _ := gocv.IMEncode(gocv.JPEGFileExt, img)
+		imgBytes := image_bytes.GetBytes()
+		base64StrOri += base64.StdEncoding.EncodeToString(imgBytes)
+		image_bytes.Close()
+	}
 
-			image_bytes.Close()
+	var matched []ObjectDetectionResutl
 
+	// // draw results on image
+	for _, result := range inferResult {
+
+		for _, row := range result {
+			inferScore := row.Confidence
+			if inferScore > score {
+				matched = append(matched, row)
+				d.metrics.detections.WithLabelValues(deviceName, labelName(row.Label)).Inc()
+				scores = append(scores, float32(math.Round(float64(inferScore)*100)/100))
+				renderer.DrawDetection(&img, row, len(matched)-1)
+			}
 		}
 
-		// close image Mat
-		img.Close()
-		img_resized.Close()
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
 
+	infer_second := 1.0 / inferTime.Seconds()
+	infer_fps := fmt.Sprintf("%.1f", infer_second)
+	d.lc.Debugf("Inference FPS: %s", infer_fps)
+
+	// calculate inference time, put timestamp and fps text to image
+	time_end_process := time.Now()
+	inferTime = time_end_process.Sub(time_start_inference)
+	infer_second = 1.0 / inferTime.Seconds()
+	infer_fps = fmt.Sprintf("%.1f", infer_second)
+	renderer.DrawInfo(&img, time_end_process, infer_fps)
+	d.lc.Debugf("Total Inference time: %s, FPS: %s", inferTime, infer_fps)
+
+	// write infer snapshot to base64 string
+	base64Str := "data:image/jpeg;base64,"
+	image_bytes, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+	if err != nil {
+		d.lc.Errorf("Error encoding image: %s", err)
+		return matched
 	}
+	defer image_bytes.Close()
+	imgBytes := image_bytes.GetBytes()
+	base64Str += base64.StdEncoding.EncodeToString(imgBytes)
+
+	// write to the events stream, the frames with detections only
+	d.writeStream(deviceName, StreamEvents, img)
+
+	// write ovms result to channel
+	ovmsResult := OVMSResult{
+		ModelName: model,
+		InferFPS:  infer_fps,
+		Snapshot:  base64Str,
+		Scores:    scores,
+		Original:  base64StrOri,
+	}
+	ch := d.resultChannel(deviceName)
+	select {
+	case ch <- ovmsResult:
+	default:
+		d.lc.Debugf("OVMS channel is error, drop result.")
+	}
+	return matched
 }
 
-// read rawOutputContent to ObjectDetectionResutl
-func (d *Driver) readData(inputBytes []byte, shape []int64) []ObjectDetectionResutl {
-	count := int(shape[2])
-	len := int(shape[3])
+// read an output tensor of [image_id, label, conf, x_min, y_min, x_max, y_max] rows to ObjectDetectionResutl
+func (d *Driver) readData(tensor *Tensor) ([]ObjectDetectionResutl, error) {
+	shape := tensor.Shape
+	if len(shape) < 2 || shape[len(shape)-1] < 7 {
+		return nil, fmt.Errorf("output '%s' of shape %v is not an object detection output", tensor.Name, shape)
+	}
+	rowLen := int(shape[len(shape)-1])
+	count := int(shape[len(shape)-2])
+	f32data := tensor.Data
+	if len(f32data) < count*rowLen {
+		return nil, fmt.Errorf("output '%s' has %d elements, expected %d for shape %v", tensor.Name, len(f32data), count*rowLen, shape)
+	}
 
 	var odrs []ObjectDetectionResutl
-	f32data := d.readRawDataToFloat32(inputBytes)
 	for i := 0; i < count; i++ {
 		odr := ObjectDetectionResutl{
-			ImageId:    f32data[i*len],
-			Label:      f32data[i*len+1],
-			Confidence: f32data[i*len+2],
-			X_min:      f32data[i*len+3],
-			Y_min:      f32data[i*len+4],
-			X_max:      f32data[i*len+5],
-			Y_max:      f32data[i*len+6],
+			ImageId:    f32data[i*rowLen],
+			Label:      f32data[i*rowLen+1],
+			Confidence: f32data[i*rowLen+2],
+			X_min:      f32data[i*rowLen+3],
+			Y_min:      f32data[i*rowLen+4],
+			X_max:      f32data[i*rowLen+5],
+			Y_max:      f32data[i*rowLen+6],
 		}
 		odrs = append(odrs, odr)
 	}
-	return odrs
+	return odrs, nil
 
 }
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadData(t *testing.T) {
+	d := newTestDriver(t)
+	detections := []ovmstest.Detection{
+		testDetection,
+		{Label: 1, Confidence: 0.4, XMin: 0.1, YMin: 0.2, XMax: 0.3, YMax: 0.4},
+	}
+
+	response, err := fromGRPCInferResponse(ovmstest.DetectionResponse(detections))
+	require.NoError(t, err)
+	tensor, err := DecodeOutputTensor(response, 0)
+	require.NoError(t, err)
+	results, err := d.readData(tensor)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for i, detection := range detections {
+		assert.Equal(t, ObjectDetectionResutl{
+			Label:      detection.Label,
+			Confidence: detection.Confidence,
+			X_min:      detection.XMin,
+			Y_min:      detection.YMin,
+			X_max:      detection.XMax,
+			Y_max:      detection.YMax,
+		}, results[i])
+	}
+
+	results, err = d.readData(&Tensor{Name: "detection_out", Shape: []int64{1, 1, 0, 7}})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = d.readData(&Tensor{Name: "detection_out", Shape: []int64{1, 1, 2, 7}, Data: make([]float32, 7)})
+	assert.Error(t, err)
+	_, err = d.readData(&Tensor{Name: "scores", Shape: []int64{1, 5}, Data: make([]float32, 5)})
+	assert.Error(t, err)
+}
+
+func TestProcessMjpegStream(t *testing.T) {
+	deviceName := "process-camera"
+
+	t.Run("result", func(t *testing.T) {
+		d := newTestDriver(t)
+		server := startFakeServer(t, d, deviceName)
+		d.NewStreamClient(deviceName, testProtocols()[Protocol])
+		d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, stop)
+		}()
+
+		select {
+		case result := <-d.ovmsCh[deviceName]:
+			assert.Equal(t, testModel, result.ModelName)
+			assert.Equal(t, []float32{0.9}, result.Scores)
+			assert.True(t, strings.HasPrefix(result.Snapshot, "data:image/jpeg;base64,"))
+			assert.True(t, strings.HasPrefix(result.Original, "data:image/jpeg;base64,"))
+		case <-time.After(5 * time.Second):
+			t.Fatal("no inference result")
+		}
+		assert.Equal(t, CaptureRunning, d.captureState(deviceName))
+		assert.NotZero(t, server.InferCount())
+
+		close(stop)
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("capture did not stop")
+		}
+	})
+
+	t.Run("below score", func(t *testing.T) {
+		d := newTestDriver(t)
+		d.frameSourceFactory = func(uri string) (FrameSource, error) {
+			return ovmstest.NewReplaySource(uri, false)
+		}
+		server := startFakeServer(t, d, deviceName)
+		server.SetDetections(ovmstest.Detection{Label: 3, Confidence: 0.3, XMax: 1, YMax: 1})
+		d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+
+		// every frame is inferred, the source ends after the last one
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+		assert.Error(t, err)
+		assert.EqualValues(t, 3, server.InferCount())
+		assert.Empty(t, d.ovmsCh[deviceName])
+	})
+
+	t.Run("model not ready", func(t *testing.T) {
+		d := newTestDriver(t)
+		server := startFakeServer(t, d, deviceName)
+		server.SetReady(false)
+
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+		assert.ErrorContains(t, err, "not ready")
+		assert.Zero(t, server.InferCount())
+	})
+
+	t.Run("unknown model", func(t *testing.T) {
+		d := newTestDriver(t)
+		startFakeServer(t, d, deviceName)
+		protocols := testProtocols()
+		protocols[Protocol]["Model"] = "unknown"
+
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+		assert.Error(t, err)
+	})
+
+	t.Run("source not found", func(t *testing.T) {
+		d := newTestDriver(t)
+		startFakeServer(t, d, deviceName)
+		protocols := testProtocols()
+		protocols[Protocol]["Uri"] = "does-not-exist"
+
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+		assert.Error(t, err)
+	})
+}
@@ -10,65 +10,443 @@
 package driver
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
 	"time"
 
 	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
 	"github.com/spf13/cast"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
-// Create GRPC client by 'Device' definition
-func (d *Driver) NewGRPCClient(deviceName string, protocol map[string]models.ProtocolProperties) (*grpc_client.GRPCInferenceServiceClient, error) {
-	d.lc.Debugf("Creating new GRPC client for device %s", deviceName)
+// Create inference backend by 'Device' definition
+func (d *Driver) NewInferenceBackend(deviceName string, protocol map[string]models.ProtocolProperties) (InferenceBackend, error) {
+	d.lc.Debugf("Creating new inference backend for device %s", deviceName)
 
 	proto := protocol[Protocol]
 
 	host, _ := cast.ToStringE(proto["Host"])
 	port, _ := cast.ToStringE(proto["Port"])
+	endpoints, _ := cast.ToStringE(proto[Endpoints])
+	balancing, _ := cast.ToStringE(proto[Balancing])
+	transport, _ := cast.ToStringE(proto[Transport])
 
-	// Connect to gRPC server with retry, default max retry times is 3, retry interval is 3 seconds
-	var conn *grpc.ClientConn
-	var err error
-	for i := 0; i < 3; i++ {
-		conn, err = grpc.Dial(host+":"+port, grpc.WithInsecure())
+	addresses, err := parseEndpoints(host, port, endpoints)
+	if err != nil {
+		return nil, err
+	}
+	balancing, err = parseBalancing(balancing)
+	if err != nil {
+		return nil, err
+	}
+	transport, err = parseTransport(transport)
+	if err != nil {
+		return nil, err
+	}
+	streamInfer, _ := cast.ToBoolE(proto[StreamInfer])
+	if streamInfer && transport == TransportREST {
+		return nil, fmt.Errorf("'%s' is not supported with the '%s' transport", StreamInfer, TransportREST)
+	}
+
+	policy, err := d.DevicePolicy(proto)
+	if err != nil {
+		return nil, err
+	}
+
+	// use TLS transport credentials if enabled, otherwise plaintext
+	tlsInfo, err := parseTLSInfo(proto)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := d.NewTLSConfig(tlsInfo)
+	if err != nil {
+		d.lc.Errorf("Failed to load TLS config for device %s: %v", deviceName, err)
+		return nil, err
+	}
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	// Devices targeting the same endpoint with the same TLS settings share one connection
+	var keys []string
+	var backends []InferenceBackend
+	for _, endpoint := range addresses {
+		if transport == TransportREST {
+			backends = append(backends, newRESTBackend(endpoint, tlsConfig))
+			continue
+		}
+		key := grpcConnKey(endpoint, tlsInfo)
+		conn, err := d.grpcPool.Acquire(key, endpoint, func() (*grpc.ClientConn, error) {
+			return d.dialGRPC(endpoint, creds, policy.Dial, policy.MetadataTimeout)
+		})
+		if err != nil {
+			d.lc.Errorf("Failed to connect after %d retries: %v", policy.Dial.MaxAttempts, err)
+			for _, key := range keys {
+				d.grpcPool.Release(key)
+			}
+			return nil, err
+		}
+		keys = append(keys, key)
+		backends = append(backends, newGRPCBackend(grpc_client.NewGRPCInferenceServiceClient(conn)))
+	}
+
+	// balance over all endpoints if more than one
+	backend := backends[0]
+	var group *endpointGroup
+	if len(backends) > 1 {
+		group = newEndpointGroup(d.lc, balancing, addresses, backends)
+		backend = group
+		d.lc.Infof("Device %s balances over %d OVMS endpoints (%s)", deviceName, len(addresses), balancing)
+	}
+	if transport == TransportREST {
+		d.lc.Infof("Device %s uses the KServe REST API of OVMS", deviceName)
+	}
+
+	// optionally keep a long-lived inference stream instead of unary calls
+	var stream *streamInferClient
+	if streamInfer {
+		inflight, _ := cast.ToIntE(proto[StreamInflight])
+		stream = newStreamInferClient(d.lc, deviceName, backend.(streamBackend), inflight)
+		backend = stream
+		d.lc.Infof("Device %s uses streaming inference with %d frame(s) in flight", deviceName, stream.Inflight())
+	}
+	d.lc.Debugf("Inference backend of device %s: %T", deviceName, backend)
+
+	// drop the connections previously held by this device
+	d.releaseGRPCClient(deviceName)
+	d.grpcConnKeys[deviceName] = keys
+	if group != nil {
+		d.endpointGroups[deviceName] = group
+	}
+	if stream != nil {
+		d.streamClients[deviceName] = stream
+	}
+	d.backends[deviceName] = backend
+
+	return backend, nil
+}
+
+// Connect to gRPC server with retry, by default 3 attempts 3 seconds apart, an attempt
+// waits up to 'timeout' for the connection to be ready
+func (d *Driver) dialGRPC(endpoint string, creds credentials.TransportCredentials, retry RetryPolicy, timeout time.Duration) (*grpc.ClientConn, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := connectGRPC(endpoint, creds, timeout)
 		if err == nil {
-			break
+			return conn, nil
+		}
+		if retry.Exhausted(attempt) {
+			return nil, err
+		}
+		d.lc.Errorf("Couldn't connect to endpoint %s (attempt %s): %v", endpoint, retry.Progress(attempt), err)
+		time.Sleep(retry.Delay(attempt))
+	}
+}
+
+// open a connection to 'endpoint' and wait until it is ready, it is closed when not ready in time
+func connectGRPC(endpoint string, creds credentials.TransportCredentials, timeout time.Duration) (*grpc.ClientConn, error) {
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn.Connect()
+	for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, state) {
+			conn.Close()
+			return nil, status.Errorf(codes.Unavailable, "connection to %s not ready after %s, last state %s", endpoint, timeout, state)
+		}
+	}
+	return conn, nil
+}
+
+// Release the shared connections, inference stream and endpoint group held by 'DeviceName'
+func (d *Driver) releaseGRPCClient(deviceName string) {
+	d.detachGRPCClient(deviceName)()
+}
+
+// Detach the shared connections, inference stream and endpoint group held by 'DeviceName'
+// from the driver, they are closed by the returned function
+func (d *Driver) detachGRPCClient(deviceName string) func() {
+	stream := d.streamClients[deviceName]
+	group := d.endpointGroups[deviceName]
+	keys := d.grpcConnKeys[deviceName]
+	delete(d.streamClients, deviceName)
+	delete(d.endpointGroups, deviceName)
+	delete(d.grpcConnKeys, deviceName)
+	pool := d.grpcPool
+	return func() {
+		if stream != nil {
+			stream.Close()
+		}
+		if group != nil {
+			group.Close()
 		}
-		d.lc.Errorf("Couldn't connect to endpoint %s: %v", host+":"+port, err)
-		time.Sleep(time.Second * 3) // retry interval 3 seconds
+		// close the shared connections only when no other device uses them
+		for _, key := range keys {
+			pool.Release(key)
+		}
+	}
+}
+
+// Get InferenceBackend by 'DeviceName'
+func (d *Driver) GetInferenceBackend(deviceName string, protocols map[string]models.ProtocolProperties) (InferenceBackend, error) {
+	d.lc.Debugf("Getting inference backend for device: %s", deviceName)
+
+	backend := d.backends[deviceName]
+
+	if backend != nil {
+		return backend, nil
 	}
+
+	d.lc.Warnf("Inference backend for device %s not found. Creating it...", deviceName)
+	backend, err := d.NewInferenceBackend(deviceName, protocols)
 	if err != nil {
-		d.lc.Errorf("Failed to connect after 3 retries: %v", err)
 		return nil, err
 	}
 
-	// Create client from gRPC server connection
-	client := grpc_client.NewGRPCInferenceServiceClient(conn)
-	d.lc.Debugf("Grpc info: %s", client)
+	return backend, nil
+}
 
-	d.grpcConns[deviceName] = conn
-	d.grpcServers[deviceName] = &client
+// grpcBackend is the InferenceBackend of a KServe v2 gRPC client, converting the driver
+// tensors to the typed contents of the protocol
+type grpcBackend struct {
+	client grpc_client.GRPCInferenceServiceClient
+}
 
-	return &client, nil
+func newGRPCBackend(client grpc_client.GRPCInferenceServiceClient) *grpcBackend {
+	return &grpcBackend{client: client}
 }
 
-// Get GrpcClient by 'DeviceName'
-func (d *Driver) GetGRPCClient(deviceName string, protocols map[string]models.ProtocolProperties) (*grpc_client.GRPCInferenceServiceClient, error) {
-	d.lc.Debugf("Getting GRPC client for device: %s", deviceName)
+func (b *grpcBackend) ServerReady(ctx context.Context) (bool, error) {
+	resp, err := b.client.ServerReady(ctx, &grpc_client.ServerReadyRequest{})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetReady(), nil
+}
 
-	grpcClient := d.grpcServers[deviceName]
+func (b *grpcBackend) ModelReady(ctx context.Context, model string, version string) (bool, error) {
+	resp, err := b.client.ModelReady(ctx, &grpc_client.ModelReadyRequest{Name: model, Version: version})
+	if err != nil {
+		return false, err
+	}
+	return resp.GetReady(), nil
+}
 
-	if grpcClient != nil {
-		return grpcClient, nil
+func (b *grpcBackend) ModelMetadata(ctx context.Context, model string, version string) (*ModelMetadata, error) {
+	resp, err := b.client.ModelMetadata(ctx, &grpc_client.ModelMetadataRequest{Name: model, Version: version})
+	if err != nil {
+		return nil, err
 	}
+	return fromGRPCModelMetadata(resp), nil
+}
 
-	d.lc.Warnf("GRPCClient for device %s not found. Creating it...", deviceName)
-	grpcClient, err := d.NewGRPCClient(deviceName, protocols)
+func (b *grpcBackend) ModelInfer(ctx context.Context, request *InferRequest) (*InferResponse, error) {
+	in, err := toGRPCInferRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.ModelInfer(ctx, in)
 	if err != nil {
 		return nil, err
 	}
-	d.grpcServers[deviceName] = grpcClient
+	return fromGRPCInferResponse(resp)
+}
+
+// ModelStreamInfer opens a bidirectional inference stream
+func (b *grpcBackend) ModelStreamInfer(ctx context.Context) (grpc_client.GRPCInferenceService_ModelStreamInferClient, error) {
+	return b.client.ModelStreamInfer(ctx)
+}
+
+func fromGRPCModelMetadata(resp *grpc_client.ModelMetadataResponse) *ModelMetadata {
+	toTensors := func(tensors []*grpc_client.ModelMetadataResponse_TensorMetadata) []*TensorMetadata {
+		var out []*TensorMetadata
+		for _, tensor := range tensors {
+			out = append(out, &TensorMetadata{Name: tensor.GetName(), Datatype: tensor.GetDatatype(), Shape: tensor.GetShape()})
+		}
+		return out
+	}
+	return &ModelMetadata{
+		Name:     resp.GetName(),
+		Versions: resp.GetVersions(),
+		Platform: resp.GetPlatform(),
+		Inputs:   toTensors(resp.GetInputs()),
+		Outputs:  toTensors(resp.GetOutputs()),
+	}
+}
+
+// convert an inference request, the inputs are sent as typed contents
+func toGRPCInferRequest(request *InferRequest) (*grpc_client.ModelInferRequest, error) {
+	in := &grpc_client.ModelInferRequest{
+		Id:           request.Id,
+		ModelName:    request.ModelName,
+		ModelVersion: request.ModelVersion,
+	}
+	for _, input := range request.Inputs {
+		contents, err := decodeTensorData(input.Datatype, input.Data)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "input '%s': %v", input.Name, err)
+		}
+		in.Inputs = append(in.Inputs, &grpc_client.ModelInferRequest_InferInputTensor{
+			Name:     input.Name,
+			Datatype: input.Datatype,
+			Shape:    input.Shape,
+			Contents: contents,
+		})
+	}
+	return in, nil
+}
+
+// convert an inference response, from raw_output_contents when the response has them
+// or else from the typed contents of the outputs
+func fromGRPCInferResponse(resp *grpc_client.ModelInferResponse) (*InferResponse, error) {
+	response := &InferResponse{
+		Id:           resp.GetId(),
+		ModelName:    resp.GetModelName(),
+		ModelVersion: resp.GetModelVersion(),
+	}
+	outputs := resp.GetOutputs()
+	raws := resp.GetRawOutputContents()
+	// raw contents are either used for all outputs or for none
+	if len(raws) > 0 && len(raws) != len(outputs) {
+		return nil, status.Errorf(codes.Internal, "%d raw output contents for %d outputs", len(raws), len(outputs))
+	}
+	for i, output := range outputs {
+		tensor := &InferTensor{
+			Name:     output.GetName(),
+			Datatype: output.GetDatatype(),
+			Shape:    output.GetShape(),
+		}
+		if len(raws) > 0 {
+			tensor.Data = raws[i]
+		} else {
+			// there is no half precision field, FP16 contents are sent as fp32_contents
+			if tensor.Datatype == "FP16" {
+				tensor.Datatype = "FP32"
+			}
+			data, err := encodeTensorContents(tensor.Datatype, output.GetContents())
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "output '%s': %v", tensor.Name, err)
+			}
+			tensor.Data = data
+		}
+		response.Outputs = append(response.Outputs, tensor)
+	}
+	return response, nil
+}
+
+// encode typed tensor contents to the little endian binary representation
+func encodeTensorContents(datatype string, contents *grpc_client.InferTensorContents) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch datatype {
+	case "BYTES":
+		// each element is prefixed with its 4 bytes length
+		for _, element := range contents.GetBytesContents() {
+			binary.Write(&buf, binary.LittleEndian, uint32(len(element)))
+			buf.Write(element)
+		}
+	case "BOOL":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetBoolContents())
+	case "INT8":
+		for _, v := range contents.GetIntContents() {
+			buf.WriteByte(byte(int8(v)))
+		}
+	case "INT16":
+		for _, v := range contents.GetIntContents() {
+			err = binary.Write(&buf, binary.LittleEndian, int16(v))
+		}
+	case "INT32":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetIntContents())
+	case "INT64":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetInt64Contents())
+	case "UINT8":
+		for _, v := range contents.GetUintContents() {
+			buf.WriteByte(byte(v))
+		}
+	case "UINT16":
+		for _, v := range contents.GetUintContents() {
+			err = binary.Write(&buf, binary.LittleEndian, uint16(v))
+		}
+	case "UINT32":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetUintContents())
+	case "UINT64":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetUint64Contents())
+	case "FP32":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetFp32Contents())
+	case "FP64":
+		err = binary.Write(&buf, binary.LittleEndian, contents.GetFp64Contents())
+	default:
+		return nil, fmt.Errorf("unsupported datatype '%s'", datatype)
+	}
+	return buf.Bytes(), err
+}
+
+// decode the little endian data of a tensor to typed contents, the field follows the datatype
+// as in the KServe v2 protocol: int_contents for INT8 to INT32, uint_contents for UINT8 to UINT32
+func decodeTensorData(datatype string, data []byte) (*grpc_client.InferTensorContents, error) {
+	contents := &grpc_client.InferTensorContents{}
+	if datatype == "BYTES" {
+		for len(data) > 0 {
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated BYTES element length")
+			}
+			length := binary.LittleEndian.Uint32(data)
+			if uint64(length) > uint64(len(data)-4) {
+				return nil, fmt.Errorf("BYTES element of %d bytes exceeds the tensor data", length)
+			}
+			contents.BytesContents = append(contents.BytesContents, data[4:4+length])
+			data = data[4+length:]
+		}
+		return contents, nil
+	}
 
-	return grpcClient, nil
+	size, err := datatypeSize(datatype)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("%d bytes of %s data are not a whole number of elements", len(data), datatype)
+	}
+	for i := 0; i < len(data); i += size {
+		b := data[i : i+size]
+		switch datatype {
+		case "BOOL":
+			contents.BoolContents = append(contents.BoolContents, b[0] != 0)
+		case "INT8":
+			contents.IntContents = append(contents.IntContents, int32(int8(b[0])))
+		case "INT16":
+			contents.IntContents = append(contents.IntContents, int32(int16(binary.LittleEndian.Uint16(b))))
+		case "INT32":
+			contents.IntContents = append(contents.IntContents, int32(binary.LittleEndian.Uint32(b)))
+		case "INT64":
+			contents.Int64Contents = append(contents.Int64Contents, int64(binary.LittleEndian.Uint64(b)))
+		case "UINT8":
+			contents.UintContents = append(contents.UintContents, uint32(b[0]))
+		case "UINT16":
+			contents.UintContents = append(contents.UintContents, uint32(binary.LittleEndian.Uint16(b)))
+		case "UINT32":
+			contents.UintContents = append(contents.UintContents, binary.LittleEndian.Uint32(b))
+		case "UINT64":
+			contents.Uint64Contents = append(contents.Uint64Contents, binary.LittleEndian.Uint64(b))
+		case "FP32":
+			contents.Fp32Contents = append(contents.Fp32Contents, math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case "FP64":
+			contents.Fp64Contents = append(contents.Fp64Contents, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		default:
+			return nil, fmt.Errorf("unsupported datatype '%s'", datatype)
+		}
+	}
+	return contents, nil
 }
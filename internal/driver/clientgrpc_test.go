@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// a BYTES input of encoded images
+func bytesInput(name string, images ...string) []*InferTensor {
+	var data []byte
+	for _, image := range images {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(image)))
+		data = append(data, image...)
+	}
+	return []*InferTensor{
+		{Name: name, Datatype: "BYTES", Shape: []int64{int64(len(images))}, Data: data},
+	}
+}
+
+func TestTensorDataContents(t *testing.T) {
+	contents := []struct {
+		datatype string
+		contents *grpc_client.InferTensorContents
+	}{
+		{"BYTES", &grpc_client.InferTensorContents{BytesContents: [][]byte{[]byte("jpeg"), {}, []byte("png")}}},
+		{"BOOL", &grpc_client.InferTensorContents{BoolContents: []bool{true, false}}},
+		{"INT8", &grpc_client.InferTensorContents{IntContents: []int32{-128, 127}}},
+		{"INT16", &grpc_client.InferTensorContents{IntContents: []int32{-300, 300}}},
+		{"INT32", &grpc_client.InferTensorContents{IntContents: []int32{-70000, 70000}}},
+		{"INT64", &grpc_client.InferTensorContents{Int64Contents: []int64{-1 << 40, 1 << 40}}},
+		{"UINT8", &grpc_client.InferTensorContents{UintContents: []uint32{0, 255}}},
+		{"UINT16", &grpc_client.InferTensorContents{UintContents: []uint32{0, 65535}}},
+		{"UINT32", &grpc_client.InferTensorContents{UintContents: []uint32{0, 1 << 31}}},
+		{"UINT64", &grpc_client.InferTensorContents{Uint64Contents: []uint64{0, 1 << 63}}},
+		{"FP32", &grpc_client.InferTensorContents{Fp32Contents: []float32{-0.5, 3.25}}},
+		{"FP64", &grpc_client.InferTensorContents{Fp64Contents: []float64{-0.5, 1e100}}},
+	}
+	for _, c := range contents {
+		// the binary representation of the driver tensors and the typed contents of gRPC convert both ways
+		data, err := encodeTensorContents(c.datatype, c.contents)
+		require.NoError(t, err, c.datatype)
+		decoded, err := decodeTensorData(c.datatype, data)
+		require.NoError(t, err, c.datatype)
+		assert.Equal(t, c.contents.String(), decoded.String(), c.datatype)
+	}
+
+	_, err := decodeTensorData("BYTES", []byte{8, 0, 0, 0, 1})
+	assert.ErrorContains(t, err, "exceeds the tensor data")
+	_, err = decodeTensorData("BYTES", []byte{8, 0})
+	assert.ErrorContains(t, err, "truncated")
+	_, err = decodeTensorData("FP32", make([]byte, 6))
+	assert.ErrorContains(t, err, "whole number of elements")
+	_, err = decodeTensorData("FP16", make([]byte, 2))
+	assert.ErrorContains(t, err, "unsupported datatype")
+
+	// a request that cannot be converted is invalid
+	_, err = toGRPCInferRequest(&InferRequest{Inputs: []*InferTensor{{Name: "image", Datatype: "FP32", Data: make([]byte, 3)}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.ErrorContains(t, err, "input 'image'")
+}
+
+func TestGRPCBackend(t *testing.T) {
+	server := ovmstest.NewServer(testModel)
+	server.Inputs = append(server.Inputs, grpcTensorMetadata("image_info", "FP32", 1, 3))
+	server.SetDetections(testDetection)
+	server.Start()
+	t.Cleanup(server.Stop)
+	conn, err := server.Dial()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	backend := newGRPCBackend(grpc_client.NewGRPCInferenceServiceClient(conn))
+	ctx := context.Background()
+
+	ready, err := backend.ServerReady(ctx)
+	require.NoError(t, err)
+	assert.True(t, ready)
+	ready, err = backend.ModelReady(ctx, testModel, "1")
+	require.NoError(t, err)
+	assert.True(t, ready)
+
+	metadata, err := backend.ModelMetadata(ctx, testModel, "1")
+	require.NoError(t, err)
+	assert.Equal(t, []*TensorMetadata{
+		{Name: "image_tensor", Datatype: "BYTES", Shape: []int64{1, 300, 300, 3}},
+		{Name: "image_info", Datatype: "FP32", Shape: []int64{1, 3}},
+	}, metadata.Inputs)
+	assert.Equal(t, "detection_out", metadata.Outputs[0].Name)
+	_, err = backend.ModelMetadata(ctx, "unknown", "1")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the inputs are sent as typed contents, the raw outputs are returned as is
+	response, err := backend.ModelInfer(ctx, &InferRequest{
+		ModelName:    testModel,
+		ModelVersion: "1",
+		Inputs: append(bytesInput("image_tensor", "jpeg"),
+			&InferTensor{Name: "image_info", Datatype: "FP32", Shape: []int64{1, 3}, Data: encodeFP32([]float32{300, 300, 1})}),
+	})
+	require.NoError(t, err)
+	request := server.LastRequest()
+	assert.Equal(t, [][]byte{[]byte("jpeg")}, request.Inputs[0].Contents.BytesContents)
+	assert.Equal(t, []float32{300, 300, 1}, request.Inputs[1].Contents.Fp32Contents)
+	assert.Equal(t, testModel, response.ModelName)
+	require.Len(t, response.Outputs, 1)
+	assert.Equal(t, ovmstest.EncodeDetections([]ovmstest.Detection{testDetection}), response.Outputs[0].Data)
+}
+
+func TestDialGRPC(t *testing.T) {
+	d := newTestDriver(t)
+	retry := RetryPolicy{MaxAttempts: 2, Interval: 10 * time.Millisecond}
+
+	// a connection is returned once ready
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := ovmstest.NewServer(testModel)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	conn, err := d.dialGRPC(lis.Addr().String(), insecure.NewCredentials(), retry, 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, connectivity.Ready, conn.GetState())
+	_ = conn.Close()
+
+	// an endpoint nobody listens on fails after the last attempt
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := closed.Addr().String()
+	require.NoError(t, closed.Close())
+	start := time.Now()
+	conn, err = d.dialGRPC(address, insecure.NewCredentials(), retry, 100*time.Millisecond)
+	assert.Nil(t, conn)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
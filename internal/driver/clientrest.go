@@ -0,0 +1,380 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// header of the KServe binary tensor extension, length of the JSON part of the body
+const inferHeaderContentLength = "Inference-Header-Content-Length"
+
+// restBackend is the InferenceBackend of the KServe v2 REST API of OVMS. Tensors are sent
+// with the binary tensor extension, HTTP failures are mapped to gRPC status errors.
+type restBackend struct {
+	baseURL string
+	client  *http.Client
+}
+
+type restTensorMetadata struct {
+	Name     string  `json:"name"`
+	Datatype string  `json:"datatype"`
+	Shape    []int64 `json:"shape"`
+}
+
+type restModelMetadata struct {
+	Name     string               `json:"name"`
+	Versions []string             `json:"versions"`
+	Platform string               `json:"platform"`
+	Inputs   []restTensorMetadata `json:"inputs"`
+	Outputs  []restTensorMetadata `json:"outputs"`
+}
+
+type restTensor struct {
+	Name       string                 `json:"name"`
+	Datatype   string                 `json:"datatype,omitempty"`
+	Shape      []int64                `json:"shape,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Data       json.RawMessage        `json:"data,omitempty"`
+}
+
+type restInferRequest struct {
+	Id         string                 `json:"id,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Inputs     []restTensor           `json:"inputs"`
+}
+
+type restInferResponse struct {
+	ModelName    string       `json:"model_name"`
+	ModelVersion string       `json:"model_version"`
+	Id           string       `json:"id"`
+	Outputs      []restTensor `json:"outputs"`
+}
+
+type restError struct {
+	Error string `json:"error"`
+}
+
+// validate transport, gRPC is the default
+func parseTransport(transport string) (string, error) {
+	switch transport {
+	case "":
+		return TransportGRPC, nil
+	case TransportGRPC, TransportREST:
+		return transport, nil
+	default:
+		return "", fmt.Errorf("invalid value for '%s', please configure '%s' or '%s'", Transport, TransportGRPC, TransportREST)
+	}
+}
+
+func newRESTBackend(address string, tlsConfig *tls.Config) *restBackend {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		scheme = "https"
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &restBackend{
+		baseURL: scheme + "://" + address,
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+// path of a model, with version if specified
+func modelPath(name string, version string) string {
+	path := "/v2/models/" + url.PathEscape(name)
+	if version != "" {
+		path += "/versions/" + url.PathEscape(version)
+	}
+	return path
+}
+
+// map HTTP failures to gRPC status codes
+func restStatusError(code int, body []byte) error {
+	var restErr restError
+	message := string(body)
+	if json.Unmarshal(body, &restErr) == nil && restErr.Error != "" {
+		message = restErr.Error
+	}
+
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, message)
+	case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return status.Error(codes.Unavailable, message)
+	default:
+		return status.Errorf(codes.Internal, "HTTP %d: %s", code, message)
+	}
+}
+
+// map a failed round trip to a gRPC status error
+func restTransportError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// send request and return the response body and headers, 'body' is a binary tensor request
+// whose first 'headerLength' bytes are JSON
+func (c *restBackend) do(ctx context.Context, method string, path string, body []byte, headerLength int) ([]byte, http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, nil, status.Error(codes.Internal, err.Error())
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set(inferHeaderContentLength, strconv.Itoa(headerLength))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, restTransportError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, restTransportError(ctx, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, restStatusError(resp.StatusCode, data)
+	}
+	return data, resp.Header, nil
+}
+
+// readiness endpoints answer 200 when ready, any other answer means not ready
+func (c *restBackend) ready(ctx context.Context, path string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, restTransportError(ctx, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+func (c *restBackend) ServerReady(ctx context.Context) (bool, error) {
+	return c.ready(ctx, "/v2/health/ready")
+}
+
+func (c *restBackend) ModelReady(ctx context.Context, model string, version string) (bool, error) {
+	return c.ready(ctx, modelPath(model, version)+"/ready")
+}
+
+func (c *restBackend) ModelMetadata(ctx context.Context, model string, version string) (*ModelMetadata, error) {
+	data, _, err := c.do(ctx, http.MethodGet, modelPath(model, version), nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var metadata restModelMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid model metadata: %v", err)
+	}
+
+	toTensors := func(tensors []restTensorMetadata) []*TensorMetadata {
+		var out []*TensorMetadata
+		for _, tensor := range tensors {
+			out = append(out, &TensorMetadata{Name: tensor.Name, Datatype: tensor.Datatype, Shape: tensor.Shape})
+		}
+		return out
+	}
+	return &ModelMetadata{
+		Name:     metadata.Name,
+		Versions: metadata.Versions,
+		Platform: metadata.Platform,
+		Inputs:   toTensors(metadata.Inputs),
+		Outputs:  toTensors(metadata.Outputs),
+	}, nil
+}
+
+// ModelInfer sends inputs with the binary tensor extension and accepts binary or JSON outputs
+func (c *restBackend) ModelInfer(ctx context.Context, in *InferRequest) (*InferResponse, error) {
+	request := restInferRequest{
+		Id:         in.Id,
+		Parameters: map[string]interface{}{"binary_data_output": true},
+	}
+
+	var binaryData bytes.Buffer
+	for _, input := range in.Inputs {
+		request.Inputs = append(request.Inputs, restTensor{
+			Name:       input.Name,
+			Datatype:   input.Datatype,
+			Shape:      input.Shape,
+			Parameters: map[string]interface{}{"binary_data_size": len(input.Data)},
+		})
+		binaryData.Write(input.Data)
+	}
+
+	header, err := json.Marshal(request)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	body := append(header, binaryData.Bytes()...)
+
+	data, headers, err := c.do(ctx, http.MethodPost, modelPath(in.ModelName, in.ModelVersion)+"/infer", body, len(header))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRESTInferResponse(data, headers.Get(inferHeaderContentLength))
+}
+
+// decode an inference response, binary outputs follow the JSON header in output order
+func decodeRESTInferResponse(data []byte, headerLength string) (*InferResponse, error) {
+	jsonLength := len(data)
+	if headerLength != "" {
+		length, err := strconv.Atoi(headerLength)
+		if err != nil || length < 0 || length > len(data) {
+			return nil, status.Errorf(codes.Internal, "invalid %s '%s'", inferHeaderContentLength, headerLength)
+		}
+		jsonLength = length
+	}
+
+	var response restInferResponse
+	if err := json.Unmarshal(data[:jsonLength], &response); err != nil {
+		return nil, status.Errorf(codes.Internal, "invalid inference response: %v", err)
+	}
+
+	inferResponse := &InferResponse{
+		ModelName:    response.ModelName,
+		ModelVersion: response.ModelVersion,
+		Id:           response.Id,
+	}
+	binaryData := data[jsonLength:]
+	for _, output := range response.Outputs {
+		tensor := &InferTensor{
+			Name:     output.Name,
+			Datatype: output.Datatype,
+			Shape:    output.Shape,
+		}
+
+		var raw []byte
+		if size, ok := output.Parameters["binary_data_size"]; ok {
+			length, ok := size.(float64)
+			if !ok || int(length) < 0 || int(length) > len(binaryData) {
+				return nil, status.Errorf(codes.Internal, "invalid binary_data_size of output '%s'", output.Name)
+			}
+			raw = binaryData[:int(length)]
+			binaryData = binaryData[int(length):]
+		} else {
+			var err error
+			raw, err = encodeJSONData(output.Datatype, output.Data)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "output '%s': %v", output.Name, err)
+			}
+			// JSON numbers of half precision outputs are widened to FP32
+			if output.Datatype == "FP16" {
+				tensor.Datatype = "FP32"
+			}
+		}
+
+		tensor.Data = raw
+		inferResponse.Outputs = append(inferResponse.Outputs, tensor)
+	}
+	return inferResponse, nil
+}
+
+// flatten nested JSON arrays of an output tensor
+func flattenJSONData(value interface{}, out []interface{}) []interface{} {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			out = flattenJSONData(v, out)
+		}
+		return out
+	}
+	return append(out, value)
+}
+
+// encode JSON output data to the little endian binary representation of its datatype
+func encodeJSONData(datatype string, data json.RawMessage) ([]byte, error) {
+	var value interface{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, element := range flattenJSONData(value, nil) {
+		if datatype == "BYTES" {
+			str, ok := element.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string element for BYTES")
+			}
+			binary.Write(&buf, binary.LittleEndian, uint32(len(str)))
+			buf.WriteString(str)
+			continue
+		}
+		if datatype == "BOOL" {
+			b, ok := element.(bool)
+			if !ok {
+				return nil, fmt.Errorf("expected boolean element for BOOL")
+			}
+			binary.Write(&buf, binary.LittleEndian, b)
+			continue
+		}
+
+		number, ok := element.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected numeric element for %s", datatype)
+		}
+		var v interface{}
+		switch datatype {
+		case "INT8":
+			v = int8(number)
+		case "INT16":
+			v = int16(number)
+		case "INT32":
+			v = int32(number)
+		case "INT64":
+			v = int64(number)
+		case "UINT8":
+			v = uint8(number)
+		case "UINT16":
+			v = uint16(number)
+		case "UINT32":
+			v = uint32(number)
+		case "UINT64":
+			v = uint64(number)
+		case "FP16", "FP32":
+			v = math.Float32bits(float32(number))
+		case "FP64":
+			v = number
+		default:
+			return nil, fmt.Errorf("unsupported datatype '%s'", datatype)
+		}
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,228 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// REST backend of a test server
+func newTestRESTBackend(t *testing.T, handler http.HandlerFunc) (*restBackend, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return newRESTBackend(strings.TrimPrefix(server.URL, "http://"), nil), server
+}
+
+// little endian binary representation of tensor data
+func littleEndian(data interface{}) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, data)
+	return buf.Bytes()
+}
+
+func TestRESTBackendReady(t *testing.T) {
+	backend, server := newTestRESTBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/health/ready", "/v2/models/ssd/ready":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	ctx := context.Background()
+
+	ready, err := backend.ServerReady(ctx)
+	require.NoError(t, err)
+	assert.True(t, ready)
+	ready, err = backend.ModelReady(ctx, "ssd", "")
+	require.NoError(t, err)
+	assert.True(t, ready)
+	ready, err = backend.ModelReady(ctx, "ssd", "2")
+	require.NoError(t, err)
+	assert.False(t, ready)
+
+	// an unreachable server is unavailable
+	server.Close()
+	_, err = backend.ServerReady(ctx)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestRESTBackendModelMetadata(t *testing.T) {
+	backend, _ := newTestRESTBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/models/ssd/versions/1":
+			io.WriteString(w, `{"name":"ssd","versions":["1"],"platform":"OpenVINO",`+
+				`"inputs":[{"name":"image","datatype":"UINT8","shape":[1,300,300,3]}],`+
+				`"outputs":[{"name":"detection_out","datatype":"FP32","shape":[1,1,-1,7]}]}`)
+		case "/v2/models/broken":
+			io.WriteString(w, `{"name":`)
+		case "/v2/models/crash":
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, "out of memory")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"error":"Model with requested name is not found"}`)
+		}
+	})
+	ctx := context.Background()
+
+	metadata, err := backend.ModelMetadata(ctx, "ssd", "1")
+	require.NoError(t, err)
+	assert.Equal(t, &ModelMetadata{
+		Name:     "ssd",
+		Versions: []string{"1"},
+		Platform: "OpenVINO",
+		Inputs:   []*TensorMetadata{{Name: "image", Datatype: "UINT8", Shape: []int64{1, 300, 300, 3}}},
+		Outputs:  []*TensorMetadata{{Name: "detection_out", Datatype: "FP32", Shape: []int64{1, 1, -1, 7}}},
+	}, metadata)
+
+	// the error of the body is kept, the status is mapped to a gRPC code
+	_, err = backend.ModelMetadata(ctx, "unknown", "")
+	assert.Equal(t, status.Error(codes.NotFound, "Model with requested name is not found"), err)
+	_, err = backend.ModelMetadata(ctx, "crash", "")
+	assert.Equal(t, status.Error(codes.Internal, "HTTP 500: out of memory"), err)
+	_, err = backend.ModelMetadata(ctx, "broken", "")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, "invalid model metadata")
+}
+
+func TestRESTStatusError(t *testing.T) {
+	for code, expected := range map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusBadGateway:          codes.Unavailable,
+		http.StatusGatewayTimeout:      codes.Unavailable,
+		http.StatusInternalServerError: codes.Internal,
+		http.StatusTeapot:              codes.Internal,
+	} {
+		assert.Equal(t, expected, status.Code(restStatusError(code, nil)), code)
+	}
+}
+
+// a binary tensor response: the JSON header, then the binary outputs in order
+func writeInferResponse(w http.ResponseWriter, header string, binaryData []byte) {
+	w.Header().Set(inferHeaderContentLength, strconv.Itoa(len(header)))
+	io.WriteString(w, header)
+	w.Write(binaryData)
+}
+
+func TestRESTBackendModelInfer(t *testing.T) {
+	type received struct {
+		request restInferRequest
+		payload []byte
+	}
+	requests := make(chan received, 1)
+	backend, _ := newTestRESTBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v2/models/ssd/versions/1/infer" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		length, _ := strconv.Atoi(r.Header.Get(inferHeaderContentLength))
+		var request restInferRequest
+		_ = json.Unmarshal(body[:length], &request)
+		requests <- received{request: request, payload: body[length:]}
+
+		scores := encodeFP32([]float32{0.5, 0.25})
+		writeInferResponse(w, `{"model_name":"ssd","model_version":"1","id":"7","outputs":[`+
+			`{"name":"scores","datatype":"FP32","shape":[1,2],"parameters":{"binary_data_size":8}},`+
+			`{"name":"labels","datatype":"INT64","shape":[1,2],"data":[[3,5]]},`+
+			`{"name":"half","datatype":"FP16","shape":[2],"data":[1.5,-2]},`+
+			`{"name":"valid","datatype":"BOOL","shape":[2],"data":[true,false]},`+
+			`{"name":"names","datatype":"BYTES","shape":[1],"data":["person"]}]}`, scores)
+	})
+
+	image := bytesInput("image", "jpeg")[0]
+	info := &InferTensor{Name: "image_info", Datatype: "FP32", Shape: []int64{1, 3}, Data: encodeFP32([]float32{300, 300, 1})}
+	response, err := backend.ModelInfer(context.Background(), &InferRequest{
+		Id:           "7",
+		ModelName:    "ssd",
+		ModelVersion: "1",
+		Inputs:       []*InferTensor{image, info},
+	})
+	require.NoError(t, err)
+
+	// the inputs are sent as binary data after the JSON header
+	sent := <-requests
+	request := sent.request
+	assert.Equal(t, "7", request.Id)
+	assert.Equal(t, true, request.Parameters["binary_data_output"])
+	require.Len(t, request.Inputs, 2)
+	assert.Equal(t, "BYTES", request.Inputs[0].Datatype)
+	assert.Equal(t, float64(len(image.Data)), request.Inputs[0].Parameters["binary_data_size"])
+	assert.Equal(t, []int64{1, 3}, request.Inputs[1].Shape)
+	assert.Equal(t, append(append([]byte(nil), image.Data...), info.Data...), sent.payload)
+
+	// binary and JSON outputs decode to the same binary representation
+	assert.Equal(t, "ssd", response.ModelName)
+	assert.Equal(t, "7", response.Id)
+	require.Len(t, response.Outputs, 5)
+	assert.Equal(t, &InferTensor{Name: "scores", Datatype: "FP32", Shape: []int64{1, 2}, Data: encodeFP32([]float32{0.5, 0.25})}, response.Outputs[0])
+	assert.Equal(t, littleEndian([]int64{3, 5}), response.Outputs[1].Data)
+	assert.Equal(t, "FP32", response.Outputs[2].Datatype)
+	assert.Equal(t, encodeFP32([]float32{1.5, -2}), response.Outputs[2].Data)
+	assert.Equal(t, []byte{1, 0}, response.Outputs[3].Data)
+	assert.Equal(t, append(littleEndian(uint32(6)), "person"...), response.Outputs[4].Data)
+}
+
+func TestRESTBackendModelInferErrors(t *testing.T) {
+	backend, _ := newTestRESTBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/models/invalid/infer":
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"error":"Invalid number of inputs"}`)
+		case "/v2/models/loading/infer":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, `{"error":"Model is not loaded yet"}`)
+		case "/v2/models/header/infer":
+			w.Header().Set(inferHeaderContentLength, "1000")
+			io.WriteString(w, `{}`)
+		case "/v2/models/size/infer":
+			writeInferResponse(w, `{"outputs":[{"name":"scores","datatype":"FP32","shape":[1],"parameters":{"binary_data_size":8}}]}`, make([]byte, 4))
+		case "/v2/models/data/infer":
+			io.WriteString(w, `{"outputs":[{"name":"scores","datatype":"FP32","shape":[1],"data":["high"]}]}`)
+		case "/v2/models/slow/infer":
+			// the cancellation is seen once the body is read
+			io.Copy(io.Discard, r.Body)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	})
+
+	infer := func(model string, timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_, err := backend.ModelInfer(ctx, &InferRequest{ModelName: model, Inputs: bytesInput("image", "jpeg")})
+		return err
+	}
+	assert.Equal(t, status.Error(codes.InvalidArgument, "Invalid number of inputs"), infer("invalid", time.Second))
+	assert.Equal(t, status.Error(codes.Unavailable, "Model is not loaded yet"), infer("loading", time.Second))
+	err := infer("header", time.Second)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.ErrorContains(t, err, inferHeaderContentLength)
+	assert.ErrorContains(t, infer("size", time.Second), "invalid binary_data_size of output 'scores'")
+	assert.ErrorContains(t, infer("data", time.Second), "expected numeric element for FP32")
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(infer("slow", 50*time.Millisecond)))
+}
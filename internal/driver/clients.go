@@ -11,79 +11,171 @@ package driver
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
 
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
-	"github.com/yiqisoft/mjpeg"
 	"gocv.io/x/gocv"
 )
 
-// Create Stream Writer by device name
-func (d *Driver) NewStreamClient(deviceName string) *mjpeg.Stream {
+// Create the live streams of a device by device name, replacing the previous ones
+func (d *Driver) NewStreamClient(deviceName string, protocol models.ProtocolProperties) *deviceStreams {
 	d.lc.Debugf("Creating new Stream client for device %s", deviceName)
 
-	stream := mjpeg.NewStream()
-	d.streams[deviceName] = stream
-	return stream
-}
-
-// Write live stream to client by device name
-func (d *Driver) WriteStream(deviceName string, img gocv.Mat) error {
-	stream, ok := d.streams[deviceName]
-	if !ok {
-		return fmt.Errorf("stream for device %s not found", deviceName)
+	config, err := parseStreamConfig(protocol)
+	if err != nil {
+		d.lc.Errorf("Invalid stream settings for device %s, using defaults: %v", deviceName, err)
+	}
+	streams := newDeviceStreams(config, d.metrics.streamClients, deviceName)
+	if config.HLS.Enabled {
+		// the segments are written to a directory of the device, removed with its streams unless recorded
+		dir, err := hlsDir(deviceName, config.HLS)
+		if err != nil {
+			d.lc.Errorf("Failed to create the HLS directory of device %s: %v", deviceName, err)
+		} else {
+			streams.hls = newHLSStream(config.HLS, dir, openH264Segment)
+		}
+	}
+	if config.RTSP {
+		rtsp, err := d.rtsp.AddStream(deviceName)
+		if err != nil {
+			d.lc.Errorf("Failed to create the RTSP stream of device %s: %v", deviceName, err)
+		} else {
+			streams.rtsp = rtsp
+		}
+	}
+	d.stateMu.Lock()
+	previous := d.streams[deviceName]
+	d.streams[deviceName] = streams
+	d.stateMu.Unlock()
+	if previous != nil {
+		previous.Close()
 	}
+	return streams
+}
 
-	buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
-	if err != nil {
-		return err
+// create the directory of the HLS segments of a device, a new recording under the record directory
+func hlsDir(deviceName string, config HLSConfig) (string, error) {
+	if !config.Record() {
+		return os.MkdirTemp("", "ovms-hls-")
 	}
-	stream.UpdateJPEG(buf.GetBytes())
-	defer buf.Close()
+	dir := filepath.Join(config.RecordDir, deviceName, time.Now().Format(hlsRecordLayout))
+	return dir, os.MkdirAll(dir, 0755)
+}
 
-	return nil
+// create the live streams of a device and route them on the live server
+func (d *Driver) addStream(deviceName string, protocols map[string]models.ProtocolProperties) {
+	streams := d.NewStreamClient(deviceName, protocols[Protocol])
+	d.live.AddStream(deviceName, streams, parseLiveAccess(protocols[Protocol]))
 }
 
-// Get GocvClient by 'DeviceName'
-func (d *Driver) GetGocvClient(deviceName string, protocols map[string]models.ProtocolProperties) (*gocv.VideoCapture, error) {
-	d.lc.Debugf("Getting Gocv client for device: %s", deviceName)
+// Write a frame to a live stream variant of a device by device name
+func (d *Driver) WriteStream(deviceName string, variant string, img gocv.Mat) error {
+	d.stateMu.RLock()
+	streams, ok := d.streams[deviceName]
+	d.stateMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("stream for device %s not found", deviceName)
+	}
+	return streams.Write(variant, img, d.metrics.encodeDuration.WithLabelValues(deviceName))
+}
 
-	gocvClient := d.gocvClients[deviceName]
+// FrameSource delivers the frames of a device, gocv.VideoCapture is the default one
+type FrameSource interface {
+	// Read the next frame into img, false when no frame could be read
+	Read(img *gocv.Mat) bool
+	Close() error
+}
 
-	// var webcam *gocv.VideoCapture
-	if gocvClient == nil {
-		d.lc.Warnf("GocvClient for device %s not found. Creating it...", deviceName)
-		err := d.NewGocvClient(deviceName, protocols)
-		if err != nil {
-			delete(d.gocvClients, deviceName)
-			return nil, err
-		}
-		d.gocvClients[deviceName] = gocvClient
-	} else {
-		d.lc.Debugf("GocvClient exist.")
+// Open the frame source of a device by 'Uri'
+func (d *Driver) OpenFrameSource(uri string) (FrameSource, error) {
+	if d.frameSourceFactory != nil {
+		return d.frameSourceFactory(uri)
 	}
+	capture, err := gocv.VideoCaptureFile(uri)
+	if err != nil {
+		return nil, err
+	}
+	return capture, nil
+}
 
-	return gocvClient, nil
-
+// captureWorker is the capture goroutine of a device
+type captureWorker struct {
+	stop chan struct{}
+	done chan struct{}
 }
 
+// maximum wait for a capture goroutine to exit, a blocked camera read may take longer
+var captureStopTimeout = 5 * time.Second
+
 // Create RTSP client by 'Device' definition
 func (d *Driver) NewGocvClient(deviceName string, protocols map[string]models.ProtocolProperties) error {
 	d.lc.Debugf("Creating new Gocv client for device %s", deviceName)
 
-	// get gRPC client
-	grpcClient, err := d.GetGRPCClient(deviceName, protocols)
+	// get inference backend
+	backend, err := d.GetInferenceBackend(deviceName, protocols)
 	if err != nil {
-		err = fmt.Errorf("failed to get grpc client for device: %s", deviceName)
+		err = fmt.Errorf("failed to get inference backend for device: %s", deviceName)
 		return err
 	}
 
+	// a device has a single capture goroutine
+	d.StopGocvClient(deviceName)
+
+	worker := &captureWorker{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	d.stateMu.Lock()
+	d.captures[deviceName] = worker
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+	d.stateMu.Unlock()
+
 	go func() {
-		d.imageCapture(deviceName, grpcClient, protocols)
+		defer close(worker.done)
+		d.imageCapture(deviceName, backend, protocols, worker.stop)
 	}()
 
 	return err
 }
 
+// closed channel of the exit of no capture goroutine
+var captureStopped = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+// Stop the capture goroutine of a device and wait for it to exit. The returned channel is
+// closed once it exited, which is later when the wait timed out.
+func (d *Driver) StopGocvClient(deviceName string) <-chan struct{} {
+	d.stateMu.Lock()
+	worker, ok := d.captures[deviceName]
+	delete(d.captures, deviceName)
+	d.stateMu.Unlock()
+	if !ok {
+		return captureStopped
+	}
+
+	close(worker.stop)
+	select {
+	case <-worker.done:
+	case <-time.After(captureStopTimeout):
+		d.lc.Warnf("Capture of device %s did not stop within %s", deviceName, captureStopTimeout)
+	}
+	return worker.done
+}
+
+// get the result channel of a device
+func (d *Driver) resultChannel(deviceName string) chan OVMSResult {
+	d.stateMu.RLock()
+	defer d.stateMu.RUnlock()
+	return d.ovmsCh[deviceName]
+}
+
 // 91 [Common Objects in Context (COCO)](https://cocodataset.org/#home) dataset
 var coco_classes = []string{
 	"__background__", "person", "bicycle", "car", "motorcycle", "airplan", "bus", "train", "car", "boat",
@@ -97,3 +189,19 @@ var coco_classes = []string{
 	"cell phone", "microwave", "oven", "toaster", "sink", "refrigerator", "blender", "book", "clock",
 	"vase", "scissors", "teddy bear", "hair drier", "toothbrush", "hair brush",
 }
+
+// name of a COCO label, its number when unknown
+func labelName(label float32) string {
+	if i := int(label); i >= 0 && i < len(coco_classes) {
+		return coco_classes[i]
+	}
+	return strconv.Itoa(int(label))
+}
+
+// whether a detection may carry the label 'name': a COCO label, or the number of a class past them
+func knownLabel(name string) bool {
+	if i, err := strconv.Atoi(name); err == nil {
+		return i >= len(coco_classes)
+	}
+	return slices.Contains(coco_classes, name)
+}
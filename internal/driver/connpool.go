@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"google.golang.org/grpc"
+)
+
+// grpcConnEntry is a gRPC connection shared by all devices using the same endpoint, it is
+// pending until 'ready' is closed, then holds either the connection or the dial error
+type grpcConnEntry struct {
+	endpoint string
+	conn     *grpc.ClientConn
+	err      error
+	refs     int
+	cancel   context.CancelFunc
+	ready    chan struct{}
+}
+
+// grpcConnPool shares gRPC connections between devices, keyed by endpoint and TLS settings
+type grpcConnPool struct {
+	lc    logger.LoggingClient
+	mu    sync.Mutex
+	conns map[string]*grpcConnEntry
+}
+
+func newGRPCConnPool(lc logger.LoggingClient) *grpcConnPool {
+	return &grpcConnPool{
+		lc:    lc,
+		conns: make(map[string]*grpcConnEntry),
+	}
+}
+
+// connection key of an endpoint, devices with different TLS settings never share a connection
+func grpcConnKey(endpoint string, tlsInfo TLSInfo) string {
+	if !tlsInfo.Enabled {
+		return endpoint
+	}
+	return fmt.Sprintf("%s|tls|%s|%s|%s|%s|%s", endpoint, tlsInfo.CACert, tlsInfo.ClientCert, tlsInfo.ClientKey, tlsInfo.ServerName, tlsInfo.SecretName)
+}
+
+// Acquire returns the shared connection for key, dialing it if this is the first user. The
+// dial runs without the lock, other users of the key wait for it while other keys proceed.
+func (p *grpcConnPool) Acquire(key string, endpoint string, dial func() (*grpc.ClientConn, error)) (*grpc.ClientConn, error) {
+	p.mu.Lock()
+	if entry, ok := p.conns[key]; ok {
+		entry.refs++
+		refs := entry.refs
+		p.mu.Unlock()
+		<-entry.ready
+		if entry.err != nil {
+			return nil, entry.err
+		}
+		p.lc.Debugf("Reusing gRPC connection to %s, %d device(s) sharing it", endpoint, refs)
+		return entry.conn, nil
+	}
+	entry := &grpcConnEntry{
+		endpoint: endpoint,
+		refs:     1,
+		ready:    make(chan struct{}),
+	}
+	p.conns[key] = entry
+	p.mu.Unlock()
+
+	conn, err := dial()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(entry.ready)
+	if err == nil && p.conns[key] != entry {
+		// the pool was closed while dialing
+		conn.Close()
+		err = fmt.Errorf("gRPC connection pool closed while dialing %s", endpoint)
+	}
+	if err != nil {
+		// a failed dial is not pooled, its waiters get the error
+		if p.conns[key] == entry {
+			delete(p.conns, key)
+		}
+		entry.err = err
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	entry.conn = conn
+	entry.cancel = cancel
+	go p.watchState(ctx, endpoint, conn)
+
+	p.lc.Infof("New gRPC connection to %s", endpoint)
+	return conn, nil
+}
+
+// Release drops one reference to key, the connection is closed when the last device leaves
+func (p *grpcConnPool) Release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.conns[key]
+	if !ok {
+		return
+	}
+	entry.refs--
+	if entry.refs > 0 {
+		p.lc.Debugf("gRPC connection to %s still used by %d device(s)", entry.endpoint, entry.refs)
+		return
+	}
+
+	entry.cancel()
+	if err := entry.conn.Close(); err != nil {
+		p.lc.Errorf("Error closing gRPC connection to %s: %v", entry.endpoint, err)
+	}
+	delete(p.conns, key)
+	p.lc.Infof("gRPC connection to %s closed", entry.endpoint)
+}
+
+// Close closes all connections regardless of their users
+func (p *grpcConnPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, entry := range p.conns {
+		// a pending dial closes its own connection, see Acquire
+		if entry.conn != nil {
+			entry.cancel()
+			entry.conn.Close()
+		}
+		delete(p.conns, key)
+	}
+}
+
+// log connectivity changes once per endpoint rather than once per device
+func (p *grpcConnPool) watchState(ctx context.Context, endpoint string, conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for conn.WaitForStateChange(ctx, state) {
+		state = conn.GetState()
+		p.lc.Infof("gRPC connection to %s is %s", endpoint, state)
+	}
+}
@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// a dialer of lazy connections counting its dials, nothing is connected until a call
+func countingDial(dials *atomic.Int32) func() (*grpc.ClientConn, error) {
+	return func() (*grpc.ClientConn, error) {
+		dials.Add(1)
+		return grpc.NewClient("passthrough:///ovms:9000", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+}
+
+// references of a pooled connection, 0 when it is not in the pool
+func poolRefs(p *grpcConnPool, key string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.conns[key]; ok {
+		return entry.refs
+	}
+	return 0
+}
+
+func TestGRPCConnKey(t *testing.T) {
+	assert.Equal(t, "ovms:9000", grpcConnKey("ovms:9000", TLSInfo{CACert: "ca.pem"}))
+	plain := grpcConnKey("ovms:9000", TLSInfo{})
+	secure := grpcConnKey("ovms:9000", TLSInfo{Enabled: true, CACert: "ca.pem"})
+	other := grpcConnKey("ovms:9000", TLSInfo{Enabled: true, CACert: "other-ca.pem"})
+	assert.NotEqual(t, plain, secure)
+	assert.NotEqual(t, secure, other)
+}
+
+func TestGRPCConnPool(t *testing.T) {
+	pool := newGRPCConnPool(logger.NewMockClient())
+	var dials atomic.Int32
+	dial := countingDial(&dials)
+
+	// the devices of an endpoint share one connection
+	key := grpcConnKey("ovms:9000", TLSInfo{})
+	first, err := pool.Acquire(key, "ovms:9000", dial)
+	require.NoError(t, err)
+	second, err := pool.Acquire(key, "ovms:9000", dial)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, 2, poolRefs(pool, key))
+
+	tlsKey := grpcConnKey("ovms:9000", TLSInfo{Enabled: true, CACert: "ca.pem"})
+	secure, err := pool.Acquire(tlsKey, "ovms:9000", dial)
+	require.NoError(t, err)
+	assert.NotSame(t, first, secure)
+	assert.Equal(t, int32(2), dials.Load())
+
+	// a failed dial is not pooled
+	_, err = pool.Acquire("unreachable", "unreachable", func() (*grpc.ClientConn, error) { return nil, errors.New("refused") })
+	assert.Error(t, err)
+	assert.Zero(t, poolRefs(pool, "unreachable"))
+
+	// the connection is closed with its last device
+	pool.Release(key)
+	assert.Equal(t, 1, poolRefs(pool, key))
+	assert.NotEqual(t, connectivity.Shutdown, first.GetState())
+	pool.Release(key)
+	assert.Zero(t, poolRefs(pool, key))
+	assert.Equal(t, connectivity.Shutdown, first.GetState())
+	pool.Release(key)
+
+	// a released endpoint is dialed again
+	third, err := pool.Acquire(key, "ovms:9000", dial)
+	require.NoError(t, err)
+	assert.NotSame(t, first, third)
+	assert.Equal(t, int32(3), dials.Load())
+
+	pool.Close()
+	assert.Equal(t, connectivity.Shutdown, secure.GetState())
+	assert.Equal(t, connectivity.Shutdown, third.GetState())
+	assert.Empty(t, pool.conns)
+}
+
+func TestGRPCConnPoolConcurrent(t *testing.T) {
+	pool := newGRPCConnPool(logger.NewMockClient())
+	defer pool.Close()
+	var dials atomic.Int32
+	dial := countingDial(&dials)
+
+	const devices = 16
+	conns := make([]*grpc.ClientConn, devices)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], _ = pool.Acquire("ovms:9000", "ovms:9000", dial)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, devices, poolRefs(pool, "ovms:9000"))
+	for _, conn := range conns {
+		assert.Same(t, conns[0], conn)
+	}
+}
+
+func TestGRPCConnPoolPendingDial(t *testing.T) {
+	pool := newGRPCConnPool(logger.NewMockClient())
+	defer pool.Close()
+	var dials atomic.Int32
+
+	// a slow dial blocks neither the pool nor the other endpoints
+	release := make(chan struct{})
+	var dialErr error
+	blockedDial := func() (*grpc.ClientConn, error) {
+		dials.Add(1)
+		<-release
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		return countingDial(&atomic.Int32{})()
+	}
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := pool.Acquire("slow:9000", "slow:9000", blockedDial)
+			results <- err
+		}()
+	}
+	assert.Eventually(t, func() bool { return poolRefs(pool, "slow:9000") == 2 }, time.Second, time.Millisecond)
+	_, err := pool.Acquire("ovms:9000", "ovms:9000", countingDial(&dials))
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// the waiters of a failed dial get its error and the key is dialed again
+	dialErr = errors.New("refused")
+	close(release)
+	assert.EqualError(t, <-results, "refused")
+	assert.EqualError(t, <-results, "refused")
+	assert.Equal(t, int32(2), dials.Load())
+	assert.Zero(t, poolRefs(pool, "slow:9000"))
+	_, err = pool.Acquire("slow:9000", "slow:9000", countingDial(&dials))
+	require.NoError(t, err)
+	assert.Equal(t, 1, poolRefs(pool, "slow:9000"))
+}
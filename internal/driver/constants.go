@@ -9,5 +9,171 @@ package driver
 // Constants related to protocol properties
 const (
 	Protocol = "ovms"
-	LivePort = "18080"
+)
+
+// Constants related to TLS protocol properties
+const (
+	TLS           = "TLS"
+	CACert        = "CACert"
+	ClientCert    = "ClientCert"
+	ClientKey     = "ClientKey"
+	ServerName    = "ServerName"
+	TLSSecretName = "TLSSecretName"
+
+	// keys read from the secret store when 'TLSSecretName' is set
+	SecretCACert     = "caCert"
+	SecretClientCert = "clientCert"
+	SecretClientKey  = "clientKey"
+)
+
+// Constants related to the live streams of a device
+const (
+	StreamQuality   = "StreamQuality"
+	StreamMaxWidth  = "StreamMaxWidth"
+	StreamMaxHeight = "StreamMaxHeight"
+	StreamRaw       = "raw"
+	StreamAnnotated = "annotated"
+	StreamEvents    = "events"
+
+	HLS                = "HLS"
+	HLSSegmentDuration = "HLSSegmentDuration"
+	HLSPlaylistSize    = "HLSPlaylistSize"
+	HLSFrameRate       = "HLSFrameRate"
+	HLSRecordDir       = "HLSRecordDir"
+
+	RTSP = "RTSP"
+)
+
+// Constants related to the overlay drawn on the annotated frames
+const (
+	OverlayColor           = "OverlayColor"
+	OverlayColors          = "OverlayColors"
+	OverlayLabel           = "OverlayLabel"
+	OverlayFontSize        = "OverlayFontSize"
+	OverlayThickness       = "OverlayThickness"
+	OverlayLabelBackground = "OverlayLabelBackground"
+	OverlayTimeFormat      = "OverlayTimeFormat"
+	OverlayTimezone        = "OverlayTimezone"
+	OverlayBoxes           = "OverlayBoxes"
+	OverlayLabels          = "OverlayLabels"
+	OverlayTimestamp       = "OverlayTimestamp"
+	OverlayFPS             = "OverlayFPS"
+)
+
+// Constants related to the privacy masking of the frames leaving a device
+const (
+	PrivacyLabels   = "PrivacyLabels"
+	PrivacyZones    = "PrivacyZones"
+	PrivacyMask     = "PrivacyMask"
+	PrivacyScore    = "PrivacyScore"
+	PrivacyMargin   = "PrivacyMargin"
+	PrivacyBlur     = "blur"
+	PrivacyPixelate = "pixelate"
+)
+
+// Constants related to the access to the live streaming server
+const (
+	LiveAccess    = "LiveAccess"
+	LiveAuthNone  = "none"
+	LiveAuthToken = "token"
+	LiveAuthBasic = "basic"
+	LiveAuthJWT   = "jwt"
+
+	// keys read from the secret store when 'LiveTLSSecretName' is set
+	SecretServerCert = "serverCert"
+	SecretServerKey  = "serverKey"
+)
+
+// Constants related to multiple OVMS endpoints of a device
+const (
+	Endpoints             = "Endpoints"
+	Balancing             = "Balancing"
+	BalancingRoundRobin   = "round-robin"
+	BalancingLeastLatency = "least-latency"
+)
+
+// Constants related to streaming inference
+const (
+	StreamInfer    = "StreamInfer"
+	StreamInflight = "StreamInflight"
+)
+
+// Constants related to the transport used to reach OVMS
+const (
+	Transport     = "Transport"
+	TransportGRPC = "grpc"
+	TransportREST = "rest"
+)
+
+// Constants related to timeouts and retries, named as in the service configuration
+const (
+	InferTimeout         = "InferTimeout"
+	MetadataTimeout      = "MetadataTimeout"
+	DialRetries          = "DialRetries"
+	DialRetryInterval    = "DialRetryInterval"
+	ReconnectAttempts    = "ReconnectAttempts"
+	ReconnectInterval    = "ReconnectInterval"
+	ReconnectMaxInterval = "ReconnectMaxInterval"
+	ReconnectMultiplier  = "ReconnectMultiplier"
+	ReconnectJitter      = "ReconnectJitter"
+
+	// name of the custom configuration section
+	CustomConfigSection = "OVMS"
+)
+
+// Constants related to the model input of a device
+const (
+	InputName   = "InputName"
+	Layout      = "Layout"
+	InputWidth  = "InputWidth"
+	InputHeight = "InputHeight"
+	LayoutNHWC  = "NHWC"
+	LayoutNCHW  = "NCHW"
+)
+
+// Constants related to the batching of frames of several devices
+const (
+	BatchSize = "BatchSize"
+	BatchWait = "BatchWait"
+)
+
+// Constants related to the sampling of frames for inference
+const (
+	InferFPS        = "InferFPS"
+	FrameSkip       = "FrameSkip"
+	SkipFrames      = "SkipFrames"
+	MotionThreshold = "MotionThreshold"
+	AdaptiveFPS     = "AdaptiveFPS"
+	MinInferFPS     = "MinInferFPS"
+	MotionMethod    = "MotionMethod"
+	MotionROI       = "MotionROI"
+	MotionKeepAlive = "MotionKeepAlive"
+	MotionHoldOff   = "MotionHoldOff"
+	SkipNone        = "none"
+	SkipNth         = "nth"
+	SkipTime        = "time"
+	SkipMotion      = "motion"
+	MotionDiff      = "diff"
+	MotionMOG2      = "mog2"
+)
+
+// Device resources besides the inference result
+const (
+	MotionResource = "motion"
+	StatusResource = "status"
+)
+
+// Constants related to the buffering of the frames read from a source
+const (
+	FrameBuffer       = "FrameBuffer"
+	FrameBufferLatest = "latest"
+	FrameBufferAll    = "all"
+)
+
+// Capture states of a device
+const (
+	CaptureConnecting   = "connecting"
+	CaptureRunning      = "running"
+	CaptureReconnecting = "reconnecting"
+	CaptureFailed       = "failed"
 )
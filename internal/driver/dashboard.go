@@ -0,0 +1,30 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// dashboardPage lists the devices with their annotated stream, status and last detections,
+// from /status and the /results feed
+//
+//go:embed web/dashboard.html
+var dashboardPage []byte
+
+// DashboardHandler serves the dashboard page of the live server
+func DashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(dashboardPage)
+	})
+}
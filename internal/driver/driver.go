@@ -12,19 +12,16 @@ package driver
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"sync"
-	"time"
 
-	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
 	"github.com/edgexfoundry/device-sdk-go/v4/pkg/interfaces"
 	sdkModel "github.com/edgexfoundry/device-sdk-go/v4/pkg/models"
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/common"
 	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
-	"github.com/yiqisoft/mjpeg"
+	"github.com/spf13/cast"
 	"gocv.io/x/gocv"
-	"google.golang.org/grpc"
 )
 
 var once sync.Once
@@ -32,17 +29,34 @@ var driver *Driver
 
 // Define Driver struct
 type Driver struct {
-	lc          logger.LoggingClient
-	asyncCh     chan<- *sdkModel.AsyncValues
-	grpcConns   map[string]*grpc.ClientConn
-	grpcServers map[string]*grpc_client.GRPCInferenceServiceClient
-	gocvClients map[string]*gocv.VideoCapture
-	mu          sync.Mutex
-	writers     map[string]*gocv.VideoWriter
-	streams     map[string]*mjpeg.Stream
-	imageSizes  map[string]ImageSize
-	sdk         interfaces.DeviceServiceSDK
-	ovmsCh      map[string]chan OVMSResult
+	lc             logger.LoggingClient
+	asyncCh        chan<- *sdkModel.AsyncValues
+	grpcPool       *grpcConnPool
+	grpcConnKeys   map[string][]string
+	endpointGroups map[string]*endpointGroup
+	streamClients  map[string]*streamInferClient
+	backends       map[string]InferenceBackend
+	batchers       *frameBatcherPool
+	captures       map[string]*captureWorker
+	mu             sync.Mutex
+	writers        map[string]*gocv.VideoWriter
+	streams        map[string]*deviceStreams
+	imageSizes     map[string]ImageSize
+	sdk            interfaces.DeviceServiceSDK
+	ovmsCh         map[string]chan OVMSResult
+	serviceConfig  *config.ServiceConfig
+	policy         DevicePolicy
+	stateMu        sync.RWMutex
+	captureStates  map[string]string
+	motionStates   map[string]bool
+	stats          map[string]*CaptureStats
+	health         map[string]*deviceHealth
+	metrics        *pipelineMetrics
+	live           *liveServer
+	rtsp           *rtspServer
+	results        *resultHub
+	// frameSourceFactory opens frame sources instead of gocv, used by tests
+	frameSourceFactory func(uri string) (FrameSource, error)
 }
 
 // Driver is initialized on service start
@@ -57,50 +71,56 @@ func NewProtocolDriver() interfaces.ProtocolDriver {
 func (d *Driver) Initialize(sdk interfaces.DeviceServiceSDK) error {
 	d.lc = sdk.LoggingClient()
 	d.asyncCh = sdk.AsyncValuesChannel()
+	d.sdk = sdk
 
 	// init all clients
-	d.grpcConns = make(map[string]*grpc.ClientConn)
-	d.grpcServers = make(map[string]*grpc_client.GRPCInferenceServiceClient)
-	d.gocvClients = make(map[string]*gocv.VideoCapture)
-	d.streams = make(map[string]*mjpeg.Stream)
-	d.imageSizes = make(map[string]ImageSize)
+	d.initClients()
 
-	d.sdk = sdk
-	d.ovmsCh = make(map[string]chan OVMSResult)
-
-	// prepare image matrix
+	// load custom configuration, devices may override timeouts and retries by protocol properties
+	d.serviceConfig = &config.ServiceConfig{}
+	if err := sdk.LoadCustomConfig(d.serviceConfig, CustomConfigSection); err != nil {
+		return fmt.Errorf("failed to load '%s' custom configuration: %v", CustomConfigSection, err)
+	}
+	if err := d.serviceConfig.OVMS.Validate(); err != nil {
+		return err
+	}
+	policy, err := servicePolicy(&d.serviceConfig.OVMS)
+	if err != nil {
+		return fmt.Errorf("invalid '%s' custom configuration: %v", CustomConfigSection, err)
+	}
+	d.policy = policy
+	liveConfig, err := d.serviceLiveSettings(&d.serviceConfig.OVMS)
+	if err != nil {
+		return fmt.Errorf("invalid '%s' custom configuration: %v", CustomConfigSection, err)
+	}
+	d.rtsp.SetAddr(serviceRTSPAddr(&d.serviceConfig.OVMS))
+	// the RTSP clients send the credentials of the live server, over RTSPS with its TLS
+	d.rtsp.SetTLS(liveConfig.TLS)
+	if liveConfig.Auth != nil && liveConfig.TLS == nil {
+		d.lc.Warnf("Live streaming clients are authenticated without 'LiveTLS', their credentials are sent in plaintext over HTTP and RTSP")
+	}
 
 	for _, device := range sdk.Devices() {
 		// init stream by device name
-		stream := d.NewStreamClient(device.Name)
-		http.Handle("/"+device.Name+".mjpeg", stream)
+		d.addStream(device.Name, device.Protocols)
 	}
 
-	// initialize HTTP server for streamings
-	server := &http.Server{
-		Addr: "0.0.0.0:" + LivePort,
+	// the live streams are served before the captures start writing them
+	if err := d.live.Start(liveConfig); err != nil {
+		return err
 	}
-	go func() {
-		if err := server.ListenAndServe(); err != nil {
-			d.lc.Errorf("failed to start HTTP server for live stream: %v", err)
-		} else {
-			d.lc.Infof("HTTP server started for live streaming on port %s", LivePort)
-		}
-	}()
-
-	time.Sleep(3 * time.Second)
 
 	// initialize the all devices connection in the service started
 	for _, device := range sdk.Devices() {
 
 		var err error
-		// init grpc client
-		_, err = d.NewGRPCClient(device.Name, device.Protocols)
+		// init inference backend
+		_, err = d.NewInferenceBackend(device.Name, device.Protocols)
 		if err != nil {
-			d.lc.Errorf("failed to initialize GRPC client for '%s' device, skiprotocoling this device: %v", device.Name, err)
+			d.lc.Errorf("failed to initialize inference backend for '%s' device, skiprotocoling this device: %v", device.Name, err)
 			continue
 		}
-		d.lc.Debugf("GrpcClient connected for device: %s", device.Name)
+		d.lc.Debugf("Inference backend connected for device: %s", device.Name)
 
 		// init gocv client
 		err = d.NewGocvClient(device.Name, device.Protocols)
@@ -114,30 +134,104 @@ func (d *Driver) Initialize(sdk interfaces.DeviceServiceSDK) error {
 	return nil
 }
 
+// init the client and state maps of the driver
+func (d *Driver) initClients() {
+	d.grpcPool = newGRPCConnPool(d.lc)
+	d.grpcConnKeys = make(map[string][]string)
+	d.endpointGroups = make(map[string]*endpointGroup)
+	d.streamClients = make(map[string]*streamInferClient)
+	d.backends = make(map[string]InferenceBackend)
+	d.batchers = newFrameBatcherPool(d.lc)
+	d.captures = make(map[string]*captureWorker)
+	d.streams = make(map[string]*deviceStreams)
+	d.imageSizes = make(map[string]ImageSize)
+	d.ovmsCh = make(map[string]chan OVMSResult)
+	d.captureStates = make(map[string]string)
+	d.motionStates = make(map[string]bool)
+	d.stats = make(map[string]*CaptureStats)
+	d.health = make(map[string]*deviceHealth)
+	d.metrics = newPipelineMetrics(d)
+	d.results = newResultHub()
+	d.live = newLiveServer(d.lc)
+	d.rtsp = newRTSPServer(d.lc, d.live)
+	// metrics and status of the inference pipeline
+	d.live.Handle("GET /metrics", d.metrics.Handler(d.live.Authorized))
+	d.live.Handle("GET /status", d.StatusHandler())
+	d.live.Handle("GET /status/{device}", d.StatusHandler())
+	// dashboard of the devices, fed by the detection events
+	d.live.Handle("GET /{$}", DashboardHandler())
+	d.live.Handle("GET /results", d.ResultsHandler())
+	d.live.Handle("GET /results/{device}", d.ResultsHandler())
+	d.policy = defaultPolicy
+}
+
 // HandleReadCommands triggers a protocol Read operation for the specified device.
 func (d *Driver) HandleReadCommands(deviceName string, protocols map[string]models.ProtocolProperties, reqs []sdkModel.CommandRequest) (res []*sdkModel.CommandValue, err error) {
 	d.lc.Debugf("Driver.HandleReadCommands: protocols: %v, resource: %v, attributes: %v", protocols, reqs[0].DeviceResourceName, reqs[0].Attributes)
 
 	res = make([]*sdkModel.CommandValue, 0)
 
-	var ovmsResult OVMSResult
-	select {
-	case ovmsResult = <-d.ovmsCh[deviceName]:
-		d.lc.Infof("OVMSResult received, Device: %s, Model: %s, %s fps", deviceName, ovmsResult.ModelName, ovmsResult.InferFPS)
-		break
+	for _, req := range reqs {
+		// motion state of a device gating inference on motion
+		if req.DeviceResourceName == MotionResource {
+			moving, ok := d.motionState(deviceName)
+			if !ok {
+				return nil, fmt.Errorf("motion detection of device %s is not enabled, set '%s' to %s", deviceName, FrameSkip, SkipMotion)
+			}
+			cv, err := sdkModel.NewCommandValue(req.DeviceResourceName, common.ValueTypeBool, moving)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, cv)
+			continue
+		}
 
-	default:
-		// Handle case when channel is send-only
-		d.lc.Debugf("Cannot receive from send-only channel d.ovmsCh")
-		return nil, nil
-	}
-	jsonstr, err := json.Marshal(ovmsResult)
+		// status of the capture and inference pipeline of a device
+		if req.DeviceResourceName == StatusResource {
+			status, ok := d.DeviceStatus(deviceName)
+			if !ok {
+				return nil, fmt.Errorf("device %s has no capture", deviceName)
+			}
+			jsonstr, err := json.Marshal(status)
+			if err != nil {
+				return nil, err
+			}
+			cv, err := sdkModel.NewCommandValue(req.DeviceResourceName, common.ValueTypeString, string(jsonstr))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, cv)
+			continue
+		}
 
-	req := reqs[0]
-	var cv *sdkModel.CommandValue
-	cv, _ = sdkModel.NewCommandValue(req.DeviceResourceName, common.ValueTypeString, string(jsonstr))
-	res = append(res, cv)
+		// report a capture that failed instead of returning no reading forever
+		if d.captureState(deviceName) == CaptureFailed {
+			return nil, fmt.Errorf("capture of device %s failed, see the service log for the cause", deviceName)
+		}
+
+		var ovmsResult OVMSResult
+		select {
+		case ovmsResult = <-d.resultChannel(deviceName):
+			d.lc.Infof("OVMSResult received, Device: %s, Model: %s, %s fps", deviceName, ovmsResult.ModelName, ovmsResult.InferFPS)
+
+		default:
+			// no new result since the last reading
+			d.lc.Debugf("No new OVMS result for device %s", deviceName)
+			continue
+		}
+		jsonstr, err := json.Marshal(ovmsResult)
+		if err != nil {
+			return nil, err
+		}
 
+		var cv *sdkModel.CommandValue
+		cv, _ = sdkModel.NewCommandValue(req.DeviceResourceName, common.ValueTypeString, string(jsonstr))
+		res = append(res, cv)
+	}
+
+	if len(res) == 0 {
+		return nil, nil
+	}
 	return
 }
 
@@ -158,16 +252,49 @@ func (d *Driver) HandleWriteCommands(deviceName string, protocols map[string]mod
 // readings (if suprotocolorted).
 func (d *Driver) Stop(force bool) error {
 
+	// the live stream clients are disconnected first, the result feeds end at once
+	if d.results != nil {
+		d.results.Close()
+	}
+	if d.live != nil {
+		d.live.Shutdown(force)
+	}
+	if d.rtsp != nil {
+		d.rtsp.Close()
+	}
+
+	// stop capturing before closing the inference clients
+	d.stateMu.RLock()
+	deviceNames := make([]string, 0, len(d.captures))
+	for deviceName := range d.captures {
+		deviceNames = append(deviceNames, deviceName)
+	}
+	d.stateMu.RUnlock()
+	for _, deviceName := range deviceNames {
+		d.StopGocvClient(deviceName)
+	}
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	// clear all clients
-	for _, client := range d.gocvClients {
-		client.Close()
-	}
-	d.gocvClients = nil
 
-	d.grpcServers = nil
+	d.backends = nil
+	if d.batchers != nil {
+		d.batchers.Close()
+	}
+	for _, stream := range d.streamClients {
+		stream.Close()
+	}
+	d.streamClients = nil
+	for _, group := range d.endpointGroups {
+		group.Close()
+	}
+	d.endpointGroups = nil
+	if d.grpcPool != nil {
+		d.grpcPool.Close()
+	}
+	d.grpcConnKeys = nil
 
 	for _, writer := range d.writers {
 		if err := writer.Close(); err != nil {
@@ -175,9 +302,17 @@ func (d *Driver) Stop(force bool) error {
 		}
 	}
 	d.writers = nil
-	d.streams = nil
 	d.imageSizes = nil
 
+	// the streams are guarded by the state lock, they are closed outside of it
+	d.stateMu.Lock()
+	streams := d.streams
+	d.streams = nil
+	d.stateMu.Unlock()
+	for _, streams := range streams {
+		streams.Close()
+	}
+
 	// Then Logging Client might not be initialized
 	if d.lc != nil {
 		d.lc.Debugf("Driver.Stop called: force=%v", force)
@@ -193,8 +328,7 @@ func (d *Driver) AddDevice(deviceName string, protocols map[string]models.Protoc
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	stream := d.NewStreamClient(deviceName)
-	http.Handle("/"+deviceName+".mjpeg", stream)
+	d.addStream(deviceName, protocols)
 	// Create new gocv client
 	err := d.NewGocvClient(deviceName, protocols)
 	if err != nil {
@@ -219,26 +353,47 @@ func (d *Driver) UpdateDevice(deviceName string, protocols map[string]models.Pro
 func (d *Driver) RemoveDevice(deviceName string, protocols map[string]models.ProtocolProperties) error {
 	d.lc.Debugf("Device %s is removed", deviceName)
 
+	// the capture is stopped without holding the lock, a blocked camera read may take a while
+	done := d.StopGocvClient(deviceName)
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	gocvClient, ok := d.gocvClients[deviceName]
-	if ok {
-		gocvClient.Close()
-	}
 
-	_, ok = d.grpcServers[deviceName]
+	_, ok := d.backends[deviceName]
 	if ok {
-		delete(d.grpcServers, deviceName)
+		delete(d.backends, deviceName)
 	}
 
-	// delete http handle
-	// http.DefaultServeMux.Handle("/"+deviceName+".mjpeg", http.NotFoundHandler())
+	// the stream is unrouted, its status and metrics are dropped below
+	d.live.RemoveStream(deviceName)
 
-	grpc_conn, ok := d.grpcConns[deviceName]
-	if ok {
-		grpc_conn.Close()
-		delete(d.grpcConns, deviceName)
+	// a capture still blocked keeps its inference clients until it exits
+	release := d.detachGRPCClient(deviceName)
+	select {
+	case <-done:
+		release()
+	default:
+		go func() {
+			<-done
+			release()
+			d.lc.Debugf("Released the inference clients of device %s", deviceName)
+		}()
+	}
+
+	d.stateMu.Lock()
+	delete(d.captureStates, deviceName)
+	delete(d.motionStates, deviceName)
+	delete(d.stats, deviceName)
+	delete(d.health, deviceName)
+	delete(d.ovmsCh, deviceName)
+	streams := d.streams[deviceName]
+	delete(d.streams, deviceName)
+	d.stateMu.Unlock()
+	if streams != nil {
+		streams.Close()
 	}
+	d.metrics.RemoveDevice(deviceName)
+	d.results.RemoveDevice(deviceName)
 
 	return nil
 }
@@ -257,11 +412,27 @@ func (d *Driver) ValidateDevice(device models.Device) error {
 		return errt
 	}
 
-	if err := d.VerifyStringValue(protocol, "Host"); err != nil {
-		return err
+	// 'Endpoints' replaces 'Host' and 'Port' when a device uses several OVMS servers
+	if endpoints, ok := protocol[Endpoints]; ok {
+		host, _ := cast.ToStringE(protocol["Host"])
+		port, _ := cast.ToStringE(protocol["Port"])
+		if _, err := parseEndpoints(host, port, fmt.Sprintf("%v", endpoints)); err != nil {
+			d.lc.Error(err.Error())
+			return err
+		}
+	} else {
+		if err := d.VerifyStringValue(protocol, "Host"); err != nil {
+			return err
+		}
+		if err := d.VerifyNumberValue(protocol, "Port"); err != nil {
+			return err
+		}
 	}
-	if err := d.VerifyNumberValue(protocol, "Port"); err != nil {
-		return err
+	if balancing, ok := protocol[Balancing]; ok {
+		if _, err := parseBalancing(fmt.Sprintf("%v", balancing)); err != nil {
+			d.lc.Error(err.Error())
+			return err
+		}
 	}
 	if err := d.VerifyStringValue(protocol, "Model"); err != nil {
 		return err
@@ -281,6 +452,99 @@ func (d *Driver) ValidateDevice(device models.Device) error {
 	if err := d.VerifyBoolValue(protocol, "Snapshot"); err != nil {
 		return err
 	}
+	transport := TransportGRPC
+	if value, ok := protocol[Transport]; ok {
+		var err error
+		transport, err = parseTransport(fmt.Sprintf("%v", value))
+		if err != nil {
+			d.lc.Error(err.Error())
+			return err
+		}
+	}
+	if _, ok := protocol[StreamInfer]; ok {
+		if err := d.VerifyBoolValue(protocol, StreamInfer); err != nil {
+			return err
+		}
+		if streamInfer, _ := cast.ToBoolE(protocol[StreamInfer]); streamInfer && transport == TransportREST {
+			errt = fmt.Errorf("'%s' is not supported with the '%s' transport", StreamInfer, TransportREST)
+			d.lc.Error(errt.Error())
+			return errt
+		}
+	}
+	if _, ok := protocol[StreamInflight]; ok {
+		if err := d.VerifyNumberValue(protocol, StreamInflight); err != nil {
+			return err
+		}
+	}
+
+	// Validate model input overrides, the input itself is validated against the model metadata
+	if layout, ok := protocol[Layout]; ok {
+		if _, err := parseLayout(fmt.Sprintf("%v", layout)); err != nil {
+			d.lc.Error(err.Error())
+			return err
+		}
+	}
+	for _, name := range []string{InputWidth, InputHeight} {
+		if _, err := parseInputSize(protocol, name); err != nil {
+			d.lc.Error(err.Error())
+			return err
+		}
+	}
+
+	// Validate batching, the batch dimension is validated against the model metadata
+	if _, err := parseBatchConfig(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate frame sampling
+	if _, err := parseSamplingConfig(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate the overlay of the annotated frames
+	if _, err := parseOverlayConfig(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate the privacy masking
+	if _, err := parsePrivacyConfig(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate the frame buffer
+	if _, err := parseFrameBuffer(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate the encoding of the live streams
+	if _, err := parseStreamConfig(protocol); err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+
+	// Validate timeout and retry overrides
+	if _, err := d.DevicePolicy(protocol); err != nil {
+		errt = fmt.Errorf("invalid timeout or retry settings for device '%s': %v", device.Name, err)
+		d.lc.Error(errt.Error())
+		return errt
+	}
+
+	// Validate TLS material when TLS is required
+	tlsInfo, err := parseTLSInfo(protocol)
+	if err != nil {
+		d.lc.Error(err.Error())
+		return err
+	}
+	if _, err := d.NewTLSConfig(tlsInfo); err != nil {
+		errt = fmt.Errorf("invalid TLS configuration for device '%s': %v", device.Name, err)
+		d.lc.Error(errt.Error())
+		return errt
+	}
 
 	return nil
 }
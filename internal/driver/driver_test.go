@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v4/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testModel  = "ssd"
+	testFrames = "../ovmstest/testdata/frames"
+)
+
+var testDetection = ovmstest.Detection{Label: 3, Confidence: 0.9, XMin: 0.25, YMin: 0.25, XMax: 0.5, YMax: 0.75}
+
+// driver without SDK and live HTTP server, replaying the test frames forever
+func newTestDriver(t *testing.T) *Driver {
+	d := &Driver{lc: logger.NewMockClient()}
+	d.initClients()
+	d.policy.InferTimeout = 2 * time.Second
+	d.policy.MetadataTimeout = 2 * time.Second
+	d.policy.Reconnect = RetryPolicy{MaxAttempts: 1, Interval: 10 * time.Millisecond}
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return ovmstest.NewReplaySource(uri, true)
+	}
+	t.Cleanup(func() { _ = d.Stop(true) })
+	return d
+}
+
+// start a fake OVMS returning testDetection and use it as the backend of a device
+func startFakeServer(t *testing.T, d *Driver, deviceName string) *ovmstest.Server {
+	server := ovmstest.NewServer(testModel)
+	server.SetDetections(testDetection)
+	server.Start()
+	t.Cleanup(server.Stop)
+
+	conn, err := server.Dial()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	d.backends[deviceName] = newGRPCBackend(grpc_client.NewGRPCInferenceServiceClient(conn))
+	return server
+}
+
+func testProtocols() map[string]models.ProtocolProperties {
+	return map[string]models.ProtocolProperties{
+		Protocol: {
+			"Host":     "localhost",
+			"Port":     "9000",
+			"Model":    testModel,
+			"Version":  "1",
+			"Uri":      testFrames,
+			"Score":    "0.5",
+			"Snapshot": "true",
+			"Record":   "false",
+			// replayed frames are all inferred, as the frames of a video file
+			FrameBuffer: FrameBufferAll,
+		},
+	}
+}
+
+func readRequest() []sdkModel.CommandRequest {
+	return []sdkModel.CommandRequest{{DeviceResourceName: "predict", Type: common.ValueTypeString}}
+}
+
+func TestHandleReadCommands(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "read-camera"
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+
+	// no result yet
+	res, err := d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	expected := OVMSResult{ModelName: testModel, InferFPS: "12.5", Snapshot: "data:image/jpeg;base64,", Scores: []float32{0.9}}
+	d.ovmsCh[deviceName] <- expected
+	res, err = d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	assert.Equal(t, "predict", res[0].DeviceResourceName)
+	value, err := res[0].StringValue()
+	require.NoError(t, err)
+	var actual OVMSResult
+	require.NoError(t, json.Unmarshal([]byte(value), &actual))
+	assert.Equal(t, expected, actual)
+
+	// a result is read once
+	res, err = d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+	require.NoError(t, err)
+	assert.Empty(t, res)
+
+	d.setCaptureState(deviceName, CaptureFailed)
+	_, err = d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+	assert.Error(t, err)
+}
+
+func TestReadMotion(t *testing.T) {
+	d := newTestDriver(t)
+	asyncCh := make(chan *sdkModel.AsyncValues, 4)
+	d.asyncCh = asyncCh
+	deviceName := "motion-camera"
+	motionRequest := []sdkModel.CommandRequest{{DeviceResourceName: MotionResource, Type: common.ValueTypeBool}}
+
+	// motion is not detected for the device
+	_, err := d.HandleReadCommands(deviceName, testProtocols(), motionRequest)
+	assert.ErrorContains(t, err, "motion detection of device motion-camera is not enabled")
+
+	d.setMotionState(deviceName, true)
+	d.setMotionState(deviceName, true)
+	d.setMotionState(deviceName, false)
+	res, err := d.HandleReadCommands(deviceName, testProtocols(), motionRequest)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	moving, err := res[0].BoolValue()
+	require.NoError(t, err)
+	assert.False(t, moving)
+
+	// every change is pushed once
+	require.Len(t, asyncCh, 2)
+	for _, expected := range []bool{true, false} {
+		values := <-asyncCh
+		assert.Equal(t, deviceName, values.DeviceName)
+		require.Len(t, values.CommandValues, 1)
+		moving, err := values.CommandValues[0].BoolValue()
+		require.NoError(t, err)
+		assert.Equal(t, expected, moving)
+	}
+}
+
+func TestDeviceLifecycle(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "lifecycle-camera"
+	server := startFakeServer(t, d, deviceName)
+
+	require.NoError(t, d.AddDevice(deviceName, testProtocols(), models.Unlocked))
+
+	var res []*sdkModel.CommandValue
+	require.Eventually(t, func() bool {
+		var err error
+		res, err = d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+		return err == nil && len(res) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	value, err := res[0].StringValue()
+	require.NoError(t, err)
+	var result OVMSResult
+	require.NoError(t, json.Unmarshal([]byte(value), &result))
+	assert.Equal(t, testModel, result.ModelName)
+	assert.Equal(t, []float32{0.9}, result.Scores)
+	assert.Equal(t, CaptureRunning, d.captureState(deviceName))
+
+	require.NoError(t, d.RemoveDevice(deviceName, testProtocols()))
+	assert.NotContains(t, d.captures, deviceName)
+	assert.NotContains(t, d.backends, deviceName)
+	assert.Empty(t, d.captureState(deviceName))
+
+	// the capture goroutine is gone, no more inference
+	count := server.InferCount()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, server.InferCount())
+	res, err = d.HandleReadCommands(deviceName, testProtocols(), readRequest())
+	require.NoError(t, err)
+	assert.Empty(t, res)
+}
+
+func TestRemoveBlockedDevice(t *testing.T) {
+	d := newTestDriver(t)
+	timeout := captureStopTimeout
+	captureStopTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { captureStopTimeout = timeout })
+	deviceName := "blocked-camera"
+
+	// a capture goroutine blocked in a camera read, holding a pooled connection
+	var dials atomic.Int32
+	_, err := d.grpcPool.Acquire("ovms:9000", "ovms:9000", countingDial(&dials))
+	require.NoError(t, err)
+	d.grpcConnKeys[deviceName] = []string{"ovms:9000"}
+	worker := &captureWorker{stop: make(chan struct{}), done: make(chan struct{})}
+	d.captures[deviceName] = worker
+
+	removed := make(chan error)
+	go func() { removed <- d.RemoveDevice(deviceName, testProtocols()) }()
+
+	// the driver is not locked while the capture is waited for
+	time.Sleep(50 * time.Millisecond)
+	require.True(t, d.mu.TryLock())
+	d.mu.Unlock()
+	require.NoError(t, <-removed)
+	assert.NotContains(t, d.grpcConnKeys, deviceName)
+
+	// the connection is kept until the capture goroutine exits
+	assert.Equal(t, 1, poolRefs(d.grpcPool, "ovms:9000"))
+	close(worker.done)
+	assert.Eventually(t, func() bool { return poolRefs(d.grpcPool, "ovms:9000") == 0 }, time.Second, 10*time.Millisecond)
+}
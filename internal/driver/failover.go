@@ -0,0 +1,251 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// interval between two readiness checks of the endpoints of a device
+const healthCheckInterval = 5 * time.Second
+
+// ovmsEndpoint is one OVMS server of an endpoint group
+type ovmsEndpoint struct {
+	address string
+	backend InferenceBackend
+	healthy atomic.Bool
+	// exponentially weighted moving average of successful call latency, in nanoseconds
+	latency atomic.Int64
+}
+
+// record latency of a successful call
+func (e *ovmsEndpoint) observe(elapsed time.Duration) {
+	old := e.latency.Load()
+	if old == 0 {
+		e.latency.Store(int64(elapsed))
+		return
+	}
+	e.latency.Store(old*4/5 + int64(elapsed)/5)
+}
+
+// endpointGroup balances the requests of one device over several OVMS endpoints,
+// failing over to the next one when an endpoint is not ready or UNAVAILABLE.
+// It is the InferenceBackend of the device so the capture pipeline is unaware of it.
+type endpointGroup struct {
+	lc        logger.LoggingClient
+	endpoints []*ovmsEndpoint
+	balancing string
+	next      atomic.Uint64
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// parse the endpoint list of a device, 'Endpoints' takes precedence over 'Host' and 'Port'
+func parseEndpoints(host string, port string, endpoints string) ([]string, error) {
+	if strings.TrimSpace(endpoints) == "" {
+		return []string{host + ":" + port}, nil
+	}
+
+	var addresses []string
+	for _, address := range strings.Split(endpoints, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("invalid endpoint '%s' in '%s', expected host:port", address, Endpoints)
+		}
+		addresses = append(addresses, address)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("'%s' is empty, please configure at least one host:port", Endpoints)
+	}
+	return addresses, nil
+}
+
+// validate balancing strategy, round-robin is the default
+func parseBalancing(balancing string) (string, error) {
+	switch balancing {
+	case "":
+		return BalancingRoundRobin, nil
+	case BalancingRoundRobin, BalancingLeastLatency:
+		return balancing, nil
+	default:
+		return "", fmt.Errorf("invalid value for '%s', please configure '%s' or '%s'", Balancing, BalancingRoundRobin, BalancingLeastLatency)
+	}
+}
+
+func newEndpointGroup(lc logger.LoggingClient, balancing string, addresses []string, backends []InferenceBackend) *endpointGroup {
+	g := &endpointGroup{
+		lc:        lc,
+		balancing: balancing,
+	}
+	for i, address := range addresses {
+		endpoint := &ovmsEndpoint{address: address, backend: backends[i]}
+		endpoint.healthy.Store(true)
+		g.endpoints = append(g.endpoints, endpoint)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.wg.Add(1)
+	go g.healthCheck(ctx)
+
+	return g
+}
+
+// Close stops the readiness checks, connections are owned by the connection pool
+func (g *endpointGroup) Close() {
+	g.cancel()
+	g.wg.Wait()
+}
+
+// periodically check readiness of every endpoint so failed ones come back into rotation
+func (g *endpointGroup) healthCheck(ctx context.Context) {
+	defer g.wg.Done()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, endpoint := range g.endpoints {
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckInterval)
+			ready, err := endpoint.backend.ServerReady(checkCtx)
+			cancel()
+			g.setHealthy(endpoint, err == nil && ready)
+		}
+	}
+}
+
+// log transitions only, not every check
+func (g *endpointGroup) setHealthy(endpoint *ovmsEndpoint, healthy bool) {
+	if endpoint.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		g.lc.Infof("OVMS endpoint %s is back in rotation", endpoint.address)
+	} else {
+		g.lc.Warnf("OVMS endpoint %s is not ready, failing over", endpoint.address)
+	}
+}
+
+// order endpoints by preference: healthy ones first, by the balancing strategy
+func (g *endpointGroup) candidates() []*ovmsEndpoint {
+	count := len(g.endpoints)
+	ordered := make([]*ovmsEndpoint, 0, count)
+	var unhealthy []*ovmsEndpoint
+
+	start := int(g.next.Add(1)-1) % count
+	if g.balancing == BalancingLeastLatency {
+		// endpoints without measurement yet sort first so they get measured
+		best := -1
+		for i, endpoint := range g.endpoints {
+			if !endpoint.healthy.Load() {
+				continue
+			}
+			if best < 0 || endpoint.latency.Load() < g.endpoints[best].latency.Load() {
+				best = i
+			}
+		}
+		if best >= 0 {
+			start = best
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		endpoint := g.endpoints[(start+i)%count]
+		if endpoint.healthy.Load() {
+			ordered = append(ordered, endpoint)
+		} else {
+			unhealthy = append(unhealthy, endpoint)
+		}
+	}
+	// still try unhealthy endpoints as a last resort
+	return append(ordered, unhealthy...)
+}
+
+// invoke call on the preferred endpoint, failing over on UNAVAILABLE
+func invoke[T any](g *endpointGroup, call func(backend InferenceBackend) (T, error)) (T, error) {
+	var result T
+	var err error
+	for _, endpoint := range g.candidates() {
+		start := time.Now()
+		result, err = call(endpoint.backend)
+		if err == nil {
+			endpoint.observe(time.Since(start))
+			g.setHealthy(endpoint, true)
+			return result, nil
+		}
+
+		switch status.Code(err) {
+		case codes.Unavailable:
+			g.setHealthy(endpoint, false)
+			continue
+		case codes.DeadlineExceeded:
+			// the deadline is shared by all attempts, nothing left to fail over with
+			g.setHealthy(endpoint, false)
+			return result, err
+		default:
+			return result, err
+		}
+	}
+	return result, err
+}
+
+func (g *endpointGroup) ServerReady(ctx context.Context) (bool, error) {
+	return invoke(g, func(backend InferenceBackend) (bool, error) {
+		return backend.ServerReady(ctx)
+	})
+}
+
+func (g *endpointGroup) ModelReady(ctx context.Context, model string, version string) (bool, error) {
+	return invoke(g, func(backend InferenceBackend) (bool, error) {
+		return backend.ModelReady(ctx, model, version)
+	})
+}
+
+func (g *endpointGroup) ModelMetadata(ctx context.Context, model string, version string) (*ModelMetadata, error) {
+	return invoke(g, func(backend InferenceBackend) (*ModelMetadata, error) {
+		return backend.ModelMetadata(ctx, model, version)
+	})
+}
+
+func (g *endpointGroup) ModelInfer(ctx context.Context, request *InferRequest) (*InferResponse, error) {
+	return invoke(g, func(backend InferenceBackend) (*InferResponse, error) {
+		return backend.ModelInfer(ctx, request)
+	})
+}
+
+// ModelStreamInfer opens the stream on the preferred endpoint, a broken stream is not failed over
+func (g *endpointGroup) ModelStreamInfer(ctx context.Context) (grpc_client.GRPCInferenceService_ModelStreamInferClient, error) {
+	return invoke(g, func(backend InferenceBackend) (grpc_client.GRPCInferenceService_ModelStreamInferClient, error) {
+		stream, ok := backend.(streamBackend)
+		if !ok {
+			return nil, status.Error(codes.Unimplemented, "streaming inference is not supported by the transport")
+		}
+		return stream.ModelStreamInfer(ctx)
+	})
+}
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeBackend answers inference requests with its name after a latency, or fails with err
+type fakeBackend struct {
+	name string
+
+	mu      sync.Mutex
+	latency time.Duration
+	err     error
+	calls   int
+}
+
+func (b *fakeBackend) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.err = err
+}
+
+func (b *fakeBackend) callCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls
+}
+
+func (b *fakeBackend) call() error {
+	b.mu.Lock()
+	b.calls++
+	latency, err := b.latency, b.err
+	b.mu.Unlock()
+	time.Sleep(latency)
+	return err
+}
+
+func (b *fakeBackend) ServerReady(ctx context.Context) (bool, error) {
+	return b.call() == nil, nil
+}
+
+func (b *fakeBackend) ModelReady(ctx context.Context, model string, version string) (bool, error) {
+	err := b.call()
+	return err == nil, err
+}
+
+func (b *fakeBackend) ModelMetadata(ctx context.Context, model string, version string) (*ModelMetadata, error) {
+	if err := b.call(); err != nil {
+		return nil, err
+	}
+	return &ModelMetadata{Name: b.name}, nil
+}
+
+func (b *fakeBackend) ModelInfer(ctx context.Context, request *InferRequest) (*InferResponse, error) {
+	if err := b.call(); err != nil {
+		return nil, err
+	}
+	return &InferResponse{ModelName: b.name}, nil
+}
+
+// endpoint group of fake backends named by their address
+func newTestEndpointGroup(t *testing.T, balancing string, names ...string) (*endpointGroup, []*fakeBackend) {
+	var fakes []*fakeBackend
+	var backends []InferenceBackend
+	for _, name := range names {
+		fake := &fakeBackend{name: name}
+		fakes = append(fakes, fake)
+		backends = append(backends, fake)
+	}
+	group := newEndpointGroup(logger.NewMockClient(), balancing, names, backends)
+	t.Cleanup(group.Close)
+	return group, fakes
+}
+
+// name of the endpoint serving an inference request
+func inferEndpoint(t *testing.T, group *endpointGroup) string {
+	response, err := group.ModelInfer(context.Background(), &InferRequest{})
+	require.NoError(t, err)
+	return response.ModelName
+}
+
+func healthy(group *endpointGroup) []bool {
+	var states []bool
+	for _, endpoint := range group.endpoints {
+		states = append(states, endpoint.healthy.Load())
+	}
+	return states
+}
+
+func TestParseEndpoints(t *testing.T) {
+	addresses, err := parseEndpoints("ovms", "9001", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ovms:9001"}, addresses)
+	addresses, err = parseEndpoints("ovms", "9001", " ovms-1:9001, ,ovms-2:9001 ")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ovms-1:9001", "ovms-2:9001"}, addresses)
+
+	_, err = parseEndpoints("", "", "ovms-1")
+	assert.ErrorContains(t, err, "expected host:port")
+	_, err = parseEndpoints("", "", " , ")
+	assert.ErrorContains(t, err, "is empty")
+	_, err = parseBalancing("random")
+	assert.Error(t, err)
+}
+
+func TestEndpointGroupRoundRobin(t *testing.T) {
+	group, fakes := newTestEndpointGroup(t, BalancingRoundRobin, "a", "b", "c")
+
+	var served []string
+	for i := 0; i < 6; i++ {
+		served = append(served, inferEndpoint(t, group))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, served)
+	for _, fake := range fakes {
+		assert.Equal(t, 2, fake.callCount(), fake.name)
+	}
+}
+
+func TestEndpointGroupLeastLatency(t *testing.T) {
+	group, fakes := newTestEndpointGroup(t, BalancingLeastLatency, "slow", "fast")
+	fakes[0].latency = 20 * time.Millisecond
+
+	// the endpoints without measurement are measured first, then the fastest is preferred
+	assert.Equal(t, "slow", inferEndpoint(t, group))
+	assert.Equal(t, "fast", inferEndpoint(t, group))
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "fast", inferEndpoint(t, group))
+	}
+	assert.Equal(t, 1, fakes[0].callCount())
+
+	// a failing endpoint is skipped whatever its latency
+	fakes[1].fail(status.Error(codes.Unavailable, "connection refused"))
+	assert.Equal(t, "slow", inferEndpoint(t, group))
+	assert.Equal(t, "slow", inferEndpoint(t, group))
+	assert.Equal(t, []bool{true, false}, healthy(group))
+}
+
+func TestEndpointGroupFailover(t *testing.T) {
+	group, fakes := newTestEndpointGroup(t, BalancingRoundRobin, "a", "b")
+
+	// an UNAVAILABLE endpoint is failed over and left out of the rotation
+	fakes[0].fail(status.Error(codes.Unavailable, "connection refused"))
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, "b", inferEndpoint(t, group))
+	}
+	assert.Equal(t, 1, fakes[0].callCount())
+	assert.Equal(t, []bool{false, true}, healthy(group))
+
+	// other errors are the answer of the model server, they are not failed over
+	fakes[0].fail(nil)
+	fakes[1].fail(status.Error(codes.InvalidArgument, "invalid input"))
+	_, err := group.ModelInfer(context.Background(), &InferRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, 1, fakes[0].callCount())
+
+	// a timeout leaves no time to fail over, the endpoint is left out
+	fakes[1].fail(status.Error(codes.DeadlineExceeded, "deadline exceeded"))
+	_, err = group.ModelInfer(context.Background(), &InferRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Equal(t, []bool{false, false}, healthy(group))
+
+	// an endpoint back in rotation by the health check is preferred
+	fakes[1].fail(nil)
+	group.setHealthy(group.endpoints[0], true)
+	metadata, err := group.ModelMetadata(context.Background(), "ssd", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "a", metadata.Name)
+	assert.Equal(t, []bool{true, false}, healthy(group))
+}
+
+func TestEndpointGroupAllDown(t *testing.T) {
+	group, fakes := newTestEndpointGroup(t, BalancingRoundRobin, "a", "b", "c")
+	for _, fake := range fakes {
+		fake.fail(status.Error(codes.Unavailable, "connection refused"))
+	}
+
+	// every endpoint is tried once, the last error is returned
+	_, err := group.ModelInfer(context.Background(), &InferRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	for _, fake := range fakes {
+		assert.Equal(t, 1, fake.callCount(), fake.name)
+	}
+	assert.Equal(t, []bool{false, false, false}, healthy(group))
+	_, err = group.ModelReady(context.Background(), "ssd", "1")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// unhealthy endpoints are still tried as a last resort, a recovered one is back in rotation
+	fakes[2].fail(nil)
+	assert.Equal(t, "c", inferEndpoint(t, group))
+	assert.Equal(t, []bool{false, false, true}, healthy(group))
+	assert.Equal(t, "c", inferEndpoint(t, group))
+
+	// fake backends cannot stream
+	_, err = group.ModelStreamInfer(context.Background())
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,185 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+// interval of the frame counters log
+const frameStatsInterval = time.Minute
+
+// errCaptureStopped is returned when a frame is awaited while the capture stops
+var errCaptureStopped = errors.New("capture stopped")
+
+// CaptureStats counts the frames of a device since the service started
+type CaptureStats struct {
+	Read     atomic.Int64
+	Dropped  atomic.Int64
+	Inferred atomic.Int64
+}
+
+// get the frame counters of a device, created on first use
+func (d *Driver) captureStats(deviceName string) *CaptureStats {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	stats, ok := d.stats[deviceName]
+	if !ok {
+		stats = &CaptureStats{}
+		d.stats[deviceName] = stats
+	}
+	return stats
+}
+
+// parse 'FrameBuffer' of a device, true when only the latest frame is kept
+func parseFrameBuffer(protocol models.ProtocolProperties) (bool, error) {
+	mode, _ := cast.ToStringE(protocol[FrameBuffer])
+	switch strings.ToLower(mode) {
+	case "", FrameBufferLatest:
+		return true, nil
+	case FrameBufferAll:
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid '%s' %s, must be %s or %s", FrameBuffer, mode, FrameBufferLatest, FrameBufferAll)
+}
+
+// bufferedFrame is a frame and the time it was read from the source
+type bufferedFrame struct {
+	img  gocv.Mat
+	read time.Time
+}
+
+// frameBuffer is filled by a goroutine draining a frame source, so the source never waits for inference.
+// Keeping only the latest frame, frames not taken in time are dropped and the results follow the live scene;
+// otherwise the reader waits for every frame to be taken, as needed by video files.
+type frameBuffer struct {
+	latest bool
+	stats  *CaptureStats
+	frames chan bufferedFrame
+	stop   chan struct{}
+	// done is closed when the reader exits, err tells why
+	done chan struct{}
+	err  error
+}
+
+// start reading the frames of a device from a source, the source must not be used until the buffer is closed
+func (d *Driver) startFrameBuffer(deviceName string, source FrameSource, latest bool) *frameBuffer {
+	b := &frameBuffer{
+		latest: latest,
+		stats:  d.captureStats(deviceName),
+		frames: make(chan bufferedFrame, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(b.done)
+		b.err = b.read(source)
+		if b.err != nil {
+			b.err = fmt.Errorf("%v, device: %s", b.err, deviceName)
+		}
+	}()
+	go d.logCaptureStats(deviceName, b.stats, b.done)
+	return b
+}
+
+func (b *frameBuffer) read(source FrameSource) error {
+	for {
+		img := gocv.NewMat()
+		if ok := source.Read(&img); !ok {
+			img.Close()
+			return errors.New("failed to read image from MJPEG stream")
+		}
+		b.stats.Read.Add(1)
+		frame := bufferedFrame{img: img, read: time.Now()}
+
+		if b.latest {
+			// replace the frame not taken yet, the reader is the only sender so the slot is free afterwards
+			select {
+			case b.frames <- frame:
+				continue
+			default:
+			}
+			select {
+			case previous := <-b.frames:
+				previous.img.Close()
+				b.stats.Dropped.Add(1)
+			default:
+			}
+			b.frames <- frame
+		} else {
+			select {
+			case b.frames <- frame:
+			case <-b.stop:
+				img.Close()
+			}
+		}
+
+		select {
+		case <-b.stop:
+			return nil
+		default:
+		}
+	}
+}
+
+// Next waits for the next frame, the caller closes it.
+// It fails with the read error once the source is exhausted, or errCaptureStopped when 'stop' is closed.
+func (b *frameBuffer) Next(stop <-chan struct{}) (bufferedFrame, error) {
+	select {
+	case frame := <-b.frames:
+		return frame, nil
+	case <-b.done:
+		// the last frame is delivered before the error
+		select {
+		case frame := <-b.frames:
+			return frame, nil
+		default:
+		}
+		if b.err == nil {
+			return bufferedFrame{}, errCaptureStopped
+		}
+		return bufferedFrame{}, b.err
+	case <-stop:
+		return bufferedFrame{}, errCaptureStopped
+	}
+}
+
+// Close stops the reader and frees the frame not taken
+func (b *frameBuffer) Close() {
+	close(b.stop)
+	<-b.done
+	select {
+	case frame := <-b.frames:
+		frame.img.Close()
+	default:
+	}
+}
+
+// log the frame counters of a device periodically while its frames are read
+func (d *Driver) logCaptureStats(deviceName string, stats *CaptureStats, done <-chan struct{}) {
+	ticker := time.NewTicker(frameStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.lc.Debugf("Device %s frames read: %d, dropped: %d, inferred: %d", deviceName, stats.Read.Load(), stats.Dropped.Load(), stats.Inferred.Load())
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+// frame source of 'count' frames, the rows of a frame are its number, endless when count is 0
+type countingSource struct {
+	count int
+	read  int
+}
+
+func (s *countingSource) Read(img *gocv.Mat) bool {
+	if s.count > 0 && s.read == s.count {
+		return false
+	}
+	s.read++
+	*img = gocv.NewMatWithSize(s.read, 4, gocv.MatTypeCV8UC3)
+	return true
+}
+
+func (s *countingSource) Close() error {
+	return nil
+}
+
+func TestParseFrameBuffer(t *testing.T) {
+	latest, err := parseFrameBuffer(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.True(t, latest)
+	latest, err = parseFrameBuffer(models.ProtocolProperties{FrameBuffer: "ALL"})
+	require.NoError(t, err)
+	assert.False(t, latest)
+	_, err = parseFrameBuffer(models.ProtocolProperties{FrameBuffer: "fifo"})
+	assert.ErrorContains(t, err, "invalid 'FrameBuffer' fifo")
+}
+
+func TestFrameBuffer(t *testing.T) {
+	t.Run("latest", func(t *testing.T) {
+		d := newTestDriver(t)
+		frames := d.startFrameBuffer("latest-camera", &countingSource{count: 5}, true)
+		defer frames.Close()
+		<-frames.done
+
+		// the frames not taken in time were dropped
+		frame, err := frames.Next(nil)
+		require.NoError(t, err)
+		assert.Equal(t, 5, frame.img.Rows())
+		frame.img.Close()
+		_, err = frames.Next(nil)
+		assert.ErrorContains(t, err, "failed to read image from MJPEG stream, device: latest-camera")
+
+		stats := d.captureStats("latest-camera")
+		assert.EqualValues(t, 5, stats.Read.Load())
+		assert.EqualValues(t, 4, stats.Dropped.Load())
+	})
+
+	t.Run("all", func(t *testing.T) {
+		d := newTestDriver(t)
+		frames := d.startFrameBuffer("all-camera", &countingSource{count: 3}, false)
+		defer frames.Close()
+
+		for i := 1; i <= 3; i++ {
+			frame, err := frames.Next(nil)
+			require.NoError(t, err)
+			assert.Equal(t, i, frame.img.Rows())
+			frame.img.Close()
+		}
+		_, err := frames.Next(nil)
+		assert.Error(t, err)
+		assert.Zero(t, d.captureStats("all-camera").Dropped.Load())
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		d := newTestDriver(t)
+		frames := d.startFrameBuffer("endless-camera", &countingSource{}, false)
+		stop := make(chan struct{})
+		close(stop)
+		frame, err := frames.Next(nil)
+		require.NoError(t, err)
+		frame.img.Close()
+
+		closed := make(chan struct{})
+		go func() {
+			frames.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("frame buffer not closed")
+		}
+		_, err = frames.Next(stop)
+		assert.ErrorIs(t, err, errCaptureStopped)
+	})
+}
+
+func TestProcessMjpegStreamLatestFrame(t *testing.T) {
+	deviceName := "live-camera"
+	d := newTestDriver(t)
+	server := startFakeServer(t, d, deviceName)
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+	protocols := testProtocols()
+	protocols[Protocol][FrameBuffer] = FrameBufferLatest
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return &pacedSource{FrameSource: &countingSource{count: 50}, interval: time.Millisecond}, nil
+	}
+
+	// frames keep being read while OVMS answers slowly
+	server.SetLatency(20 * time.Millisecond)
+	err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+	assert.ErrorContains(t, err, "failed to read image")
+
+	stats := d.captureStats(deviceName)
+	assert.EqualValues(t, 50, stats.Read.Load())
+	assert.Positive(t, stats.Dropped.Load())
+	assert.Less(t, server.InferCount(), int64(50))
+	assert.Equal(t, server.InferCount(), stats.Inferred.Load())
+}
@@ -0,0 +1,422 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// playlist of the HLS stream of a device
+	hlsPlaylist = "index.m3u8"
+	// H.264 fourcc of the segments, encoded by the FFmpeg backend of OpenCV
+	hlsCodec = "avc1"
+	// segments kept on disk once out of the playlist, for the players still loading them
+	hlsRetainedSegments = 2
+	// the frames are encoded for this long after the last request, at least
+	hlsIdleTimeout = 10 * time.Second
+	// layout of the directory of a recording
+	hlsRecordLayout = "20060102-150405"
+
+	defaultHLSSegmentDuration = 2 * time.Second
+	defaultHLSPlaylistSize    = 5
+	defaultHLSFrameRate       = 15.0
+)
+
+// HLSConfig is the HLS output of the annotated frames of a device
+type HLSConfig struct {
+	Enabled         bool
+	SegmentDuration time.Duration
+	// segments listed in the rolling playlist
+	PlaylistSize int
+	// constant frame rate of the segments, frames are repeated or skipped to keep it
+	FrameRate float64
+	// RecordDir keeps every segment of the device in a directory of it, empty for live only
+	RecordDir string
+}
+
+// Record reports whether the segments are recorded
+func (c HLSConfig) Record() bool {
+	return c.RecordDir != ""
+}
+
+// segmentWriter encodes the frames of an HLS segment, gocv.VideoWriter is the default one
+type segmentWriter interface {
+	Write(img gocv.Mat) error
+	Close() error
+}
+
+// open the writer of a segment file
+type segmentWriterFactory func(path string, fps float64, width int, height int) (segmentWriter, error)
+
+// open an H.264 MPEG-TS segment with FFmpeg
+func openH264Segment(path string, fps float64, width int, height int) (segmentWriter, error) {
+	writer, err := gocv.VideoWriterFileWithAPI(path, gocv.VideoCaptureFFmpeg, hlsCodec, fps, width, height, true)
+	if err != nil {
+		return nil, err
+	}
+	if !writer.IsOpened() {
+		_ = writer.Close()
+		return nil, fmt.Errorf("FFmpeg backend of OpenCV can not encode %s with the %s codec", path, hlsCodec)
+	}
+	return writer, nil
+}
+
+type hlsSegment struct {
+	sequence int
+	duration time.Duration
+	// the segment follows a gap of the frames
+	discontinuity bool
+}
+
+// hlsStream encodes frames into segments of a directory, listed by its playlist. The frames are
+// encoded in the background, only while the stream is requested unless it is recorded.
+type hlsStream struct {
+	config HLSConfig
+	dir    string
+	open   segmentWriterFactory
+
+	// encoder state, owned by the encoding goroutine
+	writer segmentWriter
+	// frames are scaled to the size of the current segment
+	size  image.Point
+	frame gocv.Mat
+	// start and frames of the current run of frames without gap, frames of the current segment
+	runStart  time.Time
+	runFrames int
+	frames    int
+	last      time.Time
+	// the next segment, or the current one, follows a gap
+	discontinuity        bool
+	segmentDiscontinuity bool
+
+	mu sync.Mutex
+	// latest frame waiting for the encoder, replaced while it is busy
+	pending     gocv.Mat
+	pendingTime time.Time
+	queued      bool
+	wake        chan struct{}
+	stop        chan struct{}
+	done        chan struct{}
+	// last request of the playlist or of a segment
+	requested time.Time
+	// sequence number of the current segment, and the complete segments of the playlist
+	sequence int
+	segments []hlsSegment
+	// discontinuities out of the playlist
+	discontinuities int
+	// the encoder failed, the stream is disabled
+	err error
+}
+
+func newHLSStream(config HLSConfig, dir string, open segmentWriterFactory) *hlsStream {
+	s := &hlsStream{
+		config:  config,
+		dir:     dir,
+		open:    open,
+		frame:   gocv.NewMat(),
+		pending: gocv.NewMat(),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *hlsStream) segmentName(sequence int) string {
+	return fmt.Sprintf("segment-%d.ts", sequence)
+}
+
+// frames of a complete segment
+func (s *hlsStream) segmentFrames() int {
+	return max(1, int(math.Round(s.config.SegmentDuration.Seconds()*s.config.FrameRate)))
+}
+
+// active reports whether the frames read at 'now' are encoded: when recorded, or requested lately
+func (s *hlsStream) active(now time.Time) bool {
+	idle := max(hlsIdleTimeout, time.Duration(s.config.PlaylistSize)*s.config.SegmentDuration)
+	return s.config.Record() || (!s.requested.IsZero() && now.Sub(s.requested) < idle)
+}
+
+// Write queues a frame read at 'now' for the encoder without waiting for it, a frame still queued is replaced
+func (s *hlsStream) Write(img gocv.Mat, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || !s.active(now) {
+		return nil
+	}
+	if err := img.CopyTo(&s.pending); err != nil {
+		return err
+	}
+	s.pendingTime, s.queued = now, true
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// encode the queued frames until stopped, the stream is disabled on the first error
+func (s *hlsStream) run() {
+	defer close(s.done)
+	img := gocv.NewMat()
+	defer img.Close()
+	for {
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+		s.mu.Lock()
+		if !s.queued {
+			s.mu.Unlock()
+			continue
+		}
+		img, s.pending = s.pending, img
+		now := s.pendingTime
+		s.queued = false
+		s.mu.Unlock()
+
+		if err := s.write(img, now); err != nil {
+			if s.writer != nil {
+				_ = s.writer.Close()
+				s.writer = nil
+			}
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+		}
+	}
+}
+
+// encode a frame read at 'now'. Frames are repeated or skipped to play in real time at the frame rate
+// of the segments, which last SegmentDuration but the last one before a gap. A frame after a gap of
+// more than a segment starts a new segment, marked as a discontinuity.
+func (s *hlsStream) write(img gocv.Mat, now time.Time) error {
+	if !s.last.IsZero() && now.Sub(s.last) > s.config.SegmentDuration {
+		if s.writer != nil {
+			if err := s.closeSegment(); err != nil {
+				return err
+			}
+		}
+		s.runStart, s.discontinuity = time.Time{}, true
+	}
+	s.last = now
+	if s.runStart.IsZero() {
+		s.runStart, s.runFrames = now, 0
+	}
+
+	due := int(now.Sub(s.runStart).Seconds()*s.config.FrameRate) + 1
+	for s.runFrames < due {
+		if s.writer == nil {
+			if err := s.openSegment(img); err != nil {
+				return err
+			}
+		}
+		frame := img
+		if img.Cols() != s.size.X || img.Rows() != s.size.Y {
+			if err := gocv.Resize(img, &s.frame, s.size, 0, 0, gocv.InterpolationArea); err != nil {
+				return err
+			}
+			frame = s.frame
+		}
+		if err := s.writer.Write(frame); err != nil {
+			return err
+		}
+		s.runFrames++
+		s.frames++
+		if s.frames >= s.segmentFrames() {
+			if err := s.closeSegment(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// open the next segment with the size of a frame
+func (s *hlsStream) openSegment(img gocv.Mat) error {
+	// H.264 needs even dimensions
+	s.size = image.Pt(img.Cols()&^1, img.Rows()&^1)
+	if s.size.X == 0 || s.size.Y == 0 {
+		return fmt.Errorf("frame of %dx%d is too small for HLS", img.Cols(), img.Rows())
+	}
+	s.mu.Lock()
+	sequence := s.sequence
+	s.mu.Unlock()
+	writer, err := s.open(filepath.Join(s.dir, s.segmentName(sequence)), s.config.FrameRate, s.size.X, s.size.Y)
+	if err != nil {
+		return err
+	}
+	s.writer, s.frames = writer, 0
+	s.segmentDiscontinuity, s.discontinuity = s.discontinuity, false
+	return nil
+}
+
+// close the current segment and add it to the playlist. Out of a live playlist, the oldest segments are removed.
+func (s *hlsStream) closeSegment() error {
+	err := s.writer.Close()
+	s.writer = nil
+	if err != nil {
+		return err
+	}
+	duration := time.Duration(float64(s.frames) / s.config.FrameRate * float64(time.Second))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.segments = append(s.segments, hlsSegment{sequence: s.sequence, duration: duration, discontinuity: s.segmentDiscontinuity})
+	s.sequence++
+	if s.config.Record() {
+		return s.savePlaylist(false)
+	}
+	if dropped := len(s.segments) - s.config.PlaylistSize; dropped > 0 {
+		for _, segment := range s.segments[:dropped] {
+			if segment.discontinuity {
+				s.discontinuities++
+			}
+		}
+		s.segments = s.segments[dropped:]
+	}
+	if retired := s.segments[0].sequence - hlsRetainedSegments - 1; retired >= 0 {
+		err := os.Remove(filepath.Join(s.dir, s.segmentName(retired)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// the playlist of the complete segments, 'ended' once the recording is over
+func (s *hlsStream) playlist(ended bool) []byte {
+	var playlist strings.Builder
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	// the segments never last longer than SegmentDuration, the target duration never changes
+	fmt.Fprintf(&playlist, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(s.config.SegmentDuration.Seconds())))
+	if s.config.Record() {
+		playlist.WriteString("#EXT-X-PLAYLIST-TYPE:EVENT\n")
+	}
+	fmt.Fprintf(&playlist, "#EXT-X-MEDIA-SEQUENCE:%d\n", s.segments[0].sequence)
+	if s.discontinuities > 0 {
+		fmt.Fprintf(&playlist, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.discontinuities)
+	}
+	for _, segment := range s.segments {
+		if segment.discontinuity {
+			playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", segment.duration.Seconds(), s.segmentName(segment.sequence))
+	}
+	if ended {
+		playlist.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return []byte(playlist.String())
+}
+
+// save the playlist of a recording next to its segments, for its playback once the device is gone
+func (s *hlsStream) savePlaylist(ended bool) error {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	path := filepath.Join(s.dir, hlsPlaylist)
+	if err := os.WriteFile(path+".tmp", s.playlist(ended), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Playlist is the playlist of the complete segments, nil before the first one.
+// It is a request of the stream, the frames are encoded from now on.
+func (s *hlsStream) Playlist() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requested = time.Now()
+	if len(s.segments) == 0 {
+		return nil, s.err
+	}
+	return s.playlist(false), nil
+}
+
+// ServeHTTP serves the playlist, or a complete segment still on disk
+func (s *hlsStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	if file == hlsPlaylist {
+		playlist, err := s.Playlist()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if playlist == nil {
+			// the first segment is on its way
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.config.SegmentDuration.Seconds()))))
+			http.Error(w, "no segment yet", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(playlist)
+		return
+	}
+
+	str, _ := strings.CutPrefix(file, "segment-")
+	str, _ = strings.CutSuffix(str, ".ts")
+	sequence, err := strconv.Atoi(str)
+	s.mu.Lock()
+	complete := err == nil && sequence >= 0 && file == s.segmentName(sequence) && sequence < s.sequence
+	if complete {
+		s.requested = time.Now()
+	}
+	s.mu.Unlock()
+	if !complete {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, filepath.Join(s.dir, file))
+}
+
+// Close stops encoding. A recording is completed with the current segment and kept, a live stream
+// discards it and its segments are removed.
+func (s *hlsStream) Close() {
+	close(s.stop)
+	<-s.done
+	if s.writer != nil {
+		if s.config.Record() && s.frames > 0 {
+			_ = s.closeSegment()
+		} else {
+			_ = s.writer.Close()
+			s.writer = nil
+		}
+	}
+	s.frame.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending.Close()
+	if s.config.Record() {
+		_ = s.savePlaylist(true)
+	} else {
+		_ = os.RemoveAll(s.dir)
+	}
+	s.segments = nil
+	s.err = errors.New("stream closed")
+}
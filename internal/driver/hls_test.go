@@ -0,0 +1,244 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+// fakeSegment writes a line per frame with the frame size
+type fakeSegment struct {
+	file *os.File
+}
+
+func (f *fakeSegment) Write(img gocv.Mat) error {
+	_, err := fmt.Fprintf(f.file, "%dx%d\n", img.Cols(), img.Rows())
+	return err
+}
+
+func (f *fakeSegment) Close() error {
+	return f.file.Close()
+}
+
+func openFakeSegment(path string, fps float64, width int, height int) (segmentWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeSegment{file: file}, nil
+}
+
+func TestHLSStream(t *testing.T) {
+	dir := t.TempDir()
+	stream := newHLSStream(HLSConfig{SegmentDuration: time.Second, PlaylistSize: 2, FrameRate: 4}, dir, openFakeSegment)
+	frame := gocv.NewMatWithSize(9, 17, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	playlist, err := stream.Playlist()
+	require.NoError(t, err)
+	assert.Nil(t, playlist)
+
+	// frames at 2 fps are repeated to the rate of the segments, and cropped to even sizes
+	start := time.Now()
+	for i := 0; i <= 10; i++ {
+		require.NoError(t, stream.write(frame, start.Add(time.Duration(i)*500*time.Millisecond)))
+	}
+	playlist, err = stream.Playlist()
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:3\n"+
+		"#EXTINF:1.000,\nsegment-3.ts\n#EXTINF:1.000,\nsegment-4.ts\n", string(playlist))
+	data, err := os.ReadFile(filepath.Join(dir, "segment-4.ts"))
+	require.NoError(t, err)
+	assert.Equal(t, "16x8\n16x8\n16x8\n16x8\n", string(data))
+
+	// the segments out of the playlist are kept for a while
+	_, err = os.Stat(filepath.Join(dir, "segment-1.ts"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "segment-0.ts"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// a gap ends the segment, the next one is not padded and follows a discontinuity
+	require.NoError(t, stream.write(frame, start.Add(8*time.Second)))
+	require.NoError(t, stream.write(frame, start.Add(9*time.Second)))
+	playlist, err = stream.Playlist()
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:5\n"+
+		"#EXTINF:0.250,\nsegment-5.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:1.000,\nsegment-6.ts\n", string(playlist))
+
+	// late frames never make a segment longer, the discontinuities out of the playlist are counted
+	require.NoError(t, stream.write(frame, start.Add(9900*time.Millisecond)))
+	require.NoError(t, stream.write(frame, start.Add(10800*time.Millisecond)))
+	require.NoError(t, stream.write(frame, start.Add(11700*time.Millisecond)))
+	playlist, err = stream.Playlist()
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-DISCONTINUITY-SEQUENCE:1\n"+
+		"#EXTINF:1.000,\nsegment-7.ts\n#EXTINF:1.000,\nsegment-8.ts\n", string(playlist))
+
+	stream.Close()
+	_, err = os.Stat(dir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// wait for the encoder to take the queued frame
+func waitEncoder(t *testing.T, stream *hlsStream) {
+	require.Eventually(t, func() bool {
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		return !stream.queued
+	}, time.Second, time.Millisecond)
+}
+
+func TestHLSStreamRequested(t *testing.T) {
+	stream := newHLSStream(HLSConfig{SegmentDuration: time.Second, PlaylistSize: 2, FrameRate: 4}, t.TempDir(), openFakeSegment)
+	defer stream.Close()
+	frame := gocv.NewMatWithSize(8, 8, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	// nothing is encoded before the stream is requested
+	start := time.Now()
+	require.NoError(t, stream.Write(frame, start))
+	stream.mu.Lock()
+	assert.False(t, stream.queued)
+	stream.mu.Unlock()
+	assert.True(t, stream.last.IsZero())
+
+	// the frames are encoded in the background once requested
+	playlist, err := stream.Playlist()
+	require.NoError(t, err)
+	assert.Nil(t, playlist)
+	for i := 0; i <= 4; i++ {
+		require.NoError(t, stream.Write(frame, start.Add(time.Duration(i)*250*time.Millisecond)))
+		waitEncoder(t, stream)
+	}
+	require.Eventually(t, func() bool {
+		playlist, err := stream.Playlist()
+		return err == nil && playlist != nil
+	}, time.Second, time.Millisecond)
+
+	// and no longer once idle
+	assert.False(t, stream.active(time.Now().Add(hlsIdleTimeout)))
+}
+
+func TestHLSStreamEncoderError(t *testing.T) {
+	failed := errors.New("no H.264 encoder")
+	stream := newHLSStream(HLSConfig{SegmentDuration: time.Second, PlaylistSize: 2, FrameRate: 4}, t.TempDir(),
+		func(string, float64, int, int) (segmentWriter, error) { return nil, failed })
+	defer stream.Close()
+	frame := gocv.NewMatWithSize(8, 8, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	// the stream is disabled
+	_, err := stream.Playlist()
+	require.NoError(t, err)
+	require.NoError(t, stream.Write(frame, time.Now()))
+	require.Eventually(t, func() bool {
+		_, err := stream.Playlist()
+		return errors.Is(err, failed)
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, stream.Write(frame, time.Now()))
+}
+
+func TestHLSRecord(t *testing.T) {
+	dir := t.TempDir()
+	config := HLSConfig{SegmentDuration: time.Second, PlaylistSize: 1, FrameRate: 4, RecordDir: dir}
+	stream := newHLSStream(config, dir, openFakeSegment)
+	frame := gocv.NewMatWithSize(8, 8, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+
+	// a recording is encoded without requests, and keeps every segment
+	start := time.Now()
+	assert.True(t, stream.active(start))
+	for i := 0; i <= 5; i++ {
+		require.NoError(t, stream.write(frame, start.Add(time.Duration(i)*500*time.Millisecond)))
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, hlsPlaylist))
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXTINF:1.000,\nsegment-0.ts\n#EXTINF:1.000,\nsegment-1.ts\n", string(playlist))
+
+	// the recording ends with the current segment, its files are kept
+	stream.Close()
+	playlist, err = os.ReadFile(filepath.Join(dir, hlsPlaylist))
+	require.NoError(t, err)
+	assert.Equal(t, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n#EXT-X-PLAYLIST-TYPE:EVENT\n#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXTINF:1.000,\nsegment-0.ts\n#EXTINF:1.000,\nsegment-1.ts\n#EXTINF:0.750,\nsegment-2.ts\n#EXT-X-ENDLIST\n", string(playlist))
+	for _, name := range []string{"segment-0.ts", "segment-1.ts", "segment-2.ts"} {
+		_, err = os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestServeHLS(t *testing.T) {
+	d := newTestDriver(t)
+	d.addStream("camera", map[string]models.ProtocolProperties{Protocol: {}})
+	d.addStream("hls-camera", map[string]models.ProtocolProperties{Protocol: {HLS: "true", HLSSegmentDuration: "1s"}})
+	streams := d.streams["hls-camera"]
+	require.NotNil(t, streams.hls)
+	streams.hls.open = openFakeSegment
+	server := httptest.NewServer(d.live)
+	t.Cleanup(server.Close)
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+	resp, _ := get("/hls-camera/index.m3u8")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	// once requested, only the annotated frames are segmented
+	frame := gocv.NewMatWithSize(8, 8, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+	require.NoError(t, d.WriteStream("hls-camera", StreamRaw, frame))
+	streams.hls.mu.Lock()
+	assert.False(t, streams.hls.queued)
+	streams.hls.mu.Unlock()
+	require.NoError(t, d.WriteStream("hls-camera", StreamAnnotated, frame))
+	waitEncoder(t, streams.hls)
+	resp, _ = get("/hls-camera/index.m3u8")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.NoError(t, streams.hls.Write(frame, time.Now().Add(time.Second)))
+	waitEncoder(t, streams.hls)
+
+	require.Eventually(t, func() bool {
+		playlist, err := streams.hls.Playlist()
+		return err == nil && playlist != nil
+	}, time.Second, time.Millisecond)
+
+	resp, playlist := get("/hls-camera/index.m3u8")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/vnd.apple.mpegurl", resp.Header.Get("Content-Type"))
+	assert.Contains(t, playlist, "\nsegment-0.ts\n")
+	resp, segment := get("/hls-camera/segment-0.ts")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "video/mp2t", resp.Header.Get("Content-Type"))
+	assert.NotEmpty(t, segment)
+
+	// the segment being written, and the HLS of a device without it, are not found
+	for _, path := range []string{"/hls-camera/segment-1.ts", "/hls-camera/segment-00.ts", "/camera/index.m3u8"} {
+		resp, _ := get(path)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, path)
+	}
+}
@@ -0,0 +1,208 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+)
+
+// query parameter carrying the token of clients that can not set headers, such as an <img> tag
+const accessTokenParam = "access_token"
+
+// jwtValidator validates EdgeX JWTs, implemented by the secret provider of the SDK
+type jwtValidator interface {
+	IsJWTValid(jwt string) (bool, error)
+}
+
+// liveAuth authenticates the clients of the live streaming server, by token, basic auth or EdgeX JWT
+type liveAuth struct {
+	mode string
+	// tokens or passwords keyed by client name
+	mu          sync.RWMutex
+	credentials map[string]string
+	jwt         jwtValidator
+}
+
+func (a *liveAuth) setCredentials(credentials map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.credentials = credentials
+}
+
+// authenticate the client of a request, returns the client name
+func (a *liveAuth) authenticate(r *http.Request) (string, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch a.mode {
+	case LiveAuthBasic:
+		user, password, ok := r.BasicAuth()
+		expected, found := a.credentials[user]
+		return user, ok && found && secretEqual(password, expected), nil
+	case LiveAuthToken:
+		token := bearerToken(r)
+		for name, expected := range a.credentials {
+			if token != "" && secretEqual(token, expected) {
+				return name, true, nil
+			}
+		}
+		return "", false, nil
+	case LiveAuthJWT:
+		token := bearerToken(r)
+		if token == "" {
+			return "", false, nil
+		}
+		valid, err := a.jwt.IsJWTValid(token)
+		if err != nil || !valid {
+			return "", false, err
+		}
+		return jwtName(token), true, nil
+	}
+	return "", true, nil
+}
+
+// challenge an unauthenticated client
+func (a *liveAuth) challenge(w http.ResponseWriter) {
+	if a.mode == LiveAuthBasic {
+		w.Header().Set("WWW-Authenticate", `Basic realm="live", charset="UTF-8"`)
+	} else {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func secretEqual(value string, expected string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(value), []byte(expected)) == 1
+}
+
+// the bearer token of the Authorization header, or the access token query parameter
+func bearerToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get(accessTokenParam)
+}
+
+// the user name of a validated EdgeX JWT, from its 'name' claim or its subject
+func jwtName(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Name    string `json:"name"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	if claims.Name != "" {
+		return claims.Name
+	}
+	return claims.Subject
+}
+
+// parse 'LiveAccess' of a device, the clients allowed to access its live stream and status, all when empty
+func parseLiveAccess(protocol models.ProtocolProperties) []string {
+	value, _ := cast.ToStringE(protocol[LiveAccess])
+	var clients []string
+	for _, client := range strings.Split(value, ",") {
+		if client = strings.TrimSpace(client); client != "" {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// the authentication of the live streaming server from the custom configuration, nil when disabled
+func (d *Driver) newLiveAuth(c *config.OVMSConfig) (*liveAuth, error) {
+	mode := strings.ToLower(c.LiveAuth)
+	switch mode {
+	case "", LiveAuthNone:
+		return nil, nil
+	case LiveAuthJWT:
+		if d.sdk == nil {
+			return nil, fmt.Errorf("secret store is not available to validate JWT")
+		}
+		validator, ok := d.sdk.SecretProvider().(jwtValidator)
+		if !ok {
+			return nil, fmt.Errorf("secret provider does not validate JWT")
+		}
+		return &liveAuth{mode: mode, jwt: validator}, nil
+	}
+
+	credentials, err := d.getSecret(c.LiveAuthSecretName)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("no client credentials found in secret '%s'", c.LiveAuthSecretName)
+	}
+	auth := &liveAuth{mode: mode, credentials: credentials}
+	// the clients follow the updates of the secret
+	err = d.sdk.SecretProvider().RegisterSecretUpdatedCallback(c.LiveAuthSecretName, func(secretName string) {
+		credentials, err := d.getSecret(secretName)
+		if err != nil {
+			d.lc.Errorf("Failed to reload live stream clients: %v", err)
+			return
+		}
+		auth.setCredentials(credentials)
+		d.lc.Infof("Live stream clients reloaded from secret '%s'", secretName)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch secret '%s': %v", c.LiveAuthSecretName, err)
+	}
+	return auth, nil
+}
+
+// the server TLS config of the live streaming server from the custom configuration, nil when HTTPS is disabled
+func (d *Driver) newLiveTLSConfig(c *config.OVMSConfig) (*tls.Config, error) {
+	if !c.LiveTLS {
+		return nil, nil
+	}
+	secret, err := d.getSecret(c.LiveTLSSecretName)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, err := d.loadPEM(c.LiveCert, secret, SecretServerCert)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := d.loadPEM(c.LiveKey, secret, SecretServerKey)
+	if err != nil {
+		return nil, err
+	}
+	if certPEM == nil || keyPEM == nil {
+		return nil, fmt.Errorf("HTTPS of the live server is enabled but no certificate and key are configured, set 'LiveCert' and 'LiveKey' or '%s' and '%s' in secret '%s'",
+			SecretServerCert, SecretServerKey, c.LiveTLSSecretName)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid live server certificate or key: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
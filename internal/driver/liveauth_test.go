@@ -0,0 +1,188 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a stream answering 200 without streaming
+var okStream = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+type fakeJWTValidator map[string]bool
+
+func (v fakeJWTValidator) IsJWTValid(jwt string) (bool, error) {
+	return v[jwt], nil
+}
+
+// a JWT with a 'name' claim, its signature is checked by the validator
+func testJWT(name string) string {
+	claims, _ := json.Marshal(map[string]string{"name": name, "sub": "entity-" + name})
+	return "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".signature"
+}
+
+func serveLive(d *Driver, path string, prepare func(r *http.Request)) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if prepare != nil {
+		prepare(r)
+	}
+	recorder := httptest.NewRecorder()
+	d.live.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestParseLiveAccess(t *testing.T) {
+	assert.Nil(t, parseLiveAccess(models.ProtocolProperties{}))
+	assert.Equal(t, []string{"alice", "bob"}, parseLiveAccess(models.ProtocolProperties{LiveAccess: " alice, ,bob "}))
+}
+
+func TestLiveAuth(t *testing.T) {
+	d := newTestDriver(t)
+	d.live.AddStream("private-camera", okStream, []string{"alice"})
+	d.live.AddStream("public-camera", okStream, nil)
+	d.setCaptureState("private-camera", CaptureRunning)
+	d.setCaptureState("public-camera", CaptureRunning)
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	t.Run("token", func(t *testing.T) {
+		d.live.auth = &liveAuth{mode: LiveAuthToken, credentials: map[string]string{"alice": "alice-token", "bob": "bob-token"}}
+
+		recorder := serveLive(d, "/public-camera.mjpeg", nil)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+		assert.Equal(t, http.StatusUnauthorized, serveLive(d, "/metrics", bearer("wrong")).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/metrics", bearer("bob-token")).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/public-camera.mjpeg", bearer("bob-token")).Code)
+		assert.Equal(t, http.StatusForbidden, serveLive(d, "/private-camera.mjpeg", bearer("bob-token")).Code)
+		assert.Equal(t, http.StatusForbidden, serveLive(d, "/status/private-camera", bearer("bob-token")).Code)
+		// the token of an <img> tag is a query parameter
+		assert.Equal(t, http.StatusOK, serveLive(d, "/private-camera.mjpeg?access_token=alice-token", nil).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/status/private-camera", bearer("alice-token")).Code)
+
+		// the metrics of the devices a client may not access are left out
+		d.metrics.reconnects.WithLabelValues("private-camera").Inc()
+		assert.NotContains(t, serveLive(d, "/metrics", bearer("bob-token")).Body.String(), "private-camera")
+		assert.Contains(t, serveLive(d, "/metrics", bearer("alice-token")).Body.String(), `device_ovms_reconnects_total{device="private-camera"} 1`)
+
+		// the status of the devices a client may not access is left out
+		var statuses []DeviceStatus
+		require.NoError(t, json.Unmarshal(serveLive(d, "/status", bearer("bob-token")).Body.Bytes(), &statuses))
+		require.Len(t, statuses, 1)
+		assert.Equal(t, "public-camera", statuses[0].Device)
+
+		// reloaded credentials apply to the next requests
+		d.live.auth.setCredentials(map[string]string{"alice": "new-token"})
+		assert.Equal(t, http.StatusUnauthorized, serveLive(d, "/private-camera.mjpeg", bearer("alice-token")).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/private-camera.mjpeg", bearer("new-token")).Code)
+	})
+
+	t.Run("basic", func(t *testing.T) {
+		d.live.auth = &liveAuth{mode: LiveAuthBasic, credentials: map[string]string{"alice": "secret", "bob": "password"}}
+		basic := func(user, password string) func(r *http.Request) {
+			return func(r *http.Request) { r.SetBasicAuth(user, password) }
+		}
+
+		recorder := serveLive(d, "/private-camera.mjpeg", nil)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("WWW-Authenticate"), "Basic")
+		assert.Equal(t, http.StatusUnauthorized, serveLive(d, "/private-camera.mjpeg", basic("alice", "password")).Code)
+		assert.Equal(t, http.StatusUnauthorized, serveLive(d, "/private-camera.mjpeg", basic("carol", "")).Code)
+		assert.Equal(t, http.StatusForbidden, serveLive(d, "/private-camera.mjpeg", basic("bob", "password")).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/private-camera.mjpeg", basic("alice", "secret")).Code)
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		alice, bob := testJWT("alice"), testJWT("bob")
+		d.live.auth = &liveAuth{mode: LiveAuthJWT, jwt: fakeJWTValidator{alice: true, bob: true}}
+
+		assert.Equal(t, "alice", jwtName(alice))
+		assert.Equal(t, http.StatusUnauthorized, serveLive(d, "/public-camera.mjpeg", bearer(testJWT("carol"))).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/public-camera.mjpeg", bearer(bob)).Code)
+		assert.Equal(t, http.StatusForbidden, serveLive(d, "/private-camera.mjpeg", bearer(bob)).Code)
+		assert.Equal(t, http.StatusOK, serveLive(d, "/private-camera.mjpeg", bearer(alice)).Code)
+	})
+}
+
+// write a self-signed certificate of 127.0.0.1 and its key to a directory
+func writeServerCert(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "live"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "live.crt"), filepath.Join(dir, "live.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestLiveTLS(t *testing.T) {
+	d := newTestDriver(t)
+	certFile, keyFile, pool := writeServerCert(t, t.TempDir())
+
+	_, err := d.newLiveTLSConfig(&config.OVMSConfig{LiveTLS: true, LiveCert: certFile})
+	assert.ErrorContains(t, err, "no certificate and key are configured")
+	_, err = d.newLiveTLSConfig(&config.OVMSConfig{LiveTLS: true, LiveTLSSecretName: "live-tls"})
+	assert.ErrorContains(t, err, "secret store is not available")
+
+	settings, err := d.serviceLiveSettings(&config.OVMSConfig{LiveHost: "127.0.0.1", LivePort: 0, LiveTLS: true, LiveCert: certFile, LiveKey: keyFile})
+	require.NoError(t, err)
+	require.NotNil(t, settings.TLS)
+	settings.Addr = "127.0.0.1:0"
+	require.NoError(t, d.live.Start(settings))
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get("https://" + d.live.Addr().String() + "/status")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// plain HTTP is refused
+	resp, err = http.Get("http://" + d.live.Addr().String() + "/status")
+	if err == nil {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
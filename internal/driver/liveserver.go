@@ -0,0 +1,237 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+)
+
+// defaults of the live streaming server, see the 'Live' settings of the custom configuration
+const (
+	defaultLiveHost            = "0.0.0.0"
+	defaultLivePort            = 18080
+	defaultLiveShutdownTimeout = 5 * time.Second
+	liveReadHeaderTimeout      = 10 * time.Second
+)
+
+// liveSettings are the address of the live streaming server, the time given to its clients on stop,
+// and its optional HTTPS and client authentication
+type liveSettings struct {
+	Addr            string
+	ShutdownTimeout time.Duration
+	TLS             *tls.Config
+	Auth            *liveAuth
+}
+
+// live streaming settings from the custom configuration, zero values keep the defaults
+func (d *Driver) serviceLiveSettings(c *config.OVMSConfig) (liveSettings, error) {
+	settings := liveSettings{
+		Addr:            net.JoinHostPort(defaultLiveHost, strconv.Itoa(defaultLivePort)),
+		ShutdownTimeout: defaultLiveShutdownTimeout,
+	}
+	if c == nil {
+		return settings, nil
+	}
+
+	host, port := defaultLiveHost, defaultLivePort
+	if c.LiveHost != "" {
+		host = c.LiveHost
+	}
+	if c.LivePort != 0 {
+		port = c.LivePort
+	}
+	settings.Addr = net.JoinHostPort(host, strconv.Itoa(port))
+	if c.LiveShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(c.LiveShutdownTimeout)
+		if err != nil {
+			return settings, fmt.Errorf("invalid 'LiveShutdownTimeout' %s: %v", c.LiveShutdownTimeout, err)
+		}
+		settings.ShutdownTimeout = timeout
+	}
+	var err error
+	if settings.TLS, err = d.newLiveTLSConfig(c); err != nil {
+		return settings, err
+	}
+	settings.Auth, err = d.newLiveAuth(c)
+	return settings, err
+}
+
+// liveServer serves the live streams of the devices, their status and the metrics on a mux of its own.
+// The routes of the service are fixed, the streams are looked up by device name so a removed device is unrouted.
+type liveServer struct {
+	lc      logger.LoggingClient
+	mux     *http.ServeMux
+	mu      sync.RWMutex
+	streams map[string]http.Handler
+	// clients allowed to access a device, see 'LiveAccess'
+	access map[string][]string
+	auth   *liveAuth
+	server *http.Server
+	addr   net.Addr
+	// time given to the clients to disconnect on Shutdown
+	shutdownTimeout time.Duration
+}
+
+func newLiveServer(lc logger.LoggingClient) *liveServer {
+	s := &liveServer{
+		lc:      lc,
+		mux:     http.NewServeMux(),
+		streams: make(map[string]http.Handler),
+		access:  make(map[string][]string),
+	}
+	s.mux.HandleFunc("GET /{device}/{file}", s.serveStream)
+	// the events stream of a device is also served at /<device>.mjpeg
+	s.mux.HandleFunc("GET /{stream}", s.serveStream)
+	return s
+}
+
+// Handle registers a route of the service, more specific than the stream routes
+func (s *liveServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddStream routes /<device>/<file> to the live streams of a device, replacing the previous streams of the device.
+// With authentication, only the named clients may access the device, or any client when none is named.
+func (s *liveServer) AddStream(deviceName string, handler http.Handler, clients []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[deviceName] = handler
+	s.access[deviceName] = clients
+}
+
+// RemoveStream unroutes the live stream of a device, new requests get 404
+func (s *liveServer) RemoveStream(deviceName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, deviceName)
+	delete(s.access, deviceName)
+}
+
+// Authorized tells whether the client of a request may access a device
+func (s *liveServer) Authorized(r *http.Request, deviceName string) bool {
+	if s.auth == nil {
+		return true
+	}
+	s.mu.RLock()
+	clients := s.access[deviceName]
+	s.mu.RUnlock()
+	if len(clients) == 0 {
+		return true
+	}
+	client, _ := r.Context().Value(liveClientKey{}).(string)
+	return slices.Contains(clients, client)
+}
+
+func (s *liveServer) serveStream(w http.ResponseWriter, r *http.Request) {
+	deviceName, ok := r.PathValue("device"), true
+	if deviceName == "" {
+		deviceName, ok = strings.CutSuffix(r.PathValue("stream"), ".mjpeg")
+		r.SetPathValue("file", StreamEvents+".mjpeg")
+	}
+	s.mu.RLock()
+	handler, found := s.streams[deviceName]
+	s.mu.RUnlock()
+	if !ok || !found {
+		http.NotFound(w, r)
+		return
+	}
+	if !s.Authorized(r, deviceName) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// liveClientKey is the context key of the name of an authenticated client
+type liveClientKey struct{}
+
+// ServeHTTP authenticates the client before routing the request
+func (s *liveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.auth != nil {
+		client, ok, err := s.auth.authenticate(r)
+		if err != nil {
+			s.lc.Errorf("Error authenticating live stream client %s: %v", r.RemoteAddr, err)
+		}
+		if !ok {
+			s.auth.challenge(w)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), liveClientKey{}, client))
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Start listens on the address of the settings and serves in the background,
+// a listen error such as a port in use is returned
+func (s *liveServer) Start(settings liveSettings) error {
+	listener, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP server for live stream on %s: %v", settings.Addr, err)
+	}
+	scheme := "http"
+	if settings.TLS != nil {
+		listener = tls.NewListener(listener, settings.TLS)
+		scheme = "https"
+	}
+	s.auth = settings.Auth
+	s.server = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: liveReadHeaderTimeout,
+	}
+	s.addr = listener.Addr()
+	s.shutdownTimeout = settings.ShutdownTimeout
+	go func(server *http.Server) {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			s.lc.Errorf("HTTP server for live stream stopped: %v", err)
+		}
+	}(s.server)
+	s.lc.Infof("HTTP server started for live streaming on %s://%s", scheme, s.addr)
+	return nil
+}
+
+// Addr is the address listened on, nil before Start
+func (s *liveServer) Addr() net.Addr {
+	return s.addr
+}
+
+// Shutdown stops the server, the clients are given the shutdown timeout to disconnect
+// unless 'force' is set, and are disconnected afterwards
+func (s *liveServer) Shutdown(force bool) {
+	if s.server == nil {
+		return
+	}
+	server := s.server
+	s.server = nil
+	if !force {
+		ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err == nil {
+			return
+		}
+		s.lc.Warnf("HTTP server for live stream did not stop within %s: %v", s.shutdownTimeout, err)
+	}
+	if err := server.Close(); err != nil {
+		s.lc.Errorf("Error closing HTTP server for live stream: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceLiveSettings(t *testing.T) {
+	d := newTestDriver(t)
+	settings, err := d.serviceLiveSettings(&config.OVMSConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, liveSettings{Addr: "0.0.0.0:18080", ShutdownTimeout: 5 * time.Second}, settings)
+
+	settings, err = d.serviceLiveSettings(&config.OVMSConfig{LiveHost: "::1", LivePort: 8088, LiveShutdownTimeout: "1s"})
+	require.NoError(t, err)
+	assert.Equal(t, liveSettings{Addr: "[::1]:8088", ShutdownTimeout: time.Second}, settings)
+
+	_, err = d.serviceLiveSettings(&config.OVMSConfig{LiveShutdownTimeout: "soon"})
+	assert.ErrorContains(t, err, "invalid 'LiveShutdownTimeout' soon")
+}
+
+func TestLiveServer(t *testing.T) {
+	d := newTestDriver(t)
+	// a stream sending its header and waiting for the client to leave
+	streaming := make(chan struct{})
+	d.live.AddStream("camera", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary=frame")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(streaming)
+		<-r.Context().Done()
+	}), nil)
+	settings := liveSettings{Addr: "127.0.0.1:0", ShutdownTimeout: 100 * time.Millisecond}
+	require.NoError(t, d.live.Start(settings))
+	url := "http://" + d.live.Addr().String()
+
+	// a port in use fails the start
+	err := newLiveServer(d.lc).Start(liveSettings{Addr: d.live.Addr().String()})
+	assert.ErrorContains(t, err, "failed to start HTTP server for live stream")
+
+	for path, code := range map[string]int{
+		"/metrics":        http.StatusOK,
+		"/status":         http.StatusOK,
+		"/status/unknown": http.StatusNotFound,
+		"/unknown.mjpeg":  http.StatusNotFound,
+		"/camera":         http.StatusNotFound,
+	} {
+		resp, err := http.Get(url + path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, code, resp.StatusCode, path)
+	}
+
+	stream, err := http.Get(url + "/camera.mjpeg")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, http.StatusOK, stream.StatusCode)
+	<-streaming
+
+	// the stream of a removed device is unrouted
+	require.NoError(t, d.RemoveDevice("camera", testProtocols()))
+	resp, err := http.Get(url + "/camera.mjpeg")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the connected client is disconnected once the shutdown timeout is over
+	start := time.Now()
+	require.NoError(t, d.Stop(false))
+	assert.GreaterOrEqual(t, time.Since(start), settings.ShutdownTimeout)
+	_, err = io.ReadAll(stream.Body)
+	assert.Error(t, err)
+	_, err = http.Get(url + "/status")
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc/status"
+)
+
+// namespace of the metrics of the service
+const metricsNamespace = "device_ovms"
+
+// buckets of the latency histograms, from 5ms to 10s
+var latencyBuckets = prometheus.ExponentialBuckets(0.005, 2, 12)
+
+// buckets of the JPEG encoding time histogram, from 0.5ms to 256ms
+var encodeBuckets = prometheus.ExponentialBuckets(0.0005, 2, 10)
+
+// pipelineMetrics are the metrics of the inference pipeline of every device, in a registry of the driver
+type pipelineMetrics struct {
+	registry *prometheus.Registry
+
+	inferLatency     *prometheus.HistogramVec
+	endToEndLatency  *prometheus.HistogramVec
+	detections       *prometheus.CounterVec
+	reconnects       *prometheus.CounterVec
+	inferErrors      *prometheus.CounterVec
+	streamClients    *prometheus.GaugeVec
+	encodeDuration   *prometheus.HistogramVec
+	deviceCollectors []interface{ DeletePartialMatch(prometheus.Labels) int }
+}
+
+// captureCollector exposes the frame counters of the devices, see CaptureStats
+type captureCollector struct {
+	d        *Driver
+	read     *prometheus.Desc
+	dropped  *prometheus.Desc
+	inferred *prometheus.Desc
+}
+
+func newCaptureCollector(d *Driver) *captureCollector {
+	return &captureCollector{
+		d:        d,
+		read:     prometheus.NewDesc(metricsNamespace+"_frames_read_total", "Frames read from the source of a device.", []string{"device"}, nil),
+		dropped:  prometheus.NewDesc(metricsNamespace+"_frames_dropped_total", "Frames of a device replaced by a newer one before inference.", []string{"device"}, nil),
+		inferred: prometheus.NewDesc(metricsNamespace+"_frames_inferred_total", "Frames of a device inferred successfully.", []string{"device"}, nil),
+	}
+}
+
+func (c *captureCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.read
+	ch <- c.dropped
+	ch <- c.inferred
+}
+
+func (c *captureCollector) Collect(ch chan<- prometheus.Metric) {
+	c.d.stateMu.RLock()
+	defer c.d.stateMu.RUnlock()
+	for deviceName, stats := range c.d.stats {
+		ch <- prometheus.MustNewConstMetric(c.read, prometheus.CounterValue, float64(stats.Read.Load()), deviceName)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(stats.Dropped.Load()), deviceName)
+		ch <- prometheus.MustNewConstMetric(c.inferred, prometheus.CounterValue, float64(stats.Inferred.Load()), deviceName)
+	}
+}
+
+// batchCollector exposes the counters and fill ratio of the batchers, see BatchStats
+type batchCollector struct {
+	d       *Driver
+	batches *prometheus.Desc
+	frames  *prometheus.Desc
+	fill    *prometheus.Desc
+}
+
+func newBatchCollector(d *Driver) *batchCollector {
+	labels := []string{"batcher", "model", "version"}
+	return &batchCollector{
+		d:       d,
+		batches: prometheus.NewDesc(metricsNamespace+"_batches_total", "Batched inference requests sent by a batcher.", labels, nil),
+		frames:  prometheus.NewDesc(metricsNamespace+"_batch_frames_total", "Frames sent in the batches of a batcher.", labels, nil),
+		fill:    prometheus.NewDesc(metricsNamespace+"_batch_fill_ratio", "Average share of the batch size used by the batches of a batcher.", labels, nil),
+	}
+}
+
+func (c *batchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.batches
+	ch <- c.frames
+	ch <- c.fill
+}
+
+func (c *batchCollector) Collect(ch chan<- prometheus.Metric) {
+	for key, stats := range c.d.batchers.Stats() {
+		ch <- prometheus.MustNewConstMetric(c.batches, prometheus.CounterValue, float64(stats.Batches), key, stats.Model, stats.Version)
+		ch <- prometheus.MustNewConstMetric(c.frames, prometheus.CounterValue, float64(stats.Frames), key, stats.Model, stats.Version)
+		ch <- prometheus.MustNewConstMetric(c.fill, prometheus.GaugeValue, stats.FillRate(), key, stats.Model, stats.Version)
+	}
+}
+
+func newPipelineMetrics(d *Driver) *pipelineMetrics {
+	m := &pipelineMetrics{
+		registry: prometheus.NewRegistry(),
+		inferLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "ovms_latency_seconds",
+			Help:      "Latency of the inference requests of a device to OVMS.",
+			Buckets:   latencyBuckets,
+		}, []string{"device"}),
+		endToEndLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "end_to_end_latency_seconds",
+			Help:      "Time from the read of a frame of a device to its processed inference result.",
+			Buckets:   latencyBuckets,
+		}, []string{"device"}),
+		detections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "detections_total",
+			Help:      "Detections of a device above its score, by label.",
+		}, []string{"device", "label"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconnects_total",
+			Help:      "Reconnect attempts of the capture of a device.",
+		}, []string{"device"}),
+		inferErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "inference_errors_total",
+			Help:      "Failed inference requests of a device, by gRPC status code.",
+		}, []string{"device", "code"}),
+		streamClients: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "stream_clients",
+			Help:      "Clients connected to a live stream of a device, by variant.",
+		}, []string{"device", "variant"}),
+		encodeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "encode_seconds",
+			Help:      "JPEG encoding time of the frames of a device.",
+			Buckets:   encodeBuckets,
+		}, []string{"device"}),
+	}
+	m.deviceCollectors = []interface{ DeletePartialMatch(prometheus.Labels) int }{
+		m.inferLatency, m.endToEndLatency, m.detections, m.reconnects, m.inferErrors, m.streamClients, m.encodeDuration,
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		newCaptureCollector(d),
+		newBatchCollector(d),
+		m.inferLatency, m.endToEndLatency, m.detections, m.reconnects, m.inferErrors, m.streamClients, m.encodeDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format, the series of a device only to the
+// clients 'authorized' to access it
+func (m *pipelineMetrics) Handler(authorized func(r *http.Request, deviceName string) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			families, err := m.registry.Gather()
+			for _, family := range families {
+				family.Metric = slices.DeleteFunc(family.Metric, func(metric *dto.Metric) bool {
+					for _, label := range metric.GetLabel() {
+						if label.GetName() == "device" {
+							return !authorized(r, label.GetValue())
+						}
+					}
+					return false
+				})
+			}
+			// a family without series left can not be encoded
+			families = slices.DeleteFunc(families, func(family *dto.MetricFamily) bool { return len(family.Metric) == 0 })
+			return families, err
+		})
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// count a failed inference by its gRPC status code, Unknown for errors of other transports
+func (m *pipelineMetrics) inferError(deviceName string, err error) {
+	m.inferErrors.WithLabelValues(deviceName, status.Code(err).String()).Inc()
+}
+
+// observe a duration in seconds
+func observeSince(observer prometheus.Observer, start time.Time) {
+	observer.Observe(time.Since(start).Seconds())
+}
+
+// RemoveDevice drops the metrics of a removed device
+func (m *pipelineMetrics) RemoveDevice(deviceName string) {
+	for _, collector := range m.deviceCollectors {
+		collector.DeletePartialMatch(prometheus.Labels{"device": deviceName})
+	}
+}
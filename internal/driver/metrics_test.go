@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scrape the metrics of a driver in the Prometheus text format
+func scrapeMetrics(t *testing.T, d *Driver) string {
+	return scrapeAuthorizedMetrics(t, d, func(*http.Request, string) bool { return true })
+}
+
+// scrape the metrics of a driver as a client 'authorized' to access some devices
+func scrapeAuthorizedMetrics(t *testing.T, d *Driver, authorized func(*http.Request, string) bool) string {
+	recorder := httptest.NewRecorder()
+	d.metrics.Handler(authorized).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	body, err := io.ReadAll(recorder.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPipelineMetrics(t *testing.T) {
+	deviceName := "metrics-camera"
+	d := newTestDriver(t)
+	server := startFakeServer(t, d, deviceName)
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return ovmstest.NewReplaySource(uri, false)
+	}
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 3)
+
+	err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+	assert.Error(t, err)
+
+	metrics := scrapeMetrics(t, d)
+	assert.Contains(t, metrics, `device_ovms_frames_read_total{device="metrics-camera"} 3`)
+	assert.Contains(t, metrics, `device_ovms_frames_inferred_total{device="metrics-camera"} 3`)
+	assert.Contains(t, metrics, `device_ovms_frames_dropped_total{device="metrics-camera"} 0`)
+	assert.Contains(t, metrics, `device_ovms_detections_total{device="metrics-camera",label="car"} 3`)
+	assert.Contains(t, metrics, `device_ovms_ovms_latency_seconds_count{device="metrics-camera"} 3`)
+	assert.Contains(t, metrics, `device_ovms_end_to_end_latency_seconds_count{device="metrics-camera"} 3`)
+	assert.Contains(t, metrics, "go_goroutines")
+
+	// failed inferences are counted by code
+	server.SetInferError(status.Error(codes.ResourceExhausted, "busy"))
+	err = d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+	assert.Error(t, err)
+	metrics = scrapeMetrics(t, d)
+	assert.Contains(t, metrics, `device_ovms_inference_errors_total{code="ResourceExhausted",device="metrics-camera"} 3`)
+	assert.Contains(t, metrics, `device_ovms_frames_read_total{device="metrics-camera"} 6`)
+
+	// the metrics of a removed device are dropped
+	d.metrics.RemoveDevice(deviceName)
+	d.stateMu.Lock()
+	delete(d.stats, deviceName)
+	d.stateMu.Unlock()
+	assert.NotContains(t, scrapeMetrics(t, d), "metrics-camera")
+}
+
+func TestMetricsAccess(t *testing.T) {
+	d := newTestDriver(t)
+	d.metrics.reconnects.WithLabelValues("lobby-camera").Inc()
+	d.metrics.reconnects.WithLabelValues("vault-camera").Inc()
+
+	// a client only gets the series of the devices it may access
+	metrics := scrapeAuthorizedMetrics(t, d, func(_ *http.Request, deviceName string) bool { return deviceName == "lobby-camera" })
+	assert.Contains(t, metrics, `device_ovms_reconnects_total{device="lobby-camera"} 1`)
+	assert.NotContains(t, metrics, "vault-camera")
+	assert.Contains(t, metrics, "go_goroutines")
+
+	metrics = scrapeAuthorizedMetrics(t, d, func(*http.Request, string) bool { return false })
+	assert.NotContains(t, metrics, "device_ovms_reconnects_total")
+	assert.Contains(t, metrics, "go_goroutines")
+}
+
+func TestStreamClientsMetric(t *testing.T) {
+	d := newTestDriver(t)
+	streams := d.NewStreamClient("viewed-camera", nil)
+	r := httptest.NewRequest(http.MethodGet, "/viewed-camera/raw.mjpeg", nil)
+	r.SetPathValue("file", "raw.mjpeg")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		streams.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	require.Eventually(t, func() bool {
+		return streams.streams[StreamRaw].Clients() == 1
+	}, time.Second, time.Millisecond)
+	assert.Contains(t, scrapeMetrics(t, d), `device_ovms_stream_clients{device="viewed-camera",variant="raw"} 1`)
+
+	streams.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream handler did not return")
+	}
+	assert.Contains(t, scrapeMetrics(t, d), `device_ovms_stream_clients{device="viewed-camera",variant="raw"} 0`)
+}
@@ -11,43 +11,49 @@ package driver
 
 import (
 	"context"
+	"encoding/binary"
 	"time"
-
-	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
 )
 
 // Make inference request
-func (d *Driver) ModelInferRequest(client grpc_client.GRPCInferenceServiceClient, img []byte, modelName string, modelVersion string, inputName string) (*grpc_client.ModelInferResponse, error) {
-
-	// Create context for our request with 10 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (d *Driver) ModelInferRequest(backend InferenceBackend, img []byte, modelName string, modelVersion string, inputName string, timeout time.Duration) (*InferResponse, error) {
 
-	contents := grpc_client.InferTensorContents{}
-	contents.BytesContents = append(contents.BytesContents, img)
+	// the encoded image is prefixed with its length
+	data := binary.LittleEndian.AppendUint32(nil, uint32(len(img)))
+	data = append(data, img...)
 
 	// prep inference request
-	inferInput := grpc_client.ModelInferRequest_InferInputTensor{
+	inferInput := InferTensor{
 		Name:     inputName,
 		Datatype: "BYTES",
 		Shape:    []int64{1},
-		Contents: &contents,
+		Data:     data,
 	}
 
 	// Create request input tensors
-	inferInputs := []*grpc_client.ModelInferRequest_InferInputTensor{
+	inferInputs := []*InferTensor{
 		&inferInput,
 	}
 
+	return d.ModelInferRequestInputs(backend, inferInputs, modelName, modelVersion, timeout)
+}
+
+// Make inference request of prepared input tensors
+func (d *Driver) ModelInferRequestInputs(backend InferenceBackend, inferInputs []*InferTensor, modelName string, modelVersion string, timeout time.Duration) (*InferResponse, error) {
+
+	// Create context for our request with the configured timeout, 10 seconds by default
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Create inference request for specific model/version
-	modelInferRequest := grpc_client.ModelInferRequest{
+	modelInferRequest := InferRequest{
 		ModelName:    modelName,
 		ModelVersion: modelVersion,
 		Inputs:       inferInputs,
 	}
 
 	// Submit inference request to server
-	modelInferResponse, err := client.ModelInfer(ctx, &modelInferRequest)
+	modelInferResponse, err := backend.ModelInfer(ctx, &modelInferRequest)
 	if err != nil {
 		d.lc.Debugf("Error processing InferRequest: %v", err)
 		return nil, err
@@ -55,18 +61,27 @@ func (d *Driver) ModelInferRequest(client grpc_client.GRPCInferenceServiceClient
 	return modelInferResponse, nil
 }
 
-func (d *Driver) ModelMetadataRequest(client grpc_client.GRPCInferenceServiceClient, modelName string, modelVersion string) (*grpc_client.ModelMetadataResponse, error) {
-	// Create context for our request with 10 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Check whether the model is ready for inference
+func (d *Driver) ModelReadyRequest(backend InferenceBackend, modelName string, modelVersion string, timeout time.Duration) (bool, error) {
+	// Create context for our request with the configured timeout, 10 seconds by default
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Create status request for a given model
-	modelMetadataRequest := grpc_client.ModelMetadataRequest{
-		Name:    modelName,
-		Version: modelVersion,
+	ready, err := backend.ModelReady(ctx, modelName, modelVersion)
+	if err != nil {
+		d.lc.Errorf("Error processing ModelReady: %v", err)
+		return false, err
 	}
+	return ready, nil
+}
+
+func (d *Driver) ModelMetadataRequest(backend InferenceBackend, modelName string, modelVersion string, timeout time.Duration) (*ModelMetadata, error) {
+	// Create context for our request with the configured timeout, 10 seconds by default
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Submit modelMetadata request to server
-	modelMetadataResponse, err := client.ModelMetadata(ctx, &modelMetadataRequest)
+	modelMetadataResponse, err := backend.ModelMetadata(ctx, modelName, modelVersion)
 	if err != nil {
 		d.lc.Errorf("Error processing Metadata: %v", err)
 		return nil, err
@@ -75,10 +90,10 @@ func (d *Driver) ModelMetadataRequest(client grpc_client.GRPCInferenceServiceCli
 }
 
 // Make inference request
-func (d *Driver) ModelInferRequestFP32(client grpc_client.GRPCInferenceServiceClient, img []float32, modelName string, modelVersion string, inputName string) (*grpc_client.ModelInferResponse, error) {
+func (d *Driver) ModelInferRequestFP32(backend InferenceBackend, img []float32, modelName string, modelVersion string, inputName string, timeout time.Duration) (*InferResponse, error) {
 
-	// Create context for our request with 10 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Create context for our request with the configured timeout, 10 seconds by default
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	// contents := grpc_client.InferTensorContents{}
@@ -90,29 +105,27 @@ func (d *Driver) ModelInferRequestFP32(client grpc_client.GRPCInferenceServiceCl
 	// }
 
 	// prep inference request
-	inferInput := grpc_client.ModelInferRequest_InferInputTensor{
+	inferInput := InferTensor{
 		Name:     inputName,
 		Datatype: "FP32",
 		Shape:    []int64{1, 112, 112, 3},
-		Contents: &grpc_client.InferTensorContents{
-			Fp32Contents: img,
-		},
+		Data:     encodeFP32(img),
 	}
 
 	// Create request input tensors
-	inferInputs := []*grpc_client.ModelInferRequest_InferInputTensor{
+	inferInputs := []*InferTensor{
 		&inferInput,
 	}
 
 	// Create inference request for specific model/version
-	modelInferRequest := grpc_client.ModelInferRequest{
+	modelInferRequest := InferRequest{
 		ModelName:    modelName,
 		ModelVersion: modelVersion,
 		Inputs:       inferInputs,
 	}
 
 	// Submit inference request to server
-	modelInferResponse, err := client.ModelInfer(ctx, &modelInferRequest)
+	modelInferResponse, err := backend.ModelInfer(ctx, &modelInferRequest)
 	if err != nil {
 		d.lc.Debugf("Error processing InferRequest: %v", err)
 		return nil, err
@@ -0,0 +1,335 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+// default size of a dynamic input dimension, see 'InputWidth' and 'InputHeight'
+const defaultInputSize = 640
+
+// auxiliary inputs of height, width and scale of the image, used by Faster R-CNN like models
+var imageInfoInputs = []string{"image_info", "im_info"}
+
+// ModelError is a mismatch between a model and the device configuration, reconnecting does not fix it
+type ModelError struct {
+	Model string
+	Err   error
+}
+
+func (e *ModelError) Error() string {
+	return fmt.Sprintf("model %s: %v", e.Model, e.Err)
+}
+
+func (e *ModelError) Unwrap() error {
+	return e.Err
+}
+
+// ModelInput is the image input of a model and how frames are fed to it
+type ModelInput struct {
+	Name     string
+	Datatype string
+	Layout   string
+	Width    int
+	Height   int
+	Channels int
+	// ImageInfo is the optional auxiliary input describing the image size
+	ImageInfo *TensorMetadata
+}
+
+// describe tensors by name, datatype and shape for error messages
+func describeTensors(tensors []*TensorMetadata) string {
+	var names []string
+	for _, tensor := range tensors {
+		names = append(names, fmt.Sprintf("%s %s %v", tensor.Name, tensor.Datatype, tensor.Shape))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
+
+func isImageInfoInput(name string) bool {
+	for _, imageInfo := range imageInfoInputs {
+		if name == imageInfo {
+			return true
+		}
+	}
+	return false
+}
+
+// an image input is a 4 dimensions tensor, or a BYTES tensor of encoded images
+func isImageInput(tensor *TensorMetadata) bool {
+	return len(tensor.Shape) == 4 || tensor.Datatype == "BYTES"
+}
+
+// parse a layout, empty when not set
+func parseLayout(layout string) (string, error) {
+	switch strings.ToUpper(layout) {
+	case "":
+		return "", nil
+	case LayoutNHWC:
+		return LayoutNHWC, nil
+	case LayoutNCHW:
+		return LayoutNCHW, nil
+	}
+	return "", fmt.Errorf("invalid '%s' %s, must be %s or %s", Layout, layout, LayoutNHWC, LayoutNCHW)
+}
+
+// parse a positive input size override, 0 when not set
+func parseInputSize(protocol models.ProtocolProperties, name string) (int, error) {
+	str, _ := cast.ToStringE(protocol[name])
+	if str == "" {
+		return 0, nil
+	}
+	size, err := cast.ToIntE(str)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("'%s' must be a positive number of pixels", name)
+	}
+	return size, nil
+}
+
+// detect the layout of a 4 dimensions shape by the position of its 1 or 3 channels,
+// NHWC when undecidable as OVMS takes encoded images in NHWC
+func detectLayout(shape []int64) string {
+	channels := func(dim int64) bool { return dim == 1 || dim == 3 }
+	if channels(shape[1]) && !channels(shape[3]) {
+		return LayoutNCHW
+	}
+	return LayoutNHWC
+}
+
+// NewModelInput selects the image input of a model from its metadata, by the 'InputName' property
+// or as the only image input, and validates the other inputs are supported
+func NewModelInput(metadata *ModelMetadata, protocol models.ProtocolProperties) (*ModelInput, error) {
+	inputName, _ := cast.ToStringE(protocol[InputName])
+	layout, err := parseLayout(cast.ToString(protocol[Layout]))
+	if err != nil {
+		return nil, err
+	}
+	width, err := parseInputSize(protocol, InputWidth)
+	if err != nil {
+		return nil, err
+	}
+	height, err := parseInputSize(protocol, InputHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := metadata.Inputs
+	var image *TensorMetadata
+	if inputName != "" {
+		for _, input := range inputs {
+			if input.Name == inputName {
+				image = input
+			}
+		}
+		if image == nil {
+			return nil, fmt.Errorf("input '%s' not found, model inputs: %s", inputName, describeTensors(inputs))
+		}
+		if !isImageInput(image) {
+			return nil, fmt.Errorf("input '%s' of shape %v is not an image input", inputName, image.Shape)
+		}
+	} else {
+		var images []*TensorMetadata
+		for _, input := range inputs {
+			if isImageInput(input) && !isImageInfoInput(input.Name) {
+				images = append(images, input)
+			}
+		}
+		switch len(images) {
+		case 0:
+			return nil, fmt.Errorf("no image input found, model inputs: %s", describeTensors(inputs))
+		case 1:
+			image = images[0]
+		default:
+			return nil, fmt.Errorf("%d image inputs found, set '%s' to one of: %s", len(images), InputName, describeTensors(images))
+		}
+	}
+
+	modelInput := &ModelInput{
+		Name:     image.Name,
+		Datatype: image.Datatype,
+		Layout:   LayoutNHWC,
+		Width:    defaultInputSize,
+		Height:   defaultInputSize,
+		Channels: 3,
+	}
+
+	// the other inputs must be fed by the driver
+	for _, input := range inputs {
+		if input == image {
+			continue
+		}
+		if !isImageInfoInput(input.Name) {
+			return nil, fmt.Errorf("input '%s' is not supported, only an image input and an optional %s input are", input.Name, strings.Join(imageInfoInputs, " or "))
+		}
+		shape := input.Shape
+		if input.Datatype != "FP32" || len(shape) != 2 || (shape[1] != 2 && shape[1] != 3 && shape[1] != -1) {
+			return nil, fmt.Errorf("input '%s' of type %s and shape %v is not an image info input of FP32 [1, 3]", input.Name, input.Datatype, shape)
+		}
+		modelInput.ImageInfo = input
+	}
+
+	// sizes of encoded images inputs and dynamic dimensions may be overridden
+	dynamicWidth, dynamicHeight := true, true
+	shape := image.Shape
+	if len(shape) == 4 {
+		if layout == "" {
+			layout = detectLayout(shape)
+		}
+		modelInput.Layout = layout
+
+		var dimHeight, dimWidth, dimChannels int64
+		if layout == LayoutNCHW {
+			dimChannels, dimHeight, dimWidth = shape[1], shape[2], shape[3]
+		} else {
+			dimHeight, dimWidth, dimChannels = shape[1], shape[2], shape[3]
+		}
+		if dimHeight > 0 {
+			modelInput.Height = int(dimHeight)
+			dynamicHeight = false
+		}
+		if dimWidth > 0 {
+			modelInput.Width = int(dimWidth)
+			dynamicWidth = false
+		}
+		switch dimChannels {
+		case -1, 3:
+		case 1:
+			modelInput.Channels = 1
+		default:
+			return nil, fmt.Errorf("input '%s' of shape %v has %d channels in %s layout, expected 1 or 3", image.Name, shape, dimChannels, layout)
+		}
+	} else if layout == LayoutNCHW {
+		return nil, fmt.Errorf("input '%s' of encoded images cannot use the %s layout", image.Name, LayoutNCHW)
+	}
+
+	// encoded images are sent for NHWC, OVMS decodes them, raw tensors for NCHW
+	if modelInput.Layout == LayoutNCHW && modelInput.Datatype != "FP32" && modelInput.Datatype != "UINT8" {
+		return nil, fmt.Errorf("input '%s' of type %s is not supported in %s layout, expected FP32 or UINT8", image.Name, modelInput.Datatype, LayoutNCHW)
+	}
+
+	if width > 0 && dynamicWidth {
+		modelInput.Width = width
+	}
+	if height > 0 && dynamicHeight {
+		modelInput.Height = height
+	}
+
+	return modelInput, nil
+}
+
+// ValidateDetectionOutputs checks the model has an output of [image_id, label, conf, x_min, y_min, x_max, y_max]
+// rows as read by the object detection decoder
+func ValidateDetectionOutputs(metadata *ModelMetadata) error {
+	outputs := metadata.Outputs
+	for _, output := range outputs {
+		shape := output.Shape
+		if len(shape) < 2 || (shape[len(shape)-1] != 7 && shape[len(shape)-1] != -1) {
+			continue
+		}
+		if _, err := datatypeSize(output.Datatype); err != nil {
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no object detection output of shape [..., N, 7] found, model outputs: %s", describeTensors(outputs))
+}
+
+// Tensors returns the inference inputs of a frame resized to the input size
+func (in *ModelInput) Tensors(img gocv.Mat) ([]*InferTensor, error) {
+	var tensors []*InferTensor
+
+	if in.Layout == LayoutNCHW {
+		tensor, err := in.planarTensor(img)
+		if err != nil {
+			return nil, err
+		}
+		tensors = append(tensors, tensor)
+	} else {
+		nativeBytes, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+		if err != nil {
+			return nil, err
+		}
+		// the bytes are copied as native memory is freed on close
+		encoded := binary.LittleEndian.AppendUint32(nil, uint32(nativeBytes.Len()))
+		encoded = append(encoded, nativeBytes.GetBytes()...)
+		nativeBytes.Close()
+		tensors = append(tensors, &InferTensor{
+			Name:     in.Name,
+			Datatype: "BYTES",
+			Shape:    []int64{1},
+			Data:     encoded,
+		})
+	}
+
+	if in.ImageInfo != nil {
+		info := []float32{float32(in.Height), float32(in.Width), 1}
+		if shape := in.ImageInfo.Shape; shape[1] == 2 {
+			info = info[:2]
+		}
+		tensors = append(tensors, &InferTensor{
+			Name:     in.ImageInfo.Name,
+			Datatype: "FP32",
+			Shape:    []int64{1, int64(len(info))},
+			Data:     encodeFP32(info),
+		})
+	}
+	return tensors, nil
+}
+
+// planar tensor of a BGR frame for NCHW inputs
+func (in *ModelInput) planarTensor(img gocv.Mat) (*InferTensor, error) {
+	if in.Channels == 1 && img.Channels() != 1 {
+		gray := gocv.NewMat()
+		defer gray.Close()
+		if err := gocv.CvtColor(img, &gray, gocv.ColorBGRToGray); err != nil {
+			return nil, err
+		}
+		img = gray
+	}
+
+	pixels := img.ToBytes()
+	channels := in.Channels
+	size := in.Width * in.Height
+	if len(pixels) != size*channels {
+		return nil, fmt.Errorf("frame of %d bytes does not match input '%s' of %dx%dx%d", len(pixels), in.Name, in.Width, in.Height, channels)
+	}
+
+	planar := make([]byte, size*channels)
+	for i := 0; i < size; i++ {
+		for c := 0; c < channels; c++ {
+			planar[c*size+i] = pixels[i*channels+c]
+		}
+	}
+	data := planar
+	if in.Datatype == "FP32" {
+		data = make([]byte, 0, len(planar)*4)
+		for _, value := range planar {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(value)))
+		}
+	}
+
+	return &InferTensor{
+		Name:     in.Name,
+		Datatype: in.Datatype,
+		Shape:    []int64{1, int64(channels), int64(in.Height), int64(in.Width)},
+		Data:     data,
+	}, nil
+}
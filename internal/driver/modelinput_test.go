@@ -0,0 +1,194 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+func tensorMetadata(name string, datatype string, shape ...int64) *TensorMetadata {
+	return &TensorMetadata{Name: name, Datatype: datatype, Shape: shape}
+}
+
+// tensor metadata of the fake server
+func grpcTensorMetadata(name string, datatype string, shape ...int64) *grpc_client.ModelMetadataResponse_TensorMetadata {
+	return &grpc_client.ModelMetadataResponse_TensorMetadata{Name: name, Datatype: datatype, Shape: shape}
+}
+
+func detectionMetadata(inputs ...*TensorMetadata) *ModelMetadata {
+	return &ModelMetadata{
+		Name:    testModel,
+		Inputs:  inputs,
+		Outputs: []*TensorMetadata{tensorMetadata("detection_out", "FP32", 1, 1, 100, 7)},
+	}
+}
+
+func TestNewModelInput(t *testing.T) {
+	imageInfo := tensorMetadata("image_info", "FP32", 1, 3)
+
+	tests := []struct {
+		name     string
+		metadata *ModelMetadata
+		protocol models.ProtocolProperties
+		expected *ModelInput
+		err      string
+	}{
+		{"NHWC", detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 400, 3)), nil,
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNHWC, Width: 400, Height: 300, Channels: 3}, ""},
+		{"NCHW detected", detectionMetadata(tensorMetadata("image", "FP32", 1, 3, 300, 400)), nil,
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 400, Height: 300, Channels: 3}, ""},
+		{"NCHW gray", detectionMetadata(tensorMetadata("image", "UINT8", 1, 1, 64, 64)), nil,
+			&ModelInput{Name: "image", Datatype: "UINT8", Layout: LayoutNCHW, Width: 64, Height: 64, Channels: 1}, ""},
+		{"layout override", detectionMetadata(tensorMetadata("image", "FP32", 1, 3, 3, 3)), models.ProtocolProperties{Layout: "nchw"},
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 3, Height: 3, Channels: 3}, ""},
+		{"dynamic", detectionMetadata(tensorMetadata("image", "FP32", -1, -1, -1, 3)), nil,
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNHWC, Width: defaultInputSize, Height: defaultInputSize, Channels: 3}, ""},
+		{"dynamic override", detectionMetadata(tensorMetadata("image", "FP32", -1, 300, -1, 3)), models.ProtocolProperties{InputWidth: "512", InputHeight: "512"},
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNHWC, Width: 512, Height: 300, Channels: 3}, ""},
+		{"encoded images", detectionMetadata(tensorMetadata("image", "BYTES", -1)), models.ProtocolProperties{InputWidth: "320", InputHeight: "240"},
+			&ModelInput{Name: "image", Datatype: "BYTES", Layout: LayoutNHWC, Width: 320, Height: 240, Channels: 3}, ""},
+		{"other image input", detectionMetadata(tensorMetadata("left", "FP32", 1, 300, 300, 3), tensorMetadata("right", "FP32", 1, 200, 200, 3)), models.ProtocolProperties{InputName: "right"},
+			nil, "input 'left' is not supported"},
+		{"image info", detectionMetadata(tensorMetadata("image", "FP32", 1, 3, 600, 800), imageInfo), nil,
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 800, Height: 600, Channels: 3, ImageInfo: imageInfo}, ""},
+		{"image info by name", detectionMetadata(imageInfo, tensorMetadata("image", "FP32", 1, 3, 600, 800)), models.ProtocolProperties{InputName: "image"},
+			&ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 800, Height: 600, Channels: 3, ImageInfo: imageInfo}, ""},
+		{"unknown input name", detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 300, 3)), models.ProtocolProperties{InputName: "data"},
+			nil, "input 'data' not found, model inputs: image FP32 [1 300 300 3]"},
+		{"several images", detectionMetadata(tensorMetadata("left", "FP32", 1, 300, 300, 3), tensorMetadata("right", "FP32", 1, 300, 300, 3)), nil,
+			nil, "2 image inputs found, set 'InputName'"},
+		{"no image", detectionMetadata(tensorMetadata("features", "FP32", 1, 256)), nil,
+			nil, "no image input found"},
+		{"not an image", detectionMetadata(tensorMetadata("features", "FP32", 1, 256)), models.ProtocolProperties{InputName: "features"},
+			nil, "is not an image input"},
+		{"bad image info", detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 300, 3), tensorMetadata("image_info", "INT32", 1, 3)), nil,
+			nil, "is not an image info input"},
+		{"bad channels", detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 300, 4)), models.ProtocolProperties{Layout: "NHWC"},
+			nil, "has 4 channels"},
+		{"bad NCHW datatype", detectionMetadata(tensorMetadata("image", "FP16", 1, 3, 300, 300)), nil,
+			nil, "type FP16 is not supported in NCHW layout"},
+		{"bad layout", detectionMetadata(tensorMetadata("image", "FP32", 1, 300, 300, 3)), models.ProtocolProperties{Layout: "CHW"},
+			nil, "invalid 'Layout' CHW"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input, err := NewModelInput(test.metadata, test.protocol)
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, input)
+		})
+	}
+}
+
+func TestValidateDetectionOutputs(t *testing.T) {
+	metadata := detectionMetadata()
+	assert.NoError(t, ValidateDetectionOutputs(metadata))
+
+	metadata.Outputs = []*TensorMetadata{
+		tensorMetadata("boxes", "FP32", 1, 100, 5),
+		tensorMetadata("labels", "INT64", 1, 100),
+	}
+	assert.ErrorContains(t, ValidateDetectionOutputs(metadata), "model outputs: boxes FP32 [1 100 5], labels INT64 [1 100]")
+}
+
+func TestModelInputTensors(t *testing.T) {
+	img := gocv.NewMatWithSize(2, 3, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	input := &ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 3, Height: 2, Channels: 3, ImageInfo: tensorMetadata("im_info", "FP32", 1, 3)}
+	tensors, err := input.Tensors(img)
+	require.NoError(t, err)
+	require.Len(t, tensors, 2)
+	assert.Equal(t, []int64{1, 3, 2, 3}, tensors[0].Shape)
+	assert.Len(t, tensors[0].Data, 18*4)
+	assert.Equal(t, "im_info", tensors[1].Name)
+	assert.Equal(t, encodeFP32([]float32{2, 3, 1}), tensors[1].Data)
+
+	input.Datatype = "UINT8"
+	tensors, err = input.Tensors(img)
+	require.NoError(t, err)
+	assert.Len(t, tensors[0].Data, 18)
+
+	input = &ModelInput{Name: "image", Datatype: "UINT8", Layout: LayoutNHWC, Width: 3, Height: 2, Channels: 3}
+	tensors, err = input.Tensors(img)
+	require.NoError(t, err)
+	require.Len(t, tensors, 1)
+	assert.Equal(t, "BYTES", tensors[0].Datatype)
+	// a single encoded image prefixed with its length
+	contents, err := decodeTensorData("BYTES", tensors[0].Data)
+	require.NoError(t, err)
+	assert.Len(t, contents.BytesContents, 1)
+
+	// the frame must be resized to the input size first
+	input = &ModelInput{Name: "image", Datatype: "FP32", Layout: LayoutNCHW, Width: 4, Height: 4, Channels: 3}
+	_, err = input.Tensors(img)
+	assert.Error(t, err)
+}
+
+func TestProcessMjpegStreamModelInput(t *testing.T) {
+	deviceName := "input-camera"
+
+	t.Run("NCHW with image info", func(t *testing.T) {
+		d := newTestDriver(t)
+		server := startFakeServer(t, d, deviceName)
+		server.Inputs = []*grpc_client.ModelMetadataResponse_TensorMetadata{
+			grpcTensorMetadata("data", "FP32", 1, 3, 60, 80),
+			grpcTensorMetadata("image_info", "FP32", 1, 3),
+		}
+		d.frameSourceFactory = func(uri string) (FrameSource, error) {
+			return ovmstest.NewReplaySource(uri, false)
+		}
+		d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+		assert.Error(t, err)
+		assert.EqualValues(t, 3, server.InferCount())
+		request := server.LastRequest()
+		require.NotNil(t, request)
+		require.Len(t, request.Inputs, 2)
+		assert.Equal(t, []int64{1, 3, 60, 80}, request.Inputs[0].Shape)
+		assert.Len(t, request.Inputs[0].Contents.Fp32Contents, 3*60*80)
+		assert.Equal(t, []float32{60, 80, 1}, request.Inputs[1].Contents.Fp32Contents)
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		d := newTestDriver(t)
+		server := startFakeServer(t, d, deviceName)
+		server.Inputs = []*grpc_client.ModelMetadataResponse_TensorMetadata{
+			grpcTensorMetadata("data", "FP32", 1, 3, 300, 300),
+			grpcTensorMetadata("mask", "FP32", 1, 300, 300),
+		}
+
+		err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+		var modelErr *ModelError
+		require.True(t, errors.As(err, &modelErr))
+		assert.ErrorContains(t, err, "input 'mask' is not supported")
+		assert.Zero(t, server.InferCount())
+	})
+
+	t.Run("mismatch is not retried", func(t *testing.T) {
+		d := newTestDriver(t)
+		d.policy.Reconnect = RetryPolicy{MaxAttempts: 0, Interval: time.Hour}
+		server := startFakeServer(t, d, deviceName)
+		server.Inputs = []*grpc_client.ModelMetadataResponse_TensorMetadata{grpcTensorMetadata("data", "FP32", 1, 3, 300, 300, 1)}
+
+		d.imageCapture(deviceName, d.backends[deviceName], testProtocols(), make(chan struct{}))
+		assert.Equal(t, CaptureFailed, d.captureState(deviceName))
+	})
+}
@@ -0,0 +1,278 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+const (
+	defaultOverlayLabel      = `{{.Label}}:{{printf "%.2f" .Score}}`
+	defaultOverlayTimeFormat = "2006-01-02 15:04:05"
+	defaultOverlayThickness  = 1
+
+	// fonts of the detection labels, and of the timestamp and fps
+	overlayLabelFont = gocv.FontHersheyDuplex
+	overlayInfoFont  = gocv.FontHersheyPlain
+	// margin of the texts in pixels at font scale 1
+	overlayMargin = 5
+	// line height of the timestamp and fps in pixels at font scale 1
+	overlayLineHeight = 20
+)
+
+// defaultOverlayColor is the color of the labels without their own color
+var defaultOverlayColor = color.RGBA{R: 255, G: 0, B: 255, A: 128}
+
+// OverlayConfig is how the detections, timestamp and fps are drawn on the frames of a device
+type OverlayConfig struct {
+	// Color of the labels not in Colors
+	Color  color.RGBA
+	Colors map[string]color.RGBA
+	// Label is the text of a detection, executed with overlayLabel
+	Label *template.Template
+	// FontSize is the text height in fractions of the frame height, 0 keeps font scale 1
+	FontSize  float64
+	Thickness int
+	// LabelBackground fills the background of the labels with their color
+	LabelBackground bool
+	TimeFormat      string
+	Location        *time.Location
+	// elements drawn
+	Boxes     bool
+	Labels    bool
+	Timestamp bool
+	FPS       bool
+}
+
+// overlayLabel is the data of the label template of a detection
+type overlayLabel struct {
+	// Label is the class name, Class its id
+	Label string
+	Class int
+	Score float32
+	// Index numbers the detections of a frame from 0
+	Index int
+	// TrackID identifies an object across frames, 0 as objects are not tracked yet
+	TrackID int
+}
+
+// parse a color of "r,g,b" values between 0 and 255
+func parseColor(str string) (color.RGBA, error) {
+	fields := strings.Split(str, ",")
+	if len(fields) != 3 {
+		return color.RGBA{}, fmt.Errorf("color %s must be r,g,b values between 0 and 255", str)
+	}
+	var values [3]uint8
+	for i, field := range fields {
+		value, err := cast.ToIntE(strings.TrimSpace(field))
+		if err != nil || value < 0 || value > 255 {
+			return color.RGBA{}, fmt.Errorf("color %s must be r,g,b values between 0 and 255", str)
+		}
+		values[i] = uint8(value)
+	}
+	return color.RGBA{R: values[0], G: values[1], B: values[2], A: defaultOverlayColor.A}, nil
+}
+
+// parse the overlay properties of a device
+func parseOverlayConfig(protocol models.ProtocolProperties) (OverlayConfig, error) {
+	config := OverlayConfig{
+		Color:      defaultOverlayColor,
+		Colors:     make(map[string]color.RGBA),
+		Thickness:  defaultOverlayThickness,
+		TimeFormat: defaultOverlayTimeFormat,
+		Location:   time.Local,
+		Boxes:      true,
+		Labels:     true,
+		Timestamp:  true,
+		FPS:        true,
+	}
+
+	var err error
+	if str, _ := cast.ToStringE(protocol[OverlayColor]); str != "" {
+		if config.Color, err = parseColor(str); err != nil {
+			return config, fmt.Errorf("invalid '%s': %v", OverlayColor, err)
+		}
+	}
+	if str, _ := cast.ToStringE(protocol[OverlayColors]); str != "" {
+		for _, entry := range strings.Split(str, ";") {
+			label, value, ok := strings.Cut(entry, "=")
+			label = strings.TrimSpace(label)
+			if !ok || label == "" {
+				return config, fmt.Errorf("'%s' must be label=r,g,b entries separated by ';'", OverlayColors)
+			}
+			if config.Colors[label], err = parseColor(value); err != nil {
+				return config, fmt.Errorf("invalid '%s' of label %s: %v", OverlayColors, label, err)
+			}
+		}
+	}
+
+	label, _ := cast.ToStringE(protocol[OverlayLabel])
+	if label == "" {
+		label = defaultOverlayLabel
+	}
+	if config.Label, err = template.New(OverlayLabel).Parse(label); err != nil {
+		return config, fmt.Errorf("invalid '%s': %v", OverlayLabel, err)
+	}
+	// the fields are checked once, not on every frame
+	if err := config.Label.Execute(&strings.Builder{}, overlayLabel{Label: "person", Score: 1}); err != nil {
+		return config, fmt.Errorf("invalid '%s': %v", OverlayLabel, err)
+	}
+
+	validSize := func(v float64) bool { return v > 0 && v <= 1 }
+	if err := overrideFloat(&config.FontSize, OverlayFontSize, protocol[OverlayFontSize], validSize); err != nil {
+		return config, fmt.Errorf("%v, must be a fraction of the frame height between 0 and 1", err)
+	}
+	if str, _ := cast.ToStringE(protocol[OverlayThickness]); str != "" {
+		thickness, err := cast.ToIntE(str)
+		if err != nil || thickness < 1 {
+			return config, fmt.Errorf("'%s' must be a positive number of pixels", OverlayThickness)
+		}
+		config.Thickness = thickness
+	}
+
+	if str, _ := cast.ToStringE(protocol[OverlayTimeFormat]); str != "" {
+		config.TimeFormat = str
+	}
+	if str, _ := cast.ToStringE(protocol[OverlayTimezone]); str != "" {
+		if config.Location, err = time.LoadLocation(str); err != nil {
+			return config, fmt.Errorf("invalid '%s' %s: %v", OverlayTimezone, str, err)
+		}
+	}
+
+	toggles := map[string]*bool{
+		OverlayLabelBackground: &config.LabelBackground,
+		OverlayBoxes:           &config.Boxes,
+		OverlayLabels:          &config.Labels,
+		OverlayTimestamp:       &config.Timestamp,
+		OverlayFPS:             &config.FPS,
+	}
+	for name, target := range toggles {
+		if err := overrideBool(target, name, protocol[name]); err != nil {
+			return config, err
+		}
+	}
+	return config, nil
+}
+
+// overlayRenderer draws the overlay of a device, it is used by the capture loop only
+type overlayRenderer struct {
+	config OverlayConfig
+	text   strings.Builder
+}
+
+func newOverlayRenderer(config OverlayConfig) *overlayRenderer {
+	return &overlayRenderer{config: config}
+}
+
+// color of a label
+func (r *overlayRenderer) color(label string) color.RGBA {
+	if c, ok := r.config.Colors[label]; ok {
+		return c
+	}
+	return r.config.Color
+}
+
+// font scale of a frame 'rows' pixels high
+func (r *overlayRenderer) fontScale(rows int) float64 {
+	if r.config.FontSize == 0 {
+		return 1
+	}
+	height := gocv.GetTextSize("Ag", overlayLabelFont, 1, r.config.Thickness).Y
+	if height <= 0 {
+		return 1
+	}
+	return r.config.FontSize * float64(rows) / float64(height)
+}
+
+// label text of the index-th detection of a frame
+func (r *overlayRenderer) label(row ObjectDetectionResutl, index int) string {
+	r.text.Reset()
+	data := overlayLabel{Label: labelName(row.Label), Class: int(row.Label), Score: row.Confidence, Index: index}
+	if err := r.config.Label.Execute(&r.text, data); err != nil {
+		return fmt.Sprintf("%s:%.2f", data.Label, data.Score)
+	}
+	return r.text.String()
+}
+
+// text color on a filled label background, black on light colors
+func contrastColor(c color.RGBA) color.RGBA {
+	if 299*int(c.R)+587*int(c.G)+114*int(c.B) > 128*1000 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{R: 255, G: 255, B: 255, A: 255}
+}
+
+// clamp the box of a detection to a frame of 'width' x 'height' pixels
+func detectionRect(row ObjectDetectionResutl, width int, height int) image.Rectangle {
+	return image.Rect(
+		max(0, int(row.X_min*float32(width))),
+		max(0, int(row.Y_min*float32(height))),
+		min(width, int(row.X_max*float32(width))),
+		min(height, int(row.Y_max*float32(height))),
+	)
+}
+
+// DrawDetection draws the box and label of the index-th detection of a frame
+func (r *overlayRenderer) DrawDetection(img *gocv.Mat, row ObjectDetectionResutl, index int) {
+	rect := detectionRect(row, img.Cols(), img.Rows())
+	tipsColor := r.color(labelName(row.Label))
+	if r.config.Boxes {
+		_ = gocv.Rectangle(img, rect, tipsColor, r.config.Thickness)
+	}
+	if !r.config.Labels {
+		return
+	}
+
+	text := r.label(row, index)
+	scale := r.fontScale(img.Rows())
+	size, baseline := gocv.GetTextSizeWithBaseline(text, overlayLabelFont, scale, r.config.Thickness)
+	margin := int(overlayMargin * scale)
+	// above the box, or inside it at the top of the frame
+	origin := image.Pt(rect.Min.X, rect.Min.Y-margin)
+	if origin.Y-size.Y < 0 {
+		origin.Y = rect.Min.Y + size.Y + margin
+	}
+	textColor := tipsColor
+	if r.config.LabelBackground {
+		background := image.Rect(origin.X, origin.Y-size.Y-margin, origin.X+size.X+2*margin, origin.Y+baseline)
+		_ = gocv.Rectangle(img, background, tipsColor, -1)
+		origin.X += margin
+		textColor = contrastColor(tipsColor)
+	}
+	_ = gocv.PutText(img, text, origin, overlayLabelFont, scale, textColor, r.config.Thickness)
+}
+
+// Timestamp formats 'now' in the overlay timezone
+func (r *overlayRenderer) Timestamp(now time.Time) string {
+	return now.In(r.config.Location).Format(r.config.TimeFormat)
+}
+
+// DrawInfo draws the timestamp and inference fps at the top left of a frame
+func (r *overlayRenderer) DrawInfo(img *gocv.Mat, now time.Time, fps string) {
+	scale := r.fontScale(img.Rows())
+	lineHeight := int(overlayLineHeight * scale)
+	origin := image.Pt(10, 10+lineHeight)
+	if r.config.Timestamp {
+		_ = gocv.PutText(img, r.Timestamp(now), origin, overlayInfoFont, scale, r.config.Color, r.config.Thickness)
+		origin.Y += lineHeight
+	}
+	if r.config.FPS {
+		_ = gocv.PutText(img, "fps: "+fps, origin, overlayInfoFont, scale, r.config.Color, r.config.Thickness)
+	}
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverlayConfig(t *testing.T) {
+	config, err := parseOverlayConfig(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultOverlayColor, config.Color)
+	assert.Equal(t, time.Local, config.Location)
+	assert.True(t, config.Boxes && config.Labels && config.Timestamp && config.FPS)
+	assert.False(t, config.LabelBackground)
+
+	config, err = parseOverlayConfig(models.ProtocolProperties{
+		OverlayColor:           "0, 0, 255",
+		OverlayColors:          "person=0,255,0; car=255,0,0",
+		OverlayFontSize:        "0.05",
+		OverlayThickness:       "2",
+		OverlayLabelBackground: "true",
+		OverlayTimezone:        "UTC",
+		OverlayFPS:             "false",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, color.RGBA{B: 255, A: 128}, config.Color)
+	assert.Equal(t, map[string]color.RGBA{"person": {G: 255, A: 128}, "car": {R: 255, A: 128}}, config.Colors)
+	assert.Equal(t, 0.05, config.FontSize)
+	assert.Equal(t, 2, config.Thickness)
+	assert.True(t, config.LabelBackground)
+	assert.Equal(t, time.UTC, config.Location)
+	assert.False(t, config.FPS)
+	assert.True(t, config.Timestamp)
+
+	for _, protocol := range []models.ProtocolProperties{
+		{OverlayColor: "255,0"},
+		{OverlayColor: "256,0,0"},
+		{OverlayColors: "person"},
+		{OverlayColors: "person=green"},
+		{OverlayLabel: "{{.Label"},
+		{OverlayFontSize: "2"},
+		{OverlayThickness: "0"},
+		{OverlayTimezone: "Mars/Olympus"},
+		{OverlayBoxes: "maybe"},
+	} {
+		_, err := parseOverlayConfig(protocol)
+		assert.Error(t, err, protocol)
+	}
+}
+
+func TestOverlayRenderer(t *testing.T) {
+	config, err := parseOverlayConfig(models.ProtocolProperties{
+		OverlayColors:     "person=0,255,0",
+		OverlayLabel:      `#{{.Index}} {{.Label}} ({{.Class}}) {{printf "%.1f" .Score}} {{.TrackID}}`,
+		OverlayFontSize:   "0.1",
+		OverlayTimeFormat: "15:04 MST",
+		OverlayTimezone:   "UTC",
+	})
+	require.NoError(t, err)
+	renderer := newOverlayRenderer(config)
+
+	row := ObjectDetectionResutl{Label: 3, Confidence: 0.87, X_min: -0.1, Y_min: 0.25, X_max: 0.5, Y_max: 1.2}
+	assert.Equal(t, "#2 car (3) 0.9 0", renderer.label(row, 2))
+	assert.Equal(t, image.Rect(0, 25, 100, 100), detectionRect(row, 200, 100))
+	assert.Equal(t, color.RGBA{G: 255, A: 128}, renderer.color("person"))
+	assert.Equal(t, defaultOverlayColor, renderer.color("car"))
+	assert.Equal(t, "08:30 UTC", renderer.Timestamp(time.Date(2024, 5, 1, 10, 30, 0, 0, time.FixedZone("CEST", 2*3600))))
+
+	// the text keeps its height relative to the frame
+	assert.InDelta(t, 2*renderer.fontScale(360), renderer.fontScale(720), 1e-9)
+	assert.Equal(t, 1.0, newOverlayRenderer(OverlayConfig{Thickness: 1}).fontScale(720))
+
+	assert.Equal(t, color.RGBA{A: 255}, contrastColor(color.RGBA{G: 255}))
+	assert.Equal(t, color.RGBA{R: 255, G: 255, B: 255, A: 255}, contrastColor(color.RGBA{B: 255}))
+}
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+)
+
+// Default timeouts and retry policies, used when neither the service configuration nor the device sets them
+var defaultPolicy = DevicePolicy{
+	InferTimeout:    10 * time.Second,
+	MetadataTimeout: 10 * time.Second,
+	Dial: RetryPolicy{
+		MaxAttempts: 3,
+		Interval:    3 * time.Second,
+		MaxInterval: 3 * time.Second,
+		Multiplier:  1,
+	},
+	Reconnect: RetryPolicy{
+		MaxAttempts: 60,
+		Interval:    5 * time.Second,
+		MaxInterval: 60 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+	},
+}
+
+// RetryPolicy describes how many times and how fast an operation is retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, 0 retries forever
+	MaxAttempts int
+	Interval    time.Duration
+	MaxInterval time.Duration
+	Multiplier  float64
+	Jitter      float64
+}
+
+// Forever reports whether the operation is retried without limit
+func (p RetryPolicy) Forever() bool {
+	return p.MaxAttempts == 0
+}
+
+// Exhausted reports whether no attempt is left after 'attempt' attempts
+func (p RetryPolicy) Exhausted(attempt int) bool {
+	return !p.Forever() && attempt >= p.MaxAttempts
+}
+
+// Delay returns the wait before the attempt following 'attempt' failed attempts,
+// growing exponentially up to MaxInterval and randomized by Jitter
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.Interval)
+	if p.Multiplier > 1 && attempt > 1 {
+		delay *= math.Pow(p.Multiplier, float64(attempt-1))
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// Progress describes the attempts of the policy for logging
+func (p RetryPolicy) Progress(attempt int) string {
+	if p.Forever() {
+		return fmt.Sprintf("%d", attempt)
+	}
+	return fmt.Sprintf("%d/%d", attempt, p.MaxAttempts)
+}
+
+// DevicePolicy holds the timeouts and retry policies of a device
+type DevicePolicy struct {
+	InferTimeout    time.Duration
+	MetadataTimeout time.Duration
+	Dial            RetryPolicy
+	Reconnect       RetryPolicy
+}
+
+// override a duration if 'value' is set
+func overrideDuration(target *time.Duration, name string, value interface{}) error {
+	str, _ := cast.ToStringE(value)
+	if str == "" {
+		return nil
+	}
+	duration, err := time.ParseDuration(str)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("'%s' must be a positive duration such as 10s", name)
+	}
+	*target = duration
+	return nil
+}
+
+// override a non negative number of attempts if 'value' is set
+func overrideAttempts(target *int, name string, value interface{}) error {
+	str, _ := cast.ToStringE(value)
+	if str == "" {
+		return nil
+	}
+	attempts, err := cast.ToIntE(str)
+	if err != nil || attempts < 0 {
+		return fmt.Errorf("'%s' must be a non negative number, 0 retries forever", name)
+	}
+	*target = attempts
+	return nil
+}
+
+// override a float if 'value' is set, validated by 'valid'
+func overrideFloat(target *float64, name string, value interface{}, valid func(float64) bool) error {
+	str, _ := cast.ToStringE(value)
+	if str == "" {
+		return nil
+	}
+	number, err := cast.ToFloat64E(str)
+	if err != nil || !valid(number) {
+		return fmt.Errorf("invalid value for '%s'", name)
+	}
+	*target = number
+	return nil
+}
+
+// override a bool if 'value' is set
+func overrideBool(target *bool, name string, value interface{}) error {
+	str, _ := cast.ToStringE(value)
+	if str == "" {
+		return nil
+	}
+	enabled, err := cast.ToBoolE(str)
+	if err != nil {
+		return fmt.Errorf("'%s' must be true or false", name)
+	}
+	*target = enabled
+	return nil
+}
+
+// apply settings named as in the service configuration, from configuration or protocol properties
+func (p *DevicePolicy) apply(settings map[string]interface{}) error {
+	validMultiplier := func(v float64) bool { return v >= 1 }
+	validJitter := func(v float64) bool { return v >= 0 && v < 1 }
+
+	errs := []error{
+		overrideDuration(&p.InferTimeout, InferTimeout, settings[InferTimeout]),
+		overrideDuration(&p.MetadataTimeout, MetadataTimeout, settings[MetadataTimeout]),
+		overrideAttempts(&p.Dial.MaxAttempts, DialRetries, settings[DialRetries]),
+		overrideDuration(&p.Dial.Interval, DialRetryInterval, settings[DialRetryInterval]),
+		overrideAttempts(&p.Reconnect.MaxAttempts, ReconnectAttempts, settings[ReconnectAttempts]),
+		overrideDuration(&p.Reconnect.Interval, ReconnectInterval, settings[ReconnectInterval]),
+		overrideDuration(&p.Reconnect.MaxInterval, ReconnectMaxInterval, settings[ReconnectMaxInterval]),
+		overrideFloat(&p.Reconnect.Multiplier, ReconnectMultiplier, settings[ReconnectMultiplier], validMultiplier),
+		overrideFloat(&p.Reconnect.Jitter, ReconnectJitter, settings[ReconnectJitter], validJitter),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	p.Dial.MaxInterval = p.Dial.Interval
+	return nil
+}
+
+// service level policy from the custom configuration, zero values keep the defaults
+func servicePolicy(c *config.OVMSConfig) (DevicePolicy, error) {
+	policy := defaultPolicy
+	if c == nil {
+		return policy, nil
+	}
+
+	settings := map[string]interface{}{
+		InferTimeout:         c.InferTimeout,
+		MetadataTimeout:      c.MetadataTimeout,
+		DialRetryInterval:    c.DialRetryInterval,
+		ReconnectInterval:    c.ReconnectInterval,
+		ReconnectMaxInterval: c.ReconnectMaxInterval,
+	}
+	// an unset number is 0, retrying forever is only set per device
+	if c.DialRetries != 0 {
+		settings[DialRetries] = c.DialRetries
+	}
+	if c.ReconnectAttempts != 0 {
+		settings[ReconnectAttempts] = c.ReconnectAttempts
+	}
+	if c.ReconnectMultiplier != 0 {
+		settings[ReconnectMultiplier] = c.ReconnectMultiplier
+	}
+	if c.ReconnectJitter != 0 {
+		settings[ReconnectJitter] = c.ReconnectJitter
+	}
+	err := policy.apply(settings)
+	return policy, err
+}
+
+// DevicePolicy returns the service policy overridden by the protocol properties of a device
+func (d *Driver) DevicePolicy(protocol models.ProtocolProperties) (DevicePolicy, error) {
+	policy := d.policy
+	err := policy.apply(protocol)
+	return policy, err
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServicePolicy(t *testing.T) {
+	// unset and zero settings keep the defaults
+	for _, c := range []*config.OVMSConfig{nil, {}, {DialRetries: 0, ReconnectAttempts: 0, ReconnectJitter: 0}} {
+		policy, err := servicePolicy(c)
+		require.NoError(t, err)
+		assert.Equal(t, defaultPolicy, policy)
+		assert.False(t, policy.Dial.Forever())
+		assert.False(t, policy.Reconnect.Forever())
+	}
+
+	policy, err := servicePolicy(&config.OVMSConfig{
+		InferTimeout:        "5s",
+		DialRetries:         1,
+		DialRetryInterval:   "1s",
+		ReconnectAttempts:   10,
+		ReconnectMultiplier: 1.5,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, policy.InferTimeout)
+	assert.Equal(t, defaultPolicy.MetadataTimeout, policy.MetadataTimeout)
+	assert.Equal(t, RetryPolicy{MaxAttempts: 1, Interval: time.Second, MaxInterval: time.Second, Multiplier: 1}, policy.Dial)
+	assert.Equal(t, 10, policy.Reconnect.MaxAttempts)
+	assert.Equal(t, 1.5, policy.Reconnect.Multiplier)
+	assert.Equal(t, defaultPolicy.Reconnect.Jitter, policy.Reconnect.Jitter)
+
+	for _, c := range []*config.OVMSConfig{
+		{DialRetries: -1},
+		{ReconnectAttempts: -1},
+		{InferTimeout: "soon"},
+		{ReconnectMultiplier: 0.5},
+	} {
+		_, err := servicePolicy(c)
+		assert.Error(t, err, c)
+	}
+}
+
+func TestDevicePolicy(t *testing.T) {
+	d := newTestDriver(t)
+	policy, err := d.DevicePolicy(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.Equal(t, d.policy, policy)
+
+	// a device retries forever with 0 attempts
+	policy, err = d.DevicePolicy(models.ProtocolProperties{DialRetries: "0", ReconnectAttempts: 0, ReconnectInterval: "1s"})
+	require.NoError(t, err)
+	assert.True(t, policy.Dial.Forever())
+	assert.True(t, policy.Reconnect.Forever())
+	assert.Equal(t, time.Second, policy.Reconnect.Interval)
+
+	policy, err = d.DevicePolicy(models.ProtocolProperties{ReconnectAttempts: "5"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, policy.Reconnect.MaxAttempts)
+	assert.Equal(t, d.policy.Dial, policy.Dial)
+
+	for _, protocol := range []models.ProtocolProperties{
+		{DialRetries: "-1"},
+		{ReconnectAttempts: "many"},
+		{ReconnectJitter: "1"},
+		{MetadataTimeout: "0s"},
+	} {
+		_, err := d.DevicePolicy(protocol)
+		assert.Error(t, err, protocol)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Interval: time.Second, MaxInterval: 3 * time.Second, Multiplier: 2}
+	assert.False(t, policy.Exhausted(2))
+	assert.True(t, policy.Exhausted(3))
+	assert.Equal(t, "2/3", policy.Progress(2))
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 2*time.Second, policy.Delay(2))
+	assert.Equal(t, 3*time.Second, policy.Delay(5))
+
+	policy.MaxAttempts = 0
+	assert.False(t, policy.Exhausted(100))
+	assert.Equal(t, "100", policy.Progress(100))
+}
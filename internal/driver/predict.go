@@ -11,39 +11,47 @@ package driver
 
 import (
 	"image"
+	"time"
 
-	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
 	"gocv.io/x/gocv"
 )
 
 // Predict image using OpenVINO model server
-func (d *Driver) Predict(grpcClient *grpc_client.GRPCInferenceServiceClient, img gocv.Mat, model string, version string, width int, height int, inputName string) (*grpc_client.ModelInferResponse, error) {
+func (d *Driver) Predict(backend InferenceBackend, img gocv.Mat, model string, version string, input *ModelInput, timeout time.Duration) (*InferResponse, error) {
 
-	// resize image
-	img_resized := gocv.NewMat()
-	gocv.Resize(img, &img_resized, image.Point{X: width, Y: height}, 0, 0, gocv.InterpolationArea)
-	nativeBytes, err := gocv.IMEncode(gocv.JPEGFileExt, img_resized)
+	inferInputs, err := d.prepareInputs(img, input)
 	if err != nil {
-		d.lc.Errorf("Error encoding image: %s", err)
-		nativeBytes.Close()
-		img_resized.Close()
 		return nil, err
 	}
-	// get image bytes
-	inputBytes := nativeBytes.GetBytes()
 
 	// invoke inference
-	inferResponse, err := d.ModelInferRequest(*grpcClient, inputBytes, model, version, inputName)
+	return d.ModelInferRequestInputs(backend, inferInputs, model, version, timeout)
+}
+
+// Predict image in the next batch of a batcher
+func (d *Driver) PredictBatched(batcher *frameBatcher, backend InferenceBackend, img gocv.Mat, input *ModelInput) (*InferResponse, error) {
+
+	inferInputs, err := d.prepareInputs(img, input)
 	if err != nil {
-		nativeBytes.Close()
-		img_resized.Close()
 		return nil, err
 	}
 
-	// close resources
-	nativeBytes.Close()
-	img_resized.Close()
+	return batcher.Infer(backend, inferInputs)
+}
+
+// resize image to the model input and prepare the input tensors
+func (d *Driver) prepareInputs(img gocv.Mat, input *ModelInput) ([]*InferTensor, error) {
 
-	return inferResponse, nil
+	// resize image
+	img_resized := gocv.NewMat()
+	defer img_resized.Close()
+	gocv.Resize(img, &img_resized, image.Point{X: input.Width, Y: input.Height}, 0, 0, gocv.InterpolationArea)
 
+	// encoded image or raw tensor, depending on the input layout
+	inferInputs, err := input.Tensors(img_resized)
+	if err != nil {
+		d.lc.Errorf("Error preparing input '%s': %s", input.Name, err)
+		return nil, err
+	}
+	return inferInputs, nil
 }
@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// default growth of the masked boxes on each side, in fractions of their size
+	defaultPrivacyMargin = 0.1
+	// blocks along the shortest side of a pixelated region
+	privacyBlocks = 8
+	// smallest side of a pixelation block in pixels
+	privacyMinBlock = 4
+)
+
+// PrivacyConfig is what is masked on the frames of a device before they leave it
+type PrivacyConfig struct {
+	// Method is PrivacyBlur or PrivacyPixelate
+	Method string
+	// Labels whose detections are masked, the COCO labels the detections are named by
+	Labels map[string]bool
+	// Zones are masked on every frame
+	Zones []Region
+	// Score is the lowest score of a masked detection, 0 uses the score of the device
+	Score float32
+	// Margin grows the masked boxes on each side, in fractions of their size
+	Margin float64
+}
+
+// Enabled reports whether anything is masked
+func (c PrivacyConfig) Enabled() bool {
+	return len(c.Labels) > 0 || len(c.Zones) > 0
+}
+
+// parse the privacy masking properties of a device
+func parsePrivacyConfig(protocol models.ProtocolProperties) (PrivacyConfig, error) {
+	config := PrivacyConfig{Method: PrivacyBlur, Labels: make(map[string]bool), Margin: defaultPrivacyMargin}
+
+	method, _ := cast.ToStringE(protocol[PrivacyMask])
+	switch strings.ToLower(method) {
+	case "", PrivacyBlur:
+	case PrivacyPixelate:
+		config.Method = PrivacyPixelate
+	default:
+		return config, fmt.Errorf("invalid '%s' %s, must be %s or %s", PrivacyMask, method, PrivacyBlur, PrivacyPixelate)
+	}
+
+	labels, _ := cast.ToStringE(protocol[PrivacyLabels])
+	for _, label := range strings.Split(labels, ",") {
+		if label = strings.TrimSpace(label); label == "" {
+			continue
+		}
+		// a label the detections never carry would silently never be masked
+		if !knownLabel(label) {
+			return config, fmt.Errorf("invalid '%s' %s, the detections are labeled by the COCO classes or their number past them", PrivacyLabels, label)
+		}
+		config.Labels[label] = true
+	}
+	zones, _ := cast.ToStringE(protocol[PrivacyZones])
+	for _, zone := range strings.Split(zones, ";") {
+		if zone = strings.TrimSpace(zone); zone == "" {
+			continue
+		}
+		region, err := parseRegion(zone)
+		if err != nil {
+			return config, fmt.Errorf("invalid '%s': %v", PrivacyZones, err)
+		}
+		config.Zones = append(config.Zones, region)
+	}
+
+	score := 0.0
+	validScore := func(v float64) bool { return v > 0 && v <= 1 }
+	if err := overrideFloat(&score, PrivacyScore, protocol[PrivacyScore], validScore); err != nil {
+		return config, fmt.Errorf("%v, must be a score between 0 and 1", err)
+	}
+	config.Score = float32(score)
+	validMargin := func(v float64) bool { return v >= 0 && v <= 1 }
+	if err := overrideFloat(&config.Margin, PrivacyMargin, protocol[PrivacyMargin], validMargin); err != nil {
+		return config, fmt.Errorf("%v, must be a fraction of the box size between 0 and 1", err)
+	}
+	return config, nil
+}
+
+// privacyMasker masks the frames of a device leaving it: the zones, and the boxes of the
+// masked labels detected on the frame itself. It is used by the capture loop only.
+type privacyMasker struct {
+	config PrivacyConfig
+	score  float32
+	// masked copy of the frames still used for inference, and the pixelated regions
+	frame gocv.Mat
+	small gocv.Mat
+}
+
+// newPrivacyMasker creates the masker of a device, the detections above 'score' are masked
+// unless the config sets its own score
+func newPrivacyMasker(config PrivacyConfig, score float32) *privacyMasker {
+	if config.Score > 0 {
+		score = config.Score
+	}
+	return &privacyMasker{config: config, score: score, frame: gocv.NewMat(), small: gocv.NewMat()}
+}
+
+// Enabled reports whether anything is masked
+func (m *privacyMasker) Enabled() bool {
+	return m.config.Enabled()
+}
+
+// Withholds reports whether the frames without detections of their own, such as the raw frames,
+// the skipped frames or those failing inference, are kept on the device. With masked labels the
+// objects to mask on them are unknown, the masking fails closed.
+func (m *privacyMasker) Withholds() bool {
+	return len(m.config.Labels) > 0
+}
+
+// regions masked in a frame of 'width' x 'height' pixels with its 'detections'
+func (m *privacyMasker) regions(width int, height int, detections []ObjectDetectionResutl) []image.Rectangle {
+	frame := image.Rect(0, 0, width, height)
+	var regions []image.Rectangle
+	for _, zone := range m.config.Zones {
+		regions = append(regions, zone.Rect(width, height))
+	}
+	for _, row := range detections {
+		if row.Confidence <= m.score || !m.config.Labels[labelName(row.Label)] {
+			continue
+		}
+		rect := detectionRect(row, width, height)
+		dx, dy := int(m.config.Margin*float64(rect.Dx())), int(m.config.Margin*float64(rect.Dy()))
+		rect = image.Rect(rect.Min.X-dx, rect.Min.Y-dy, rect.Max.X+dx, rect.Max.Y+dy).Intersect(frame)
+		if !rect.Empty() {
+			regions = append(regions, rect)
+		}
+	}
+	return regions
+}
+
+// Mask blurs or pixelates the zones and the masked labels of the 'detections' of a frame in place.
+// A frame failing to be masked is blanked, it never leaves the device unmasked.
+func (m *privacyMasker) Mask(img *gocv.Mat, detections []ObjectDetectionResutl) error {
+	if !m.Enabled() || img.Empty() {
+		return nil
+	}
+	for _, rect := range m.regions(img.Cols(), img.Rows(), detections) {
+		if err := m.mask(img, rect); err != nil {
+			img.SetTo(gocv.NewScalar(0, 0, 0, 0))
+			return err
+		}
+	}
+	return nil
+}
+
+// mask a region of a frame, writing through its view
+func (m *privacyMasker) mask(img *gocv.Mat, rect image.Rectangle) error {
+	roi := img.Region(rect)
+	defer roi.Close()
+	side := min(rect.Dx(), rect.Dy())
+	if m.config.Method == PrivacyPixelate {
+		block := max(privacyMinBlock, side/privacyBlocks)
+		size := image.Pt(max(1, rect.Dx()/block), max(1, rect.Dy()/block))
+		if err := gocv.Resize(roi, &m.small, size, 0, 0, gocv.InterpolationArea); err != nil {
+			return err
+		}
+		return gocv.Resize(m.small, &roi, rect.Size(), 0, 0, gocv.InterpolationNearestNeighbor)
+	}
+	// a box kernel of half the region hides the details at any size, at constant cost
+	kernel := max(3, side/2|1)
+	return gocv.Blur(roi, &roi, image.Pt(kernel, kernel))
+}
+
+// Masked returns a copy of a frame still used for inference with its zones masked, or the frame
+// when nothing is masked. The copy is valid until the next call, it is blank when the frame can
+// not be copied.
+func (m *privacyMasker) Masked(img gocv.Mat) (gocv.Mat, error) {
+	if !m.Enabled() || img.Empty() {
+		return img, nil
+	}
+	if err := img.CopyTo(&m.frame); err != nil {
+		m.frame.SetTo(gocv.NewScalar(0, 0, 0, 0))
+		return m.frame, err
+	}
+	return m.frame, m.Mask(&m.frame, nil)
+}
+
+// Close releases the masked copies
+func (m *privacyMasker) Close() {
+	m.frame.Close()
+	m.small.Close()
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"image"
+	"testing"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParsePrivacyConfig(t *testing.T) {
+	config, err := parsePrivacyConfig(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.False(t, config.Enabled())
+	assert.Equal(t, PrivacyBlur, config.Method)
+	assert.Equal(t, defaultPrivacyMargin, config.Margin)
+
+	config, err = parsePrivacyConfig(models.ProtocolProperties{
+		PrivacyLabels: "person, car, 95",
+		PrivacyZones:  "0,0,0.5,0.25; 0.5,0.5,0.5,0.5",
+		PrivacyMask:   "Pixelate",
+		PrivacyScore:  "0.3",
+		PrivacyMargin: "0",
+	})
+	require.NoError(t, err)
+	assert.True(t, config.Enabled())
+	assert.Equal(t, PrivacyPixelate, config.Method)
+	assert.Equal(t, map[string]bool{"person": true, "car": true, "95": true}, config.Labels)
+	assert.Equal(t, []Region{{X: 0, Y: 0, Width: 0.5, Height: 0.25}, {X: 0.5, Y: 0.5, Width: 0.5, Height: 0.5}}, config.Zones)
+	assert.Equal(t, float32(0.3), config.Score)
+	assert.Equal(t, 0.0, config.Margin)
+
+	for _, protocol := range []models.ProtocolProperties{
+		{PrivacyMask: "erase"},
+		{PrivacyLabels: "person, face"},
+		{PrivacyLabels: "license plate"},
+		{PrivacyLabels: "1"},
+		{PrivacyZones: "0,0,1"},
+		{PrivacyZones: "0.5,0.5,0.6,0.5"},
+		{PrivacyScore: "0"},
+		{PrivacyMargin: "-0.1"},
+	} {
+		_, err := parsePrivacyConfig(protocol)
+		assert.Error(t, err, protocol)
+	}
+}
+
+func TestPrivacyMasker(t *testing.T) {
+	config, err := parsePrivacyConfig(models.ProtocolProperties{PrivacyLabels: "person", PrivacyZones: "0,0,0.1,0.1"})
+	require.NoError(t, err)
+	masker := newPrivacyMasker(config, 0.6)
+	defer masker.Close()
+	zone := image.Rect(0, 0, 20, 10)
+	assert.True(t, masker.Withholds())
+	assert.Equal(t, []image.Rectangle{zone}, masker.regions(200, 100, nil))
+
+	// the boxes of the masked labels above the score grow by the margin, within the frame
+	detections := []ObjectDetectionResutl{
+		{Label: 1, Confidence: 0.9, X_min: 0.5, Y_min: 0.5, X_max: 1, Y_max: 1},
+		{Label: 1, Confidence: 0.5, X_min: 0, Y_min: 0, X_max: 0.5, Y_max: 0.5},
+		{Label: 3, Confidence: 0.9, X_min: 0, Y_min: 0, X_max: 0.5, Y_max: 0.5},
+	}
+	assert.Equal(t, []image.Rectangle{zone, image.Rect(90, 45, 200, 100)}, masker.regions(200, 100, detections))
+
+	// the device score is overridden by the privacy score
+	config.Score = 0.4
+	masker = newPrivacyMasker(config, 0.6)
+	defer masker.Close()
+	assert.Len(t, masker.regions(200, 100, detections), 3)
+
+	// the frames without detections of their own only leave the device with zones alone
+	zones := newPrivacyMasker(PrivacyConfig{Zones: []Region{fullFrame}}, 0.6)
+	defer zones.Close()
+	assert.False(t, zones.Withholds())
+}
+
+func TestPrivacyMaskerMasked(t *testing.T) {
+	img := gocv.NewMatWithSize(100, 200, gocv.MatTypeCV8UC3)
+	defer img.Close()
+
+	// nothing is copied without masking
+	masker := newPrivacyMasker(PrivacyConfig{Method: PrivacyBlur}, 0.6)
+	defer masker.Close()
+	masked, err := masker.Masked(img)
+	require.NoError(t, err)
+	assert.Equal(t, img, masked)
+
+	// the frame used for inference is masked as a copy, or in place once inferred
+	for _, method := range []string{PrivacyBlur, PrivacyPixelate} {
+		masker := newPrivacyMasker(PrivacyConfig{Method: method, Zones: []Region{fullFrame}}, 0.6)
+		defer masker.Close()
+		masked, err := masker.Masked(img)
+		require.NoError(t, err, method)
+		assert.Equal(t, masker.frame, masked, method)
+		assert.Equal(t, []int{100, 200}, masked.Size(), method)
+		assert.NoError(t, masker.Mask(&img, nil), method)
+	}
+}
+
+func TestProcessMjpegStreamWithholds(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "private-camera"
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return ovmstest.NewReplaySource(uri, false)
+	}
+	server := startFakeServer(t, d, deviceName)
+	streams := d.NewStreamClient(deviceName, nil)
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+	protocols := testProtocols()
+	protocols[Protocol][PrivacyLabels] = "person"
+
+	// no frame leaves the device before its persons are detected
+	server.SetInferError(status.Error(codes.Unavailable, "loading"))
+	err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+	assert.Error(t, err)
+	for _, variant := range []string{StreamRaw, StreamAnnotated, StreamEvents} {
+		snapshot, err := streams.Snapshot(variant)
+		require.NoError(t, err)
+		assert.Nil(t, snapshot, variant)
+	}
+
+	// the inferred frames leave it masked with their own detections
+	server.SetInferError(nil)
+	err = d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+	assert.Error(t, err)
+	for _, variant := range []string{StreamRaw, StreamAnnotated} {
+		snapshot, err := streams.Snapshot(variant)
+		require.NoError(t, err)
+		assert.NotNil(t, snapshot, variant)
+	}
+}
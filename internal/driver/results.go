@@ -0,0 +1,337 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// detection events kept per device, replayed to new subscribers
+	resultHistory = 20
+	// detection events buffered per subscriber, a slow subscriber misses events
+	subscriberBuffer = 16
+	// interval of the keep-alive comments and pings of the result feeds
+	feedKeepAlive = 15 * time.Second
+	// time given to a write to a WebSocket client of the result feeds
+	feedWriteTimeout = 10 * time.Second
+)
+
+// Detection is a detection above the score, with its box in coordinates relative to the frame
+type Detection struct {
+	Label string  `json:"label"`
+	Score float32 `json:"score"`
+	XMin  float32 `json:"x_min"`
+	YMin  float32 `json:"y_min"`
+	XMax  float32 `json:"x_max"`
+	YMax  float32 `json:"y_max"`
+}
+
+// DetectionEvent is the result of an inferred frame with detections above the score
+type DetectionEvent struct {
+	Device     string      `json:"device"`
+	Model      string      `json:"model"`
+	Time       time.Time   `json:"time"`
+	Detections []Detection `json:"detections"`
+}
+
+// new detection event of a device from the detections of a frame
+func newDetectionEvent(deviceName string, model string, now time.Time, rows []ObjectDetectionResutl) DetectionEvent {
+	event := DetectionEvent{Device: deviceName, Model: model, Time: now, Detections: make([]Detection, 0, len(rows))}
+	for _, row := range rows {
+		event.Detections = append(event.Detections, Detection{
+			Label: labelName(row.Label),
+			Score: row.Confidence,
+			XMin:  row.X_min,
+			YMin:  row.Y_min,
+			XMax:  row.X_max,
+			YMax:  row.Y_max,
+		})
+	}
+	return event
+}
+
+// resultHub fans the detection events of the devices out to the subscribers of the result feeds
+type resultHub struct {
+	mu          sync.Mutex
+	subscribers map[chan DetectionEvent]struct{}
+	history     map[string][]DetectionEvent
+}
+
+func newResultHub() *resultHub {
+	return &resultHub{
+		subscribers: make(map[chan DetectionEvent]struct{}),
+		history:     make(map[string][]DetectionEvent),
+	}
+}
+
+// Publish an event to the subscribers without waiting for them
+func (h *resultHub) Publish(event DetectionEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	history := append(h.history[event.Device], event)
+	if len(history) > resultHistory {
+		history = history[len(history)-resultHistory:]
+	}
+	h.history[event.Device] = history
+	for subscriber := range h.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe to the events of all devices, with the recent events sorted by time.
+// The channel is closed by cancel or when the hub closes.
+func (h *resultHub) Subscribe() ([]DetectionEvent, <-chan DetectionEvent, func()) {
+	subscriber := make(chan DetectionEvent, subscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var recent []DetectionEvent
+	for _, history := range h.history {
+		recent = append(recent, history...)
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i].Time.Before(recent[j].Time) })
+	h.subscribers[subscriber] = struct{}{}
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[subscriber]; ok {
+			delete(h.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return recent, subscriber, cancel
+}
+
+// RemoveDevice drops the recent events of a removed device
+func (h *resultHub) RemoveDevice(deviceName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.history, deviceName)
+}
+
+// Close ends the subscriptions
+func (h *resultHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for subscriber := range h.subscribers {
+		delete(h.subscribers, subscriber)
+		close(subscriber)
+	}
+}
+
+// resultFilter selects the detections of the result feeds by the query parameters
+// 'label', comma-separated or repeated, and 'score', the minimum score
+type resultFilter struct {
+	labels map[string]bool
+	score  float32
+}
+
+// parse the filter of the query of a result feed
+func parseResultFilter(query url.Values) (resultFilter, error) {
+	var filter resultFilter
+	for _, value := range query["label"] {
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				if filter.labels == nil {
+					filter.labels = make(map[string]bool)
+				}
+				filter.labels[label] = true
+			}
+		}
+	}
+	if str := query.Get("score"); str != "" {
+		score, err := strconv.ParseFloat(str, 32)
+		if err != nil || score < 0 || score > 1 {
+			return filter, fmt.Errorf("'score' must be a number between 0 and 1")
+		}
+		filter.score = float32(score)
+	}
+	return filter, nil
+}
+
+// apply the filter to the detections of an event, false when none is left
+func (f resultFilter) apply(event DetectionEvent) (DetectionEvent, bool) {
+	detections := make([]Detection, 0, len(event.Detections))
+	for _, detection := range event.Detections {
+		if detection.Score >= f.score && (f.labels == nil || f.labels[detection.Label]) {
+			detections = append(detections, detection)
+		}
+	}
+	event.Detections = detections
+	return event, len(detections) > 0
+}
+
+// the WebSocket clients of the result feeds are served from the origin of the live server,
+// or without origin as non-browser clients
+var resultsUpgrader = websocket.Upgrader{}
+
+// ResultsHandler serves the detection events of a device, or of all devices the client may access,
+// starting with the recent events. A WebSocket upgrade request gets every event as a JSON text message,
+// other requests get Server-Sent Events.
+func (d *Driver) ResultsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceName := r.PathValue("device")
+		if deviceName != "" {
+			if _, ok := d.DeviceStatus(deviceName); !ok {
+				http.Error(w, "unknown device "+deviceName, http.StatusNotFound)
+				return
+			}
+			if !d.live.Authorized(r, deviceName) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		filter, err := parseResultFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		match := func(event DetectionEvent) (DetectionEvent, bool) {
+			if deviceName != "" && event.Device != deviceName || !d.live.Authorized(r, event.Device) {
+				return event, false
+			}
+			return filter.apply(event)
+		}
+
+		if websocket.IsWebSocketUpgrade(r) {
+			d.serveResultsWebSocket(w, r, match)
+		} else {
+			d.serveResultsEvents(w, r, match)
+		}
+	})
+}
+
+// serve the matching detection events as Server-Sent Events
+func (d *Driver) serveResultsEvents(w http.ResponseWriter, r *http.Request, match func(DetectionEvent) (DetectionEvent, bool)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	recent, events, cancel := d.results.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(event DetectionEvent) error {
+		event, ok := match(event)
+		if !ok {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: detection\ndata: %s\n\n", data)
+		return err
+	}
+	for _, event := range recent {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serve the matching detection events as WebSocket text messages, pinging the client to keep it alive
+func (d *Driver) serveResultsWebSocket(w http.ResponseWriter, r *http.Request, match func(DetectionEvent) (DetectionEvent, bool)) {
+	recent, events, cancel := d.results.Subscribe()
+	defer cancel()
+	// the upgrader replies to a failed upgrade
+	conn, err := resultsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		d.lc.Debugf("Failed to upgrade the result feed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// the messages of the client are discarded, its close ends the feed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event DetectionEvent) error {
+		event, ok := match(event)
+		if !ok {
+			return nil
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+	for _, event := range recent {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(feedKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// the service stops
+				message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+				_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(feedWriteTimeout))
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(feedWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// read the next event of a Server-Sent Events feed, skipping the comments
+func readServerEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestResultHub(t *testing.T) {
+	hub := newResultHub()
+	start := time.Now()
+	for i := 0; i < resultHistory+5; i++ {
+		hub.Publish(DetectionEvent{Device: "camera-a", Time: start.Add(time.Duration(2*i) * time.Millisecond)})
+	}
+	hub.Publish(DetectionEvent{Device: "camera-b", Time: start.Add(time.Millisecond)})
+
+	// the recent events of the devices are replayed in order
+	recent, events, cancel := hub.Subscribe()
+	require.Len(t, recent, resultHistory+1)
+	assert.Equal(t, "camera-b", recent[0].Device)
+	assert.Equal(t, "camera-a", recent[1].Device)
+	assert.Equal(t, start.Add(10*time.Millisecond), recent[1].Time)
+
+	hub.Publish(DetectionEvent{Device: "camera-b"})
+	assert.Equal(t, "camera-b", (<-events).Device)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	hub.RemoveDevice("camera-b")
+	recent, events, _ = hub.Subscribe()
+	assert.Len(t, recent, resultHistory)
+	hub.Close()
+	_, ok = <-events
+	assert.False(t, ok)
+}
+
+func TestResultFilter(t *testing.T) {
+	filter, err := parseResultFilter(url.Values{"label": {"car, person", "bus"}, "score": {"0.6"}})
+	require.NoError(t, err)
+	assert.Equal(t, resultFilter{labels: map[string]bool{"car": true, "person": true, "bus": true}, score: 0.6}, filter)
+
+	event := DetectionEvent{Device: "camera", Detections: []Detection{
+		{Label: "car", Score: 0.9},
+		{Label: "car", Score: 0.5},
+		{Label: "bicycle", Score: 0.9},
+	}}
+	filtered, ok := filter.apply(event)
+	assert.True(t, ok)
+	assert.Equal(t, []Detection{{Label: "car", Score: 0.9}}, filtered.Detections)
+	assert.Len(t, event.Detections, 3)
+
+	_, ok = resultFilter{labels: map[string]bool{"person": true}}.apply(event)
+	assert.False(t, ok)
+	_, ok = resultFilter{}.apply(event)
+	assert.True(t, ok)
+
+	for _, score := range []string{"high", "-0.1", "1.5"} {
+		_, err := parseResultFilter(url.Values{"score": {score}})
+		assert.Error(t, err, score)
+	}
+}
+
+func TestResultsHandler(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "results-camera"
+	startFakeServer(t, d, deviceName)
+	server := httptest.NewServer(d.live)
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL + "/results")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the detections of the inferred frames are pushed
+	require.NoError(t, d.AddDevice(deviceName, testProtocols(), models.Unlocked))
+	name, data := readServerEvent(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, "detection", name)
+	var event DetectionEvent
+	require.NoError(t, json.Unmarshal([]byte(data), &event))
+	assert.Equal(t, deviceName, event.Device)
+	assert.Equal(t, testModel, event.Model)
+	require.Len(t, event.Detections, 1)
+	assert.Equal(t, Detection{Label: "car", Score: 0.9, XMin: 0.25, YMin: 0.25, XMax: 0.5, YMax: 0.75}, event.Detections[0])
+}
+
+func TestResultsWebSocket(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "websocket-camera"
+	startFakeServer(t, d, deviceName)
+	server := httptest.NewServer(d.live)
+	t.Cleanup(server.Close)
+	feedURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/results/" + deviceName
+
+	// the feed of a device is found once its capture starts
+	_, resp, err := websocket.DefaultDialer.Dial(feedURL, nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, d.AddDevice(deviceName, testProtocols(), models.Unlocked))
+	require.Eventually(t, func() bool {
+		_, ok := d.DeviceStatus(deviceName)
+		return ok
+	}, time.Second, time.Millisecond)
+
+	_, resp, err = websocket.DefaultDialer.Dial(feedURL+"?score=high", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(feedURL+"?label=car&score=0.8", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var event DetectionEvent
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, deviceName, event.Device)
+	require.Len(t, event.Detections, 1)
+	assert.Equal(t, "car", event.Detections[0].Label)
+
+	// the feed ends when the service stops
+	d.results.Close()
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+func TestDashboard(t *testing.T) {
+	d := newTestDriver(t)
+	recorder := serveLive(d, "/", nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "<title>OpenVINO OVMS devices</title>")
+
+	// the legacy streams are still served next to the index
+	assert.Equal(t, http.StatusNotFound, serveLive(d, "/unknown.mjpeg", nil).Code)
+}
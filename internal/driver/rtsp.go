@@ -0,0 +1,318 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"image"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmjpeg"
+	"github.com/bluenviron/gortsplib/v4/pkg/rtptime"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"gocv.io/x/gocv"
+)
+
+// defaults of the RTSP server, see the 'RTSP' settings of the custom configuration
+const (
+	defaultRTSPHost = "0.0.0.0"
+	defaultRTSPPort = 8554
+	// largest frame side of RTP/JPEG, whose sides are multiples of 8 (RFC 2435)
+	rtspMaxDimension = 2040
+)
+
+// RTSP server address from the custom configuration, zero values keep the defaults
+func serviceRTSPAddr(c *config.OVMSConfig) string {
+	host, port := defaultRTSPHost, defaultRTSPPort
+	if c != nil && c.RTSPHost != "" {
+		host = c.RTSPHost
+	}
+	if c != nil && c.RTSPPort != 0 {
+		port = c.RTSPPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// rtspServer re-streams the annotated frames of the devices as RTP/JPEG at rtsp://<addr>/<device>,
+// over the TCP transport, or at rtsps:// with the TLS of the live server. The clients are authenticated
+// and authorized by the live server.
+type rtspServer struct {
+	lc   logger.LoggingClient
+	live *liveServer
+	addr string
+	tls  *tls.Config
+
+	mu     sync.Mutex
+	server *gortsplib.Server
+	// streams by device name, and the stream played by a session
+	streams map[string]*rtspStream
+	readers map[*gortsplib.ServerSession]*rtspStream
+}
+
+func newRTSPServer(lc logger.LoggingClient, live *liveServer) *rtspServer {
+	return &rtspServer{
+		lc:      lc,
+		live:    live,
+		addr:    serviceRTSPAddr(nil),
+		streams: make(map[string]*rtspStream),
+		readers: make(map[*gortsplib.ServerSession]*rtspStream),
+	}
+}
+
+// SetAddr sets the address listened on once the server starts
+func (s *rtspServer) SetAddr(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addr = addr
+}
+
+// SetTLS serves RTSPS with 'config' once the server starts, nil serves plain RTSP
+func (s *rtspServer) SetTLS(config *tls.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tls = config
+}
+
+// AddStream creates the RTSP stream of a device, replacing the previous one.
+// The server starts with the first stream, a listen error is returned.
+func (s *rtspServer) AddStream(deviceName string) (*rtspStream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		server := &gortsplib.Server{Handler: s, RTSPAddress: s.addr}
+		scheme := "rtsp"
+		if s.tls != nil {
+			server.TLSConfig = s.tls.Clone()
+			scheme = "rtsps"
+		}
+		if err := server.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start RTSP server on %s: %v", s.addr, err)
+		}
+		s.server = server
+		s.lc.Infof("RTSP server started for live streaming on %s://%s", scheme, s.addr)
+	}
+	stream, err := newRTSPStream(s, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	s.streams[deviceName] = stream
+	return stream, nil
+}
+
+// remove the stream of a device unless it was replaced
+func (s *rtspServer) remove(deviceName string, stream *rtspStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[deviceName] == stream {
+		delete(s.streams, deviceName)
+	}
+}
+
+// Close stops the server and disconnects its clients
+func (s *rtspServer) Close() {
+	s.mu.Lock()
+	server := s.server
+	s.server = nil
+	s.mu.Unlock()
+	if server != nil {
+		server.Close()
+	}
+}
+
+// the stream of a request path, with the response to an unknown path or an unauthorized client
+func (s *rtspServer) lookup(req *base.Request, path string, query string) (*base.Response, *gortsplib.ServerStream, error) {
+	deviceName, err := url.PathUnescape(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return &base.Response{StatusCode: base.StatusBadRequest}, nil, err
+	}
+	s.mu.Lock()
+	stream, ok := s.streams[deviceName]
+	s.mu.Unlock()
+	if !ok {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, nil
+	}
+	if res := s.authorize(req, query, deviceName); res != nil {
+		return res, nil, nil
+	}
+	return &base.Response{StatusCode: base.StatusOK}, stream.stream, nil
+}
+
+// authorize the client of a request as a live streaming client, nil when authorized.
+// RTSP clients set basic auth, its password is the token with token or JWT authentication.
+// The query is parsed by the server, as the media URLs of the clients append the track to the query.
+func (s *rtspServer) authorize(req *base.Request, query string, deviceName string) *base.Response {
+	auth := s.live.auth
+	if auth == nil {
+		return nil
+	}
+	r := &http.Request{Header: make(http.Header), URL: &url.URL{Path: req.URL.Path, RawQuery: query}}
+	for _, value := range req.Header["Authorization"] {
+		r.Header.Add("Authorization", value)
+	}
+	if _, password, ok := r.BasicAuth(); ok && auth.mode != LiveAuthBasic {
+		r.Header.Set("Authorization", "Bearer "+password)
+	}
+	client, ok, err := auth.authenticate(r)
+	if err != nil {
+		s.lc.Errorf("Error authenticating RTSP client: %v", err)
+	}
+	if !ok {
+		return &base.Response{
+			StatusCode: base.StatusUnauthorized,
+			Header:     base.Header{"WWW-Authenticate": base.HeaderValue{`Basic realm="live"`}},
+		}
+	}
+	if !s.live.Authorized(r.WithContext(context.WithValue(context.Background(), liveClientKey{}, client)), deviceName) {
+		return &base.Response{StatusCode: base.StatusForbidden}
+	}
+	return nil
+}
+
+// OnDescribe implements gortsplib.ServerHandlerOnDescribe
+func (s *rtspServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return s.lookup(ctx.Request, ctx.Path, ctx.Query)
+}
+
+// OnSetup implements gortsplib.ServerHandlerOnSetup
+func (s *rtspServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return s.lookup(ctx.Request, ctx.Path, ctx.Query)
+}
+
+// OnPlay implements gortsplib.ServerHandlerOnPlay, the frames are encoded while the stream has readers
+func (s *rtspServer) OnPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	deviceName, _ := url.PathUnescape(strings.TrimPrefix(ctx.Path, "/"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stream, ok := s.streams[deviceName]; ok {
+		if _, playing := s.readers[ctx.Session]; !playing {
+			s.readers[ctx.Session] = stream
+			stream.readers.Add(1)
+		}
+	}
+	s.lc.Debugf("RTSP client %s plays the stream of device %s", ctx.Conn.NetConn().RemoteAddr(), deviceName)
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// OnSessionClose implements gortsplib.ServerHandlerOnSessionClose
+func (s *rtspServer) OnSessionClose(ctx *gortsplib.ServerHandlerOnSessionCloseCtx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stream, ok := s.readers[ctx.Session]; ok {
+		delete(s.readers, ctx.Session)
+		stream.readers.Add(-1)
+	}
+}
+
+// rtspStream packetizes the annotated frames of a device as RTP/JPEG
+type rtspStream struct {
+	server     *rtspServer
+	deviceName string
+	stream     *gortsplib.ServerStream
+	media      *description.Media
+	readers    atomic.Int32
+
+	mu         sync.Mutex
+	encoder    *rtpmjpeg.Encoder
+	timestamps *rtptime.Encoder
+	start      time.Time
+	// frames are scaled to the limits of RTP/JPEG
+	frame  gocv.Mat
+	closed bool
+}
+
+func newRTSPStream(server *rtspServer, deviceName string) (*rtspStream, error) {
+	forma := &format.MJPEG{}
+	encoder, err := forma.CreateEncoder()
+	if err != nil {
+		return nil, err
+	}
+	timestamps := &rtptime.Encoder{ClockRate: forma.ClockRate()}
+	if err := timestamps.Initialize(); err != nil {
+		return nil, err
+	}
+	media := &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{forma}}
+	return &rtspStream{
+		server:     server,
+		deviceName: deviceName,
+		stream:     gortsplib.NewServerStream(server.server, &description.Session{Medias: []*description.Media{media}}),
+		media:      media,
+		encoder:    encoder,
+		timestamps: timestamps,
+		start:      time.Now(),
+		frame:      gocv.NewMat(),
+	}, nil
+}
+
+// Readers is the number of clients playing the stream
+func (s *rtspStream) Readers() int {
+	return int(s.readers.Load())
+}
+
+// size of a frame within the limits of RTP/JPEG, keeping its aspect ratio
+func rtspSize(width int, height int) (int, int) {
+	width, height = StreamConfig{MaxWidth: rtspMaxDimension, MaxHeight: rtspMaxDimension}.fit(width, height)
+	return max(8, width&^7), max(8, height&^7)
+}
+
+// Write a frame at 'now' to the readers, JPEG encoded by 'encode'
+func (s *rtspStream) Write(img gocv.Mat, encode func(gocv.Mat) ([]byte, error), now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	frame := img
+	if width, height := rtspSize(img.Cols(), img.Rows()); width != img.Cols() || height != img.Rows() {
+		if err := gocv.Resize(img, &s.frame, image.Pt(width, height), 0, 0, gocv.InterpolationArea); err != nil {
+			return err
+		}
+		frame = s.frame
+	}
+	jpeg, err := encode(frame)
+	if err != nil {
+		return err
+	}
+	packets, err := s.encoder.Encode(jpeg)
+	if err != nil {
+		return err
+	}
+	timestamp := s.timestamps.Encode(now.Sub(s.start))
+	for _, packet := range packets {
+		packet.Timestamp = timestamp
+		if err := s.stream.WritePacketRTPWithNTP(s.media, packet, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the stream from the server and disconnects its readers
+func (s *rtspStream) Close() {
+	s.server.remove(s.deviceName, s)
+	s.stream.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.frame.Close()
+}
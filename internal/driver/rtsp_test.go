@@ -0,0 +1,189 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/liberrors"
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/config"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+// a free local address for the RTSP server
+func freeRTSPAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return addr
+}
+
+// play an RTSP stream over TCP, the JPEG frames are sent to the returned channel
+func playRTSP(t *testing.T, rawURL string) (*gortsplib.Client, <-chan []byte, error) {
+	transport := gortsplib.TransportTCP
+	client := &gortsplib.Client{Transport: &transport}
+	u, err := base.ParseURL(rawURL)
+	require.NoError(t, err)
+	require.NoError(t, client.Start(u.Scheme, u.Host))
+	t.Cleanup(client.Close)
+
+	desc, _, err := client.Describe(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	var forma *format.MJPEG
+	media := desc.FindFormat(&forma)
+	require.NotNil(t, media)
+	decoder, err := forma.CreateDecoder()
+	require.NoError(t, err)
+	_, err = client.Setup(desc.BaseURL, media, 0, 0)
+	require.NoError(t, err)
+
+	frames := make(chan []byte, 1)
+	client.OnPacketRTP(media, forma, func(packet *rtp.Packet) {
+		frame, err := decoder.Decode(packet)
+		if err == nil {
+			select {
+			case frames <- frame:
+			default:
+			}
+		}
+	})
+	_, err = client.Play(nil)
+	require.NoError(t, err)
+	return client, frames, nil
+}
+
+func statusCode(err error) base.StatusCode {
+	var badStatus liberrors.ErrClientBadStatusCode
+	if errors.As(err, &badStatus) {
+		return badStatus.Code
+	}
+	return 0
+}
+
+func TestRTSPSize(t *testing.T) {
+	width, height := rtspSize(1920, 1080)
+	assert.Equal(t, []int{1920, 1080}, []int{width, height})
+	width, height = rtspSize(100, 60)
+	assert.Equal(t, []int{96, 56}, []int{width, height})
+	width, height = rtspSize(4096, 2160)
+	assert.Equal(t, []int{2040, 1072}, []int{width, height})
+	width, height = rtspSize(4, 4)
+	assert.Equal(t, []int{8, 8}, []int{width, height})
+}
+
+func TestRTSPStream(t *testing.T) {
+	d := newTestDriver(t)
+	addr := freeRTSPAddr(t)
+	d.rtsp.SetAddr(addr)
+	d.addStream("camera", map[string]models.ProtocolProperties{Protocol: {}})
+	d.addStream("rtsp-camera", map[string]models.ProtocolProperties{Protocol: {RTSP: "true"}})
+	require.NotNil(t, d.streams["rtsp-camera"].rtsp)
+
+	_, _, err := playRTSP(t, "rtsp://"+addr+"/camera")
+	assert.Equal(t, base.StatusNotFound, statusCode(err), err)
+
+	client, frames, err := playRTSP(t, "rtsp://"+addr+"/rtsp-camera")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return d.streams["rtsp-camera"].rtsp.Readers() == 1 }, time.Second, time.Millisecond)
+
+	// the annotated frames are scaled to multiples of 8
+	frame := gocv.NewMatWithSize(60, 100, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+	var jpeg []byte
+	require.Eventually(t, func() bool {
+		if err := d.WriteStream("rtsp-camera", StreamAnnotated, frame); err != nil {
+			return false
+		}
+		select {
+		case jpeg = <-frames:
+			return true
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+	width, height := jpegSize(t, jpeg)
+	assert.Equal(t, []int{96, 56}, []int{width, height})
+
+	// the readers of a removed device are disconnected
+	require.NoError(t, d.RemoveDevice("rtsp-camera", testProtocols()))
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the RTSP client of a removed device is still connected")
+	}
+	_, _, err = playRTSP(t, "rtsp://"+addr+"/rtsp-camera")
+	assert.Equal(t, base.StatusNotFound, statusCode(err), err)
+}
+
+func TestRTSPAuth(t *testing.T) {
+	d := newTestDriver(t)
+	addr := freeRTSPAddr(t)
+	d.rtsp.SetAddr(addr)
+	d.live.auth = &liveAuth{mode: LiveAuthToken, credentials: map[string]string{"alice": "alice-token", "bob": "bob-token"}}
+	d.addStream("private-camera", map[string]models.ProtocolProperties{Protocol: {RTSP: "true", LiveAccess: "alice"}})
+
+	// the token is the password of basic auth, or the access token query parameter
+	_, _, err := playRTSP(t, "rtsp://"+addr+"/private-camera")
+	assert.Equal(t, base.StatusUnauthorized, statusCode(err), err)
+	_, _, err = playRTSP(t, "rtsp://bob:bob-token@"+addr+"/private-camera")
+	assert.Equal(t, base.StatusForbidden, statusCode(err), err)
+	_, _, err = playRTSP(t, "rtsp://alice:alice-token@"+addr+"/private-camera")
+	assert.NoError(t, err)
+	_, _, err = playRTSP(t, "rtsp://"+addr+"/private-camera?access_token=alice-token")
+	assert.NoError(t, err)
+
+	d.live.auth = &liveAuth{mode: LiveAuthBasic, credentials: map[string]string{"alice": "alice-password"}}
+	_, _, err = playRTSP(t, "rtsp://alice:alice-token@"+addr+"/private-camera")
+	assert.Equal(t, base.StatusUnauthorized, statusCode(err), err)
+	_, _, err = playRTSP(t, "rtsp://alice:alice-password@"+addr+"/private-camera")
+	assert.NoError(t, err)
+}
+
+func TestRTSPTLS(t *testing.T) {
+	d := newTestDriver(t)
+	addr := freeRTSPAddr(t)
+	d.rtsp.SetAddr(addr)
+	certFile, keyFile, pool := writeServerCert(t, t.TempDir())
+	tlsConfig, err := d.newLiveTLSConfig(&config.OVMSConfig{LiveTLS: true, LiveCert: certFile, LiveKey: keyFile})
+	require.NoError(t, err)
+	d.rtsp.SetTLS(tlsConfig)
+	d.live.auth = &liveAuth{mode: LiveAuthToken, credentials: map[string]string{"alice": "alice-token"}}
+	d.addStream("secure-camera", map[string]models.ProtocolProperties{Protocol: {RTSP: "true"}})
+
+	// the credentials are only accepted over RTSPS
+	describe := func(rawURL string, tlsConfig *tls.Config) error {
+		transport := gortsplib.TransportTCP
+		client := &gortsplib.Client{Transport: &transport, TLSConfig: tlsConfig, ReadTimeout: time.Second}
+		u, err := base.ParseURL(rawURL)
+		require.NoError(t, err)
+		require.NoError(t, client.Start(u.Scheme, u.Host))
+		defer client.Close()
+		_, _, err = client.Describe(u)
+		return err
+	}
+	assert.Error(t, describe("rtsp://alice:alice-token@"+addr+"/secure-camera", nil))
+	err = describe("rtsps://"+addr+"/secure-camera", &tls.Config{RootCAs: pool})
+	assert.Equal(t, base.StatusUnauthorized, statusCode(err), err)
+	assert.NoError(t, describe("rtsps://alice:alice-token@"+addr+"/secure-camera", &tls.Config{RootCAs: pool}))
+}
@@ -0,0 +1,400 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// default fraction of changed pixels triggering inference with the motion strategy
+	defaultMotionThreshold = 0.01
+	// default time a change of the motion state lasts before it is reported
+	defaultMotionHoldOff = time.Second
+	// default lowest rate of the adaptive mode
+	defaultMinInferFPS = 1.0
+	// width frames are scaled down to before differencing
+	motionWidth = 160
+	// difference of a gray pixel counted as changed
+	motionPixelThreshold = 25
+	// foreground value of a background subtraction mask, shadows are below
+	motionForeground = 200
+
+	// the adaptive rate changes at most once per interval
+	adaptInterval = time.Second
+	// latency above the baseline by this factor lowers the rate, below 'latencyRecover' raises it
+	latencyRise    = 1.5
+	latencyRecover = 1.2
+	// weight of a new latency in its moving average
+	latencyWeight = 0.2
+
+	// detections are drawn on the skipped frames for at most this long after their inference
+	overlayHold = 2 * time.Second
+)
+
+// SamplingConfig is how the frames of a device are sampled for inference
+type SamplingConfig struct {
+	// Strategy is one of SkipNone, SkipNth, SkipTime or SkipMotion
+	Strategy string
+	// Nth infers every Nth frame with SkipNth
+	Nth int
+	// TargetFPS caps the inference rate, 0 does not
+	TargetFPS float64
+	// MotionThreshold is the fraction of changed pixels of MotionROI triggering inference with SkipMotion
+	MotionThreshold float64
+	// MotionMethod is MotionDiff or MotionMOG2
+	MotionMethod string
+	MotionROI    Region
+	// MotionKeepAlive infers a frame at least this often without motion, 0 does not
+	MotionKeepAlive time.Duration
+	// MotionHoldOff is how long a change of the motion state lasts before it is reported
+	MotionHoldOff time.Duration
+	// Adaptive lowers the rate down to MinFPS when the inference latency rises
+	Adaptive bool
+	MinFPS   float64
+}
+
+// Region is a rectangle of a frame in fractions of its width and height
+type Region struct {
+	X, Y, Width, Height float64
+}
+
+// fullFrame is the region of a whole frame
+var fullFrame = Region{X: 0, Y: 0, Width: 1, Height: 1}
+
+// parse a region of "x,y,width,height" fractions of a frame
+func parseRegion(str string) (Region, error) {
+	var values []float64
+	for _, field := range strings.Split(str, ",") {
+		value, err := cast.ToFloat64E(strings.TrimSpace(field))
+		if err != nil || value < 0 || value > 1 {
+			return Region{}, fmt.Errorf("region %s must be x,y,width,height fractions of the frame between 0 and 1", str)
+		}
+		values = append(values, value)
+	}
+	if len(values) != 4 {
+		return Region{}, fmt.Errorf("region %s must be x,y,width,height fractions of the frame between 0 and 1", str)
+	}
+	region := Region{X: values[0], Y: values[1], Width: values[2], Height: values[3]}
+	if region.Width == 0 || region.Height == 0 || region.X+region.Width > 1 || region.Y+region.Height > 1 {
+		return Region{}, fmt.Errorf("region %s must be a non empty rectangle within the frame", str)
+	}
+	return region, nil
+}
+
+// Rect returns the region in a frame of 'width' x 'height' pixels, at least one pixel large
+func (r Region) Rect(width int, height int) image.Rectangle {
+	rect := image.Rect(int(r.X*float64(width)), int(r.Y*float64(height)), int((r.X+r.Width)*float64(width)), int((r.Y+r.Height)*float64(height)))
+	if rect.Dx() == 0 {
+		rect.Max.X = rect.Min.X + 1
+	}
+	if rect.Dy() == 0 {
+		rect.Max.Y = rect.Min.Y + 1
+	}
+	return rect.Intersect(image.Rect(0, 0, width, height))
+}
+
+// parse a positive float property, 0 when not set
+func parsePositiveFloat(protocol models.ProtocolProperties, name string) (float64, error) {
+	str, _ := cast.ToStringE(protocol[name])
+	if str == "" {
+		return 0, nil
+	}
+	value, err := cast.ToFloat64E(str)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("'%s' must be a positive number", name)
+	}
+	return value, nil
+}
+
+// parse the frame sampling properties of a device
+func parseSamplingConfig(protocol models.ProtocolProperties) (SamplingConfig, error) {
+	config := SamplingConfig{
+		Strategy:        SkipNone,
+		MotionThreshold: defaultMotionThreshold,
+		MotionMethod:    MotionDiff,
+		MotionROI:       fullFrame,
+		MotionHoldOff:   defaultMotionHoldOff,
+	}
+
+	var err error
+	if config.TargetFPS, err = parsePositiveFloat(protocol, InferFPS); err != nil {
+		return config, err
+	}
+	if threshold, err := parsePositiveFloat(protocol, MotionThreshold); err != nil {
+		return config, err
+	} else if threshold > 1 {
+		return config, fmt.Errorf("'%s' must be a fraction of the frame between 0 and 1", MotionThreshold)
+	} else if threshold > 0 {
+		config.MotionThreshold = threshold
+	}
+
+	method, _ := cast.ToStringE(protocol[MotionMethod])
+	switch strings.ToLower(method) {
+	case "", MotionDiff:
+	case MotionMOG2:
+		config.MotionMethod = MotionMOG2
+	default:
+		return config, fmt.Errorf("invalid '%s' %s, must be %s or %s", MotionMethod, method, MotionDiff, MotionMOG2)
+	}
+	if roi, _ := cast.ToStringE(protocol[MotionROI]); roi != "" {
+		if config.MotionROI, err = parseRegion(roi); err != nil {
+			return config, fmt.Errorf("invalid '%s': %v", MotionROI, err)
+		}
+	}
+	if err := overrideDuration(&config.MotionKeepAlive, MotionKeepAlive, protocol[MotionKeepAlive]); err != nil {
+		return config, err
+	}
+	if err := overrideDuration(&config.MotionHoldOff, MotionHoldOff, protocol[MotionHoldOff]); err != nil {
+		return config, err
+	}
+
+	strategy, _ := cast.ToStringE(protocol[FrameSkip])
+	switch strings.ToLower(strategy) {
+	case "":
+		if config.TargetFPS > 0 {
+			config.Strategy = SkipTime
+		}
+	case SkipNone:
+	case SkipNth:
+		config.Strategy = SkipNth
+		str, _ := cast.ToStringE(protocol[SkipFrames])
+		nth, err := cast.ToIntE(str)
+		if err != nil || nth < 1 {
+			return config, fmt.Errorf("'%s' must be a positive number of frames with '%s' %s", SkipFrames, FrameSkip, SkipNth)
+		}
+		config.Nth = nth
+	case SkipTime:
+		if config.TargetFPS == 0 {
+			return config, fmt.Errorf("'%s' must be set with '%s' %s", InferFPS, FrameSkip, SkipTime)
+		}
+		config.Strategy = SkipTime
+	case SkipMotion:
+		config.Strategy = SkipMotion
+	default:
+		return config, fmt.Errorf("invalid '%s' %s, must be %s, %s, %s or %s", FrameSkip, strategy, SkipNone, SkipNth, SkipTime, SkipMotion)
+	}
+
+	if adaptive, _ := cast.ToStringE(protocol[AdaptiveFPS]); adaptive != "" {
+		if config.Adaptive, err = cast.ToBoolE(adaptive); err != nil {
+			return config, fmt.Errorf("'%s' must be true or false", AdaptiveFPS)
+		}
+	}
+	if config.MinFPS, err = parsePositiveFloat(protocol, MinInferFPS); err != nil {
+		return config, err
+	}
+	if config.Adaptive {
+		if config.TargetFPS == 0 {
+			return config, fmt.Errorf("'%s' must be set with '%s'", InferFPS, AdaptiveFPS)
+		}
+		if config.MinFPS == 0 {
+			config.MinFPS = math.Min(defaultMinInferFPS, config.TargetFPS)
+		}
+		if config.MinFPS > config.TargetFPS {
+			return config, fmt.Errorf("'%s' must not be above '%s'", MinInferFPS, InferFPS)
+		}
+	}
+	return config, nil
+}
+
+// frameSampler selects the frames of a device sent for inference, it is used by the capture loop only
+type frameSampler struct {
+	config SamplingConfig
+	// rate is the current inference rate, 0 when not capped
+	rate   float64
+	frames int
+	last   time.Time
+	// previous gray frame and background model of the motion strategy
+	previous   gocv.Mat
+	subtractor *gocv.BackgroundSubtractorMOG2
+	moving     bool
+	// reported motion state, and since when the frames disagree with it
+	reported    bool
+	motionSince time.Time
+	// moving average of the inference latency, and the lowest one seen
+	latency  time.Duration
+	baseline time.Duration
+	adjusted time.Time
+}
+
+func newFrameSampler(config SamplingConfig) *frameSampler {
+	s := &frameSampler{config: config, rate: config.TargetFPS, previous: gocv.NewMat()}
+	if config.Strategy == SkipMotion && config.MotionMethod == MotionMOG2 {
+		subtractor := gocv.NewBackgroundSubtractorMOG2()
+		s.subtractor = &subtractor
+	}
+	return s
+}
+
+// Enabled reports whether frames may be skipped
+func (s *frameSampler) Enabled() bool {
+	return s.config.Strategy != SkipNone || s.rate > 0
+}
+
+// Moving reports whether the last frame sampled with the motion strategy had motion
+func (s *frameSampler) Moving() bool {
+	return s.moving
+}
+
+// Motion returns the motion state reported at 'now'. A change is reported once it lasted
+// MotionHoldOff, a flickering detection does not flood the motion events.
+func (s *frameSampler) Motion(now time.Time) bool {
+	if s.moving == s.reported {
+		s.motionSince = time.Time{}
+		return s.reported
+	}
+	if s.motionSince.IsZero() {
+		s.motionSince = now
+	}
+	if now.Sub(s.motionSince) >= s.config.MotionHoldOff {
+		s.reported = s.moving
+		s.motionSince = time.Time{}
+	}
+	return s.reported
+}
+
+// Rate returns the current inference rate, 0 when not capped
+func (s *frameSampler) Rate() float64 {
+	return s.rate
+}
+
+// Sample reports whether a frame read at 'now' is sent for inference, never when not 'idle'
+// as an inference is already running. Motion is detected on every frame.
+func (s *frameSampler) Sample(img gocv.Mat, now time.Time, idle bool) bool {
+	if s.config.Strategy == SkipMotion {
+		s.moving = s.motion(img)
+	}
+	if !idle {
+		return false
+	}
+	switch s.config.Strategy {
+	case SkipNth:
+		s.frames++
+		if (s.frames-1)%s.config.Nth != 0 {
+			return false
+		}
+	case SkipMotion:
+		// a frame without motion is still inferred once per keep-alive interval
+		keepAlive := s.config.MotionKeepAlive > 0 && now.Sub(s.last) >= s.config.MotionKeepAlive
+		if !s.moving && !keepAlive {
+			return false
+		}
+	}
+	if s.rate > 0 && !s.last.IsZero() && now.Sub(s.last) < time.Duration(float64(time.Second)/s.rate) {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// motion reports whether enough pixels of the region of interest changed, by difference with the previous frame
+// or from the background model. The first frame is a motion.
+func (s *frameSampler) motion(img gocv.Mat) bool {
+	small := gocv.NewMat()
+	defer small.Close()
+	height := img.Rows() * motionWidth / img.Cols()
+	if height < 1 {
+		height = 1
+	}
+	if err := gocv.Resize(img, &small, image.Point{X: motionWidth, Y: height}, 0, 0, gocv.InterpolationArea); err != nil {
+		return true
+	}
+
+	gray := gocv.NewMat()
+	defer gray.Close()
+	if small.Channels() == 1 {
+		small.CopyTo(&gray)
+	} else if err := gocv.CvtColor(small, &gray, gocv.ColorBGRToGray); err != nil {
+		return true
+	}
+	if err := gocv.GaussianBlur(gray, &gray, image.Point{X: 5, Y: 5}, 0, 0, gocv.BorderDefault); err != nil {
+		return true
+	}
+
+	mask := gocv.NewMat()
+	defer mask.Close()
+	if s.subtractor != nil {
+		first := s.previous.Empty()
+		if err := s.subtractor.Apply(gray, &mask); err != nil {
+			return true
+		}
+		// the background model only needs to know a frame was seen
+		if first {
+			gray.CopyTo(&s.previous)
+			return true
+		}
+		gocv.Threshold(mask, &mask, motionForeground, 255, gocv.ThresholdBinary)
+	} else {
+		previous := s.previous
+		s.previous = gray.Clone()
+		defer previous.Close()
+		if previous.Empty() || previous.Rows() != gray.Rows() {
+			return true
+		}
+		if err := gocv.AbsDiff(previous, gray, &mask); err != nil {
+			return true
+		}
+		gocv.Threshold(mask, &mask, motionPixelThreshold, 255, gocv.ThresholdBinary)
+	}
+
+	roi := mask.Region(s.config.MotionROI.Rect(mask.Cols(), mask.Rows()))
+	defer roi.Close()
+	changed := float64(gocv.CountNonZero(roi)) / float64(roi.Total())
+	return changed >= s.config.MotionThreshold
+}
+
+// Observe records the latency of an inference finished at 'now' and adapts the rate,
+// it reports whether the rate changed
+func (s *frameSampler) Observe(latency time.Duration, now time.Time) bool {
+	if !s.config.Adaptive {
+		return false
+	}
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(float64(s.latency)*(1-latencyWeight) + float64(latency)*latencyWeight)
+	}
+	if s.baseline == 0 || s.latency < s.baseline {
+		s.baseline = s.latency
+	}
+	if now.Sub(s.adjusted) < adaptInterval {
+		return false
+	}
+	s.adjusted = now
+
+	rate := s.rate
+	switch {
+	case float64(s.latency) > float64(s.baseline)*latencyRise:
+		rate = math.Max(s.config.MinFPS, rate*0.75)
+	case float64(s.latency) < float64(s.baseline)*latencyRecover:
+		rate = math.Min(s.config.TargetFPS, rate+s.config.TargetFPS/10)
+	}
+	if rate == s.rate {
+		return false
+	}
+	s.rate = rate
+	return true
+}
+
+// Close releases the previous frame and background model of the motion strategy
+func (s *frameSampler) Close() {
+	s.previous.Close()
+	if s.subtractor != nil {
+		s.subtractor.Close()
+	}
+}
@@ -0,0 +1,321 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+// frame source delivering frames at a fixed rate, as a camera does
+type pacedSource struct {
+	FrameSource
+	interval time.Duration
+}
+
+func (s *pacedSource) Read(img *gocv.Mat) bool {
+	time.Sleep(s.interval)
+	return s.FrameSource.Read(img)
+}
+
+// fill the motion settings left out by a test case with their defaults
+func withMotionDefaults(config SamplingConfig) SamplingConfig {
+	if config.MotionMethod == "" {
+		config.MotionMethod = MotionDiff
+	}
+	if config.MotionROI == (Region{}) {
+		config.MotionROI = fullFrame
+	}
+	if config.MotionHoldOff == 0 {
+		config.MotionHoldOff = defaultMotionHoldOff
+	}
+	return config
+}
+
+// 160x120 frame with its left or right half lit
+func halfLitFrame(t *testing.T, left bool) gocv.Mat {
+	pixels := make([]byte, 120*160*3)
+	for i := range pixels {
+		if x := i / 3 % 160; (x < 80) == left {
+			pixels[i] = 200
+		}
+	}
+	img, err := gocv.NewMatFromBytes(120, 160, gocv.MatTypeCV8UC3, pixels)
+	require.NoError(t, err)
+	return img
+}
+
+func TestParseRegion(t *testing.T) {
+	region, err := parseRegion("0, 0.5, 1, 0.5")
+	require.NoError(t, err)
+	assert.Equal(t, Region{X: 0, Y: 0.5, Width: 1, Height: 0.5}, region)
+	assert.Equal(t, image.Rect(0, 60, 160, 120), region.Rect(160, 120))
+	assert.Equal(t, image.Rect(0, 0, 1, 1), Region{Width: 0.001, Height: 0.001}.Rect(160, 120))
+
+	for _, str := range []string{"0,0,1", "0,0,1,1,1", "0,0,2,1", "0.5,0,0.6,1", "0,0,0,1", "a,0,1,1"} {
+		_, err := parseRegion(str)
+		assert.Error(t, err, str)
+	}
+}
+
+func TestParseSamplingConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol models.ProtocolProperties
+		expected SamplingConfig
+		err      string
+	}{
+		{"default", nil, SamplingConfig{Strategy: SkipNone, MotionThreshold: defaultMotionThreshold}, ""},
+		{"fps", models.ProtocolProperties{InferFPS: "5"}, SamplingConfig{Strategy: SkipTime, TargetFPS: 5, MotionThreshold: defaultMotionThreshold}, ""},
+		{"nth", models.ProtocolProperties{FrameSkip: "NTH", SkipFrames: "3"}, SamplingConfig{Strategy: SkipNth, Nth: 3, MotionThreshold: defaultMotionThreshold}, ""},
+		{"motion capped", models.ProtocolProperties{FrameSkip: "motion", InferFPS: "2", MotionThreshold: "0.05"}, SamplingConfig{Strategy: SkipMotion, TargetFPS: 2, MotionThreshold: 0.05}, ""},
+		{"adaptive", models.ProtocolProperties{InferFPS: "10", AdaptiveFPS: "true"}, SamplingConfig{Strategy: SkipTime, TargetFPS: 10, MotionThreshold: defaultMotionThreshold, Adaptive: true, MinFPS: defaultMinInferFPS}, ""},
+		{"adaptive slow", models.ProtocolProperties{InferFPS: "0.5", AdaptiveFPS: "true"}, SamplingConfig{Strategy: SkipTime, TargetFPS: 0.5, MotionThreshold: defaultMotionThreshold, Adaptive: true, MinFPS: 0.5}, ""},
+		{"nth without frames", models.ProtocolProperties{FrameSkip: "nth"}, SamplingConfig{}, "'SkipFrames' must be a positive number"},
+		{"time without fps", models.ProtocolProperties{FrameSkip: "time"}, SamplingConfig{}, "'InferFPS' must be set"},
+		{"adaptive without fps", models.ProtocolProperties{AdaptiveFPS: "true"}, SamplingConfig{}, "'InferFPS' must be set with 'AdaptiveFPS'"},
+		{"min above target", models.ProtocolProperties{InferFPS: "2", AdaptiveFPS: "true", MinInferFPS: "4"}, SamplingConfig{}, "must not be above"},
+		{"bad strategy", models.ProtocolProperties{FrameSkip: "random"}, SamplingConfig{}, "invalid 'FrameSkip' random"},
+		{"bad fps", models.ProtocolProperties{InferFPS: "-1"}, SamplingConfig{}, "'InferFPS' must be a positive number"},
+		{"bad threshold", models.ProtocolProperties{MotionThreshold: "2"}, SamplingConfig{}, "between 0 and 1"},
+		{"motion gate", models.ProtocolProperties{FrameSkip: "motion", MotionMethod: "MOG2", MotionROI: "0.25,0.25,0.5,0.5", MotionKeepAlive: "30s"},
+			SamplingConfig{Strategy: SkipMotion, MotionThreshold: defaultMotionThreshold, MotionMethod: MotionMOG2, MotionROI: Region{X: 0.25, Y: 0.25, Width: 0.5, Height: 0.5}, MotionKeepAlive: 30 * time.Second}, ""},
+		{"bad method", models.ProtocolProperties{MotionMethod: "flow"}, SamplingConfig{}, "invalid 'MotionMethod' flow"},
+		{"bad roi", models.ProtocolProperties{MotionROI: "0,0,1"}, SamplingConfig{}, "invalid 'MotionROI'"},
+		{"bad keep-alive", models.ProtocolProperties{MotionKeepAlive: "often"}, SamplingConfig{}, "'MotionKeepAlive' must be a positive duration"},
+		{"hold-off", models.ProtocolProperties{FrameSkip: "motion", MotionHoldOff: "5s"}, SamplingConfig{Strategy: SkipMotion, MotionThreshold: defaultMotionThreshold, MotionHoldOff: 5 * time.Second}, ""},
+		{"bad hold-off", models.ProtocolProperties{MotionHoldOff: "0s"}, SamplingConfig{}, "'MotionHoldOff' must be a positive duration"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := parseSamplingConfig(test.protocol)
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, withMotionDefaults(test.expected), config)
+		})
+	}
+}
+
+func TestFrameSampler(t *testing.T) {
+	img := gocv.NewMatWithSize(120, 160, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	start := time.Now()
+
+	t.Run("disabled", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipNone})
+		defer sampler.Close()
+		assert.False(t, sampler.Enabled())
+		assert.True(t, sampler.Sample(img, start, true))
+		assert.True(t, sampler.Sample(img, start, true))
+	})
+
+	t.Run("nth", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipNth, Nth: 3})
+		defer sampler.Close()
+		var sampled []bool
+		for i := 0; i < 7; i++ {
+			sampled = append(sampled, sampler.Sample(img, start.Add(time.Duration(i)*time.Second), true))
+		}
+		assert.Equal(t, []bool{true, false, false, true, false, false, true}, sampled)
+	})
+
+	t.Run("time", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipTime, TargetFPS: 5})
+		defer sampler.Close()
+		var count int
+		// one second of a 25 fps source
+		for i := 0; i < 25; i++ {
+			if sampler.Sample(img, start.Add(time.Duration(i)*40*time.Millisecond), true) {
+				count++
+			}
+		}
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("motion", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipMotion, MotionThreshold: 0.1})
+		defer sampler.Close()
+		still := gocv.NewMatWithSize(120, 160, gocv.MatTypeCV8UC3)
+		defer still.Close()
+		moved := gocv.NewMatWithSize(120, 160, gocv.MatTypeCV8UC3)
+		defer moved.Close()
+		moved.SetTo(gocv.NewScalar(200, 200, 200, 0))
+
+		assert.True(t, sampler.Sample(still, start, true), "first frame")
+		assert.False(t, sampler.Sample(still, start, true))
+		assert.True(t, sampler.Sample(moved, start, true))
+		assert.True(t, sampler.Moving())
+		assert.False(t, sampler.Sample(moved, start, true))
+		assert.False(t, sampler.Moving())
+
+		// motion is detected while an inference runs
+		assert.False(t, sampler.Sample(still, start, false))
+		assert.True(t, sampler.Moving())
+	})
+
+	t.Run("motion outside the region of interest", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipMotion, MotionThreshold: 0.1, MotionROI: Region{X: 0, Y: 0, Width: 0.5, Height: 1}})
+		defer sampler.Close()
+		still := gocv.NewMatWithSize(120, 160, gocv.MatTypeCV8UC3)
+		defer still.Close()
+		right := halfLitFrame(t, false)
+		defer right.Close()
+		left := halfLitFrame(t, true)
+		defer left.Close()
+
+		assert.True(t, sampler.Sample(still, start, true))
+		assert.False(t, sampler.Sample(right, start, true))
+		assert.True(t, sampler.Sample(left, start, true))
+	})
+
+	t.Run("keep-alive", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipMotion, MotionThreshold: 0.1, MotionKeepAlive: 10 * time.Second})
+		defer sampler.Close()
+		var sampled []bool
+		for i := 0; i < 25; i++ {
+			if sampler.Sample(img, start.Add(time.Duration(i)*time.Second), true) {
+				sampled = append(sampled, true)
+			}
+		}
+		// the first frame, then one every 10 seconds
+		assert.Len(t, sampled, 3)
+		assert.False(t, sampler.Moving())
+	})
+
+	t.Run("hold-off", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipMotion, MotionThreshold: 0.1, MotionHoldOff: time.Second})
+		defer sampler.Close()
+		left := halfLitFrame(t, true)
+		defer left.Close()
+		right := halfLitFrame(t, false)
+		defer right.Close()
+
+		// a change is reported once it lasted the hold-off, a flicker is not
+		now := start
+		sampler.Sample(left, now, true)
+		assert.False(t, sampler.Motion(now))
+		sampler.Sample(left, now.Add(500*time.Millisecond), true)
+		assert.False(t, sampler.Motion(now.Add(500*time.Millisecond)))
+		sampler.Sample(right, now.Add(time.Second), true)
+		assert.True(t, sampler.Moving())
+		assert.False(t, sampler.Motion(now.Add(time.Second)))
+		sampler.Sample(left, now.Add(1500*time.Millisecond), true)
+		assert.True(t, sampler.Motion(now.Add(2*time.Second)))
+		sampler.Sample(left, now.Add(2500*time.Millisecond), true)
+		assert.False(t, sampler.Moving())
+		assert.True(t, sampler.Motion(now.Add(3*time.Second)))
+		assert.False(t, sampler.Motion(now.Add(4*time.Second)))
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		sampler := newFrameSampler(SamplingConfig{Strategy: SkipTime, TargetFPS: 10, Adaptive: true, MinFPS: 2})
+		defer sampler.Close()
+		now := start
+		observe := func(latency time.Duration, times int) {
+			for i := 0; i < times; i++ {
+				now = now.Add(adaptInterval)
+				sampler.Observe(latency, now)
+			}
+		}
+
+		observe(20*time.Millisecond, 3)
+		assert.Equal(t, 10.0, sampler.Rate())
+
+		// OVMS slows down, the rate goes down to its minimum
+		observe(200*time.Millisecond, 20)
+		assert.Equal(t, 2.0, sampler.Rate())
+
+		// and recovers
+		observe(20*time.Millisecond, 40)
+		assert.Equal(t, 10.0, sampler.Rate())
+
+		// the rate changes at most once per interval
+		sampler.Observe(time.Second, now)
+		assert.False(t, sampler.Observe(time.Second, now.Add(time.Millisecond)))
+	})
+}
+
+func TestProcessMjpegStreamSampling(t *testing.T) {
+	deviceName := "sampled-camera"
+
+	tests := []struct {
+		name     string
+		protocol map[string]string
+		inferred int64
+	}{
+		{"every frame", nil, 3},
+		{"every other frame", map[string]string{FrameSkip: SkipNth, SkipFrames: "2"}, 2},
+		{"one frame per second", map[string]string{InferFPS: "1"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDriver(t)
+			server := startFakeServer(t, d, deviceName)
+			d.frameSourceFactory = func(uri string) (FrameSource, error) {
+				source, err := ovmstest.NewReplaySource(uri, false)
+				return &pacedSource{FrameSource: source, interval: 100 * time.Millisecond}, err
+			}
+			d.ovmsCh[deviceName] = make(chan OVMSResult, 3)
+			protocols := testProtocols()
+			for name, value := range test.protocol {
+				protocols[Protocol][name] = value
+			}
+
+			err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+			assert.ErrorContains(t, err, "failed to read image")
+			assert.Equal(t, test.inferred, server.InferCount())
+		})
+	}
+}
+
+// frame source of 'count' identical black frames
+type stillSource struct {
+	count int
+}
+
+func (s *stillSource) Read(img *gocv.Mat) bool {
+	if s.count == 0 {
+		return false
+	}
+	s.count--
+	*img = gocv.NewMatWithSize(120, 160, gocv.MatTypeCV8UC3)
+	return true
+}
+
+func (s *stillSource) Close() error {
+	return nil
+}
+
+func TestProcessMjpegStreamMotion(t *testing.T) {
+	deviceName := "still-camera"
+	d := newTestDriver(t)
+	server := startFakeServer(t, d, deviceName)
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return &stillSource{count: 5}, nil
+	}
+	protocols := testProtocols()
+	protocols[Protocol][FrameSkip] = SkipMotion
+
+	err := d.processMjpegStream(deviceName, d.backends[deviceName], protocols, d.policy, make(chan struct{}))
+	assert.ErrorContains(t, err, "failed to read image")
+	// only the first frame of the static scene is inferred
+	assert.EqualValues(t, 1, server.InferCount())
+	moving, ok := d.motionState(deviceName)
+	assert.True(t, ok)
+	assert.False(t, moving)
+}
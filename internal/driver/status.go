@@ -0,0 +1,221 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v4/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/common"
+)
+
+// weight of the last interval in the inference rate of a device
+const fpsWeight = 0.2
+
+// DeviceStatus is the state of the capture and inference pipeline of a device
+type DeviceStatus struct {
+	Device            string    `json:"device"`
+	State             string    `json:"state"`
+	Model             string    `json:"model,omitempty"`
+	LastFrame         time.Time `json:"last_frame,omitzero"`
+	LastInference     time.Time `json:"last_inference,omitzero"`
+	FPS               float64   `json:"fps"`
+	ReconnectAttempts int       `json:"reconnect_attempts"`
+	LastError         string    `json:"last_error,omitempty"`
+	ModelReady        bool      `json:"model_ready"`
+	FramesRead        int64     `json:"frames_read"`
+	FramesDropped     int64     `json:"frames_dropped"`
+	FramesInferred    int64     `json:"frames_inferred"`
+}
+
+// deviceHealth is updated by the capture worker of a device
+type deviceHealth struct {
+	mu                sync.Mutex
+	lastFrame         time.Time
+	lastInference     time.Time
+	fps               float64
+	reconnectAttempts int
+	lastError         string
+	model             string
+	modelReady        bool
+}
+
+// get the health of a device, created on first use
+func (d *Driver) deviceHealth(deviceName string) *deviceHealth {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	health, ok := d.health[deviceName]
+	if !ok {
+		health = &deviceHealth{}
+		d.health[deviceName] = health
+	}
+	return health
+}
+
+func (h *deviceHealth) frameRead(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastFrame = now
+}
+
+// count an inference, the rate is a moving average of the intervals between inferences.
+// The device recovered from its last error.
+func (h *deviceHealth) inferred(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = ""
+	if !h.lastInference.IsZero() {
+		if interval := now.Sub(h.lastInference).Seconds(); interval > 0 {
+			if h.fps == 0 {
+				h.fps = 1 / interval
+			} else {
+				h.fps += fpsWeight * (1/interval - h.fps)
+			}
+		}
+	}
+	h.lastInference = now
+}
+
+func (h *deviceHealth) failed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = err.Error()
+}
+
+func (h *deviceHealth) reconnecting(attempt int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reconnectAttempts = attempt
+}
+
+func (h *deviceHealth) setModelReady(model string, ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.model = model
+	h.modelReady = ready
+}
+
+// fill the status from the health, the rate decays while no inference completes
+func (h *deviceHealth) fill(status *DeviceStatus, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status.LastFrame = h.lastFrame
+	status.LastInference = h.lastInference
+	status.FPS = h.fps
+	if since := now.Sub(h.lastInference).Seconds(); h.fps > 0 && since*h.fps > 1 {
+		status.FPS = 1 / since
+	}
+	status.ReconnectAttempts = h.reconnectAttempts
+	status.LastError = h.lastError
+	status.Model = h.model
+	status.ModelReady = h.modelReady
+}
+
+// DeviceStatus gets the status of a device, false when the device has no capture
+func (d *Driver) DeviceStatus(deviceName string) (DeviceStatus, bool) {
+	d.stateMu.RLock()
+	state, ok := d.captureStates[deviceName]
+	health := d.health[deviceName]
+	stats := d.stats[deviceName]
+	d.stateMu.RUnlock()
+	if !ok {
+		return DeviceStatus{}, false
+	}
+
+	status := DeviceStatus{Device: deviceName, State: state}
+	if health != nil {
+		health.fill(&status, time.Now())
+	}
+	if stats != nil {
+		status.FramesRead = stats.Read.Load()
+		status.FramesDropped = stats.Dropped.Load()
+		status.FramesInferred = stats.Inferred.Load()
+	}
+	return status, true
+}
+
+// get the status of every device with a capture, sorted by device name
+func (d *Driver) deviceStatuses() []DeviceStatus {
+	d.stateMu.RLock()
+	deviceNames := make([]string, 0, len(d.captureStates))
+	for deviceName := range d.captureStates {
+		deviceNames = append(deviceNames, deviceName)
+	}
+	d.stateMu.RUnlock()
+	sort.Strings(deviceNames)
+
+	statuses := make([]DeviceStatus, 0, len(deviceNames))
+	for _, deviceName := range deviceNames {
+		if status, ok := d.DeviceStatus(deviceName); ok {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// push the status of a device as an async reading of the status resource
+func (d *Driver) pushStatus(deviceName string) {
+	status, ok := d.DeviceStatus(deviceName)
+	if !ok || d.asyncCh == nil {
+		return
+	}
+	jsonstr, err := json.Marshal(status)
+	if err != nil {
+		d.lc.Errorf("Failed to encode status of device %s: %v", deviceName, err)
+		return
+	}
+	cv, err := sdkModel.NewCommandValue(StatusResource, common.ValueTypeString, string(jsonstr))
+	if err != nil {
+		d.lc.Errorf("Failed to create status reading of device %s: %v", deviceName, err)
+		return
+	}
+	d.pushReading(deviceName, StatusResource, cv)
+}
+
+// push an async reading without blocking the capture, the reading is dropped when the channel is full
+func (d *Driver) pushReading(deviceName string, resource string, cv *sdkModel.CommandValue) {
+	select {
+	case d.asyncCh <- &sdkModel.AsyncValues{DeviceName: deviceName, SourceName: resource, CommandValues: []*sdkModel.CommandValue{cv}}:
+	default:
+		d.lc.Errorf("Async values channel is full, drop %s reading of device %s", resource, deviceName)
+	}
+}
+
+// StatusHandler serves the status of every device at /status, and of a single device at /status/{device}
+func (d *Driver) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body any
+		if deviceName := r.PathValue("device"); deviceName != "" {
+			status, ok := d.DeviceStatus(deviceName)
+			if !ok {
+				http.Error(w, "unknown device "+deviceName, http.StatusNotFound)
+				return
+			}
+			if !d.live.Authorized(r, deviceName) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			body = status
+		} else {
+			// the devices the client may access
+			statuses := d.deviceStatuses()
+			body = slices.DeleteFunc(statuses, func(status DeviceStatus) bool { return !d.live.Authorized(r, status.Device) })
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			d.lc.Errorf("Failed to write device status: %v", err)
+		}
+	})
+}
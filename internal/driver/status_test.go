@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdkModel "github.com/edgexfoundry/device-sdk-go/v4/pkg/models"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/common"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceHealth(t *testing.T) {
+	start := time.Now()
+	health := &deviceHealth{}
+	for i := 0; i <= 10; i++ {
+		health.inferred(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	health.failed(errors.New("deadline exceeded"))
+
+	var status DeviceStatus
+	health.fill(&status, start.Add(time.Second))
+	assert.InDelta(t, 10, status.FPS, 0.01)
+	assert.Equal(t, start.Add(time.Second), status.LastInference)
+	assert.Equal(t, "deadline exceeded", status.LastError)
+
+	// the rate decays while no inference completes
+	health.fill(&status, start.Add(3*time.Second))
+	assert.InDelta(t, 0.5, status.FPS, 0.01)
+
+	// the error is cleared by the next inference
+	health.inferred(start.Add(4 * time.Second))
+	health.fill(&status, start.Add(4*time.Second))
+	assert.Empty(t, status.LastError)
+}
+
+func TestReadStatus(t *testing.T) {
+	d := newTestDriver(t)
+	asyncCh := make(chan *sdkModel.AsyncValues, 16)
+	d.asyncCh = asyncCh
+	deviceName := "status-camera"
+	startFakeServer(t, d, deviceName)
+	statusRequest := []sdkModel.CommandRequest{{DeviceResourceName: StatusResource, Type: common.ValueTypeString}}
+
+	_, err := d.HandleReadCommands(deviceName, testProtocols(), statusRequest)
+	assert.ErrorContains(t, err, "has no capture")
+
+	require.NoError(t, d.AddDevice(deviceName, testProtocols(), models.Unlocked))
+	var status DeviceStatus
+	require.Eventually(t, func() bool {
+		res, err := d.HandleReadCommands(deviceName, testProtocols(), statusRequest)
+		if err != nil || len(res) != 1 {
+			return false
+		}
+		value, err := res[0].StringValue()
+		// the rate of an inference is updated after its frame is counted
+		return err == nil && json.Unmarshal([]byte(value), &status) == nil && status.FramesInferred > 1 && status.FPS > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, deviceName, status.Device)
+	assert.Equal(t, CaptureRunning, status.State)
+	assert.Equal(t, testModel, status.Model)
+	assert.True(t, status.ModelReady)
+	assert.False(t, status.LastFrame.IsZero())
+	assert.False(t, status.LastInference.IsZero())
+	assert.Positive(t, status.FPS)
+	assert.Zero(t, status.ReconnectAttempts)
+	assert.Empty(t, status.LastError)
+
+	// the transitions to connecting and running are pushed
+	var states []string
+	for len(asyncCh) > 0 {
+		values := <-asyncCh
+		require.Equal(t, StatusResource, values.SourceName)
+		value, err := values.CommandValues[0].StringValue()
+		require.NoError(t, err)
+		var pushed DeviceStatus
+		require.NoError(t, json.Unmarshal([]byte(value), &pushed))
+		states = append(states, pushed.State)
+	}
+	assert.Equal(t, []string{CaptureConnecting, CaptureRunning}, states)
+}
+
+func TestStatusHandler(t *testing.T) {
+	d := newTestDriver(t)
+	d.setCaptureState("camera-b", CaptureRunning)
+	d.setCaptureState("camera-a", CaptureReconnecting)
+	d.deviceHealth("camera-a").reconnecting(2)
+	mux := http.NewServeMux()
+	mux.Handle("GET /status", d.StatusHandler())
+	mux.Handle("GET /status/{device}", d.StatusHandler())
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var statuses []DeviceStatus
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "camera-a", statuses[0].Device)
+	assert.Equal(t, CaptureReconnecting, statuses[0].State)
+	assert.Equal(t, 2, statuses[0].ReconnectAttempts)
+	assert.Equal(t, "camera-b", statuses[1].Device)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status/camera-b", nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var status DeviceStatus
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, CaptureRunning, status.State)
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status/camera-c", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
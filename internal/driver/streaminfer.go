@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// default number of requests in flight on the inference stream of a device
+const defaultStreamInflight = 4
+
+// streamBackend is a backend able to open a KServe v2 gRPC inference stream
+type streamBackend interface {
+	InferenceBackend
+	ModelStreamInfer(ctx context.Context) (grpc_client.GRPCInferenceService_ModelStreamInferClient, error)
+}
+
+type streamInferResult struct {
+	response *grpc_client.ModelInferResponse
+	err      error
+}
+
+// streamInferClient sends the inference requests of one device over a long-lived
+// ModelStreamInfer stream, matching responses to requests by id. The stream is
+// reopened on the next request after an error. Other calls stay unary.
+type streamInferClient struct {
+	streamBackend
+
+	lc       logger.LoggingClient
+	name     string
+	inflight chan struct{}
+	nextId   atomic.Uint64
+
+	mu      sync.Mutex
+	stream  grpc_client.GRPCInferenceService_ModelStreamInferClient
+	cancel  context.CancelFunc
+	pending map[string]chan streamInferResult
+	closed  bool
+
+	// Send must not be called concurrently on a gRPC stream
+	sendMu sync.Mutex
+}
+
+func newStreamInferClient(lc logger.LoggingClient, deviceName string, backend streamBackend, inflight int) *streamInferClient {
+	if inflight <= 0 {
+		inflight = defaultStreamInflight
+	}
+	return &streamInferClient{
+		streamBackend: backend,
+		lc:            lc,
+		name:          deviceName,
+		inflight:      make(chan struct{}, inflight),
+		pending:       make(map[string]chan streamInferResult),
+	}
+}
+
+// Inflight returns the maximum number of requests in flight on the stream
+func (s *streamInferClient) Inflight() int {
+	return cap(s.inflight)
+}
+
+// Close tears down the stream and fails requests still waiting for a response
+func (s *streamInferClient) Close() {
+	s.mu.Lock()
+	s.closed = true
+	stream := s.stream
+	s.mu.Unlock()
+	if stream != nil {
+		s.reset(stream, status.Error(codes.Canceled, "inference stream closed"))
+	}
+}
+
+// open the stream if there is none, must be called with s.mu held
+func (s *streamInferClient) getStream() (grpc_client.GRPCInferenceService_ModelStreamInferClient, error) {
+	if s.closed {
+		return nil, status.Error(codes.Canceled, "inference stream closed")
+	}
+	if s.stream != nil {
+		return s.stream, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := s.streamBackend.ModelStreamInfer(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s.stream = stream
+	s.cancel = cancel
+	go s.receive(stream)
+
+	s.lc.Infof("Inference stream opened for device %s", s.name)
+	return stream, nil
+}
+
+// drop a broken stream and fail all of its pending requests, the next request reconnects
+func (s *streamInferClient) reset(stream grpc_client.GRPCInferenceService_ModelStreamInferClient, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stream != stream {
+		return
+	}
+	s.cancel()
+	s.stream = nil
+	for id, ch := range s.pending {
+		ch <- streamInferResult{err: err}
+		delete(s.pending, id)
+	}
+	if !s.closed {
+		s.lc.Warnf("Inference stream of device %s broken, reconnecting on next frame: %v", s.name, err)
+	}
+}
+
+// fail the requests waiting for a response, for an error the server did not tie to a request
+func (s *streamInferClient) failPending(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, ch := range s.pending {
+		ch <- streamInferResult{err: err}
+		delete(s.pending, id)
+	}
+	s.lc.Warnf("Inference stream of device %s failed all pending requests: %v", s.name, err)
+}
+
+// dispatch responses to the waiting requests
+func (s *streamInferClient) receive(stream grpc_client.GRPCInferenceService_ModelStreamInferClient) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			s.reset(stream, err)
+			return
+		}
+
+		id := resp.GetInferResponse().GetId()
+		if id == "" && resp.GetErrorMessage() != "" {
+			s.failPending(fmt.Errorf("stream inference failed: %s", resp.GetErrorMessage()))
+			continue
+		}
+		s.mu.Lock()
+		ch, ok := s.pending[id]
+		delete(s.pending, id)
+		s.mu.Unlock()
+		if !ok {
+			s.lc.Debugf("Dropping stream response with unknown id '%s' for device %s", id, s.name)
+			continue
+		}
+
+		if resp.GetErrorMessage() != "" {
+			ch <- streamInferResult{err: fmt.Errorf("stream inference failed: %s", resp.GetErrorMessage())}
+		} else {
+			ch <- streamInferResult{response: resp.GetInferResponse()}
+		}
+	}
+}
+
+// ModelInfer sends the request on the stream and waits for its response
+func (s *streamInferClient) ModelInfer(ctx context.Context, request *InferRequest) (*InferResponse, error) {
+	in, err := toGRPCInferRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.inflight <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-s.inflight }()
+
+	in.Id = strconv.FormatUint(s.nextId.Add(1), 10)
+	ch := make(chan streamInferResult, 1)
+
+	s.mu.Lock()
+	stream, err := s.getStream()
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.pending[in.Id] = ch
+	s.mu.Unlock()
+
+	s.sendMu.Lock()
+	err = stream.Send(in)
+	s.sendMu.Unlock()
+	if err != nil {
+		s.reset(stream, err)
+		return nil, err
+	}
+
+	select {
+	case result := <-ch:
+		if result.err != nil {
+			return nil, result.err
+		}
+		return fromGRPCInferResponse(result.response)
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.pending, in.Id)
+		s.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
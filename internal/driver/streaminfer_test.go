@@ -0,0 +1,242 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/clients/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// streamServer serves inference streams with a scripted handler
+type streamServer struct {
+	grpc_client.UnimplementedGRPCInferenceServiceServer
+
+	handle  func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error
+	streams atomic.Int32
+}
+
+func (s *streamServer) ModelStreamInfer(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+	s.streams.Add(1)
+	return s.handle(stream)
+}
+
+// stream client of a scripted server over an in-memory connection
+func newTestStreamClient(t *testing.T, inflight int, handle func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error) (*streamInferClient, *streamServer) {
+	server := &streamServer{handle: handle}
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	grpc_client.RegisterGRPCInferenceServiceServer(grpcServer, server)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	client := newStreamInferClient(logger.NewMockClient(), "stream-camera", newGRPCBackend(grpc_client.NewGRPCInferenceServiceClient(conn)), inflight)
+	t.Cleanup(client.Close)
+	return client, server
+}
+
+// request of a frame, told apart by its model version
+func streamRequest(frame string) *InferRequest {
+	return &InferRequest{ModelName: "ssd", ModelVersion: frame, Inputs: bytesInput("image", frame)}
+}
+
+// answer a request with its model version
+func streamResponse(in *grpc_client.ModelInferRequest) *grpc_client.ModelStreamInferResponse {
+	return &grpc_client.ModelStreamInferResponse{InferResponse: &grpc_client.ModelInferResponse{Id: in.GetId(), ModelVersion: in.GetModelVersion()}}
+}
+
+// infer frames concurrently, the errors are returned by frame
+func inferFrames(client *streamInferClient, timeout time.Duration, frames ...string) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error)
+	for _, frame := range frames {
+		wg.Add(1)
+		go func(frame string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			response, err := client.ModelInfer(ctx, streamRequest(frame))
+			if err == nil && response.ModelVersion != frame {
+				err = status.Errorf(codes.Internal, "frame %s got the response of frame %s", frame, response.ModelVersion)
+			}
+			mu.Lock()
+			errs[frame] = err
+			mu.Unlock()
+		}(frame)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestStreamInferCorrelation(t *testing.T) {
+	// the responses of a batch of requests are sent in reverse order
+	client, server := newTestStreamClient(t, 3, func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+		for {
+			var requests []*grpc_client.ModelInferRequest
+			for len(requests) < 3 {
+				in, err := stream.Recv()
+				if err != nil {
+					return err
+				}
+				requests = append(requests, in)
+			}
+			for i := len(requests) - 1; i >= 0; i-- {
+				if err := stream.Send(streamResponse(requests[i])); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	for round := 0; round < 2; round++ {
+		errs := inferFrames(client, 5*time.Second, "a", "b", "c")
+		assert.Equal(t, map[string]error{"a": nil, "b": nil, "c": nil}, errs)
+	}
+	assert.EqualValues(t, 1, server.streams.Load())
+	client.mu.Lock()
+	assert.Empty(t, client.pending)
+	client.mu.Unlock()
+}
+
+func TestStreamInferErrors(t *testing.T) {
+	// frame "a" fails on its own, frame "b" with an error the server does not tie to a request
+	client, _ := newTestStreamClient(t, 3, func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			resp := streamResponse(in)
+			switch in.GetModelVersion() {
+			case "a":
+				resp.ErrorMessage = "invalid input"
+			case "b":
+				resp = &grpc_client.ModelStreamInferResponse{ErrorMessage: "out of memory"}
+			case "c":
+				// unanswered
+				continue
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	})
+
+	errs := inferFrames(client, 5*time.Second, "a")
+	assert.ErrorContains(t, errs["a"], "invalid input")
+	errs = inferFrames(client, 5*time.Second, "ok")
+	assert.NoError(t, errs["ok"])
+
+	// the error fails every pending request at once, without waiting for their timeout
+	start := time.Now()
+	var wg sync.WaitGroup
+	var pendingErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pendingErr = inferFrames(client, 5*time.Second, "c")["c"]
+	}()
+	require.Eventually(t, func() bool {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		return len(client.pending) == 1
+	}, time.Second, time.Millisecond)
+	errs = inferFrames(client, 5*time.Second, "b")
+	wg.Wait()
+	assert.ErrorContains(t, errs["b"], "out of memory")
+	assert.ErrorContains(t, pendingErr, "out of memory")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// the stream is kept
+	errs = inferFrames(client, 5*time.Second, "ok")
+	assert.NoError(t, errs["ok"])
+}
+
+func TestStreamInferReconnect(t *testing.T) {
+	// the first stream breaks on its second request
+	var breaks atomic.Bool
+	breaks.Store(true)
+	client, server := newTestStreamClient(t, 2, func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+		for count := 1; ; count++ {
+			in, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if count == 2 && breaks.Swap(false) {
+				return status.Error(codes.Unavailable, "server restarting")
+			}
+			if err := stream.Send(streamResponse(in)); err != nil {
+				return err
+			}
+		}
+	})
+
+	errs := inferFrames(client, 5*time.Second, "a")
+	assert.NoError(t, errs["a"])
+	errs = inferFrames(client, 5*time.Second, "b")
+	assert.Equal(t, codes.Unavailable, status.Code(errs["b"]))
+
+	// the next request reopens the stream
+	errs = inferFrames(client, 5*time.Second, "c", "d")
+	assert.Equal(t, map[string]error{"c": nil, "d": nil}, errs)
+	assert.EqualValues(t, 2, server.streams.Load())
+}
+
+func TestStreamInferTimeout(t *testing.T) {
+	// only the frames of the fast model are answered
+	client, _ := newTestStreamClient(t, 1, func(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if in.GetModelVersion() == "slow" {
+				continue
+			}
+			if err := stream.Send(streamResponse(in)); err != nil {
+				return err
+			}
+		}
+	})
+
+	errs := inferFrames(client, 50*time.Millisecond, "slow")
+	assert.ErrorIs(t, errs["slow"], context.DeadlineExceeded)
+	client.mu.Lock()
+	assert.Empty(t, client.pending)
+	client.mu.Unlock()
+
+	// the request in flight is released, and the stream kept
+	errs = inferFrames(client, 5*time.Second, "fast")
+	assert.NoError(t, errs["fast"])
+
+	// closing fails the requests and the next ones
+	client.Close()
+	errs = inferFrames(client, 5*time.Second, "closed")
+	assert.Equal(t, codes.Canceled, status.Code(errs["closed"]))
+}
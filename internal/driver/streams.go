@@ -0,0 +1,363 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cast"
+	"gocv.io/x/gocv"
+)
+
+// JPEG quality of the live streams, the OpenCV default
+const defaultStreamQuality = 95
+
+// multipart boundary of the MJPEG streams
+const mjpegBoundary = "frame"
+
+// streamVariants are the live streams of a device
+var streamVariants = []string{StreamRaw, StreamAnnotated, StreamEvents}
+
+// StreamConfig is the JPEG encoding of the live streams and snapshots of a device
+type StreamConfig struct {
+	Quality int
+	// frames are downscaled to fit, 0 for no limit
+	MaxWidth  int
+	MaxHeight int
+	// HLS output of the annotated frames
+	HLS HLSConfig
+	// RTSP re-streaming of the annotated frames
+	RTSP bool
+}
+
+// parse the stream properties of a device
+func parseStreamConfig(protocol models.ProtocolProperties) (StreamConfig, error) {
+	config := StreamConfig{
+		Quality: defaultStreamQuality,
+		HLS: HLSConfig{
+			SegmentDuration: defaultHLSSegmentDuration,
+			PlaylistSize:    defaultHLSPlaylistSize,
+			FrameRate:       defaultHLSFrameRate,
+		},
+	}
+	if str, _ := cast.ToStringE(protocol[StreamQuality]); str != "" {
+		quality, err := cast.ToIntE(str)
+		if err != nil || quality < 1 || quality > 100 {
+			return config, fmt.Errorf("'%s' must be a JPEG quality between 1 and 100", StreamQuality)
+		}
+		config.Quality = quality
+	}
+	for name, target := range map[string]*int{StreamMaxWidth: &config.MaxWidth, StreamMaxHeight: &config.MaxHeight} {
+		if str, _ := cast.ToStringE(protocol[name]); str != "" {
+			size, err := cast.ToIntE(str)
+			if err != nil || size < 1 {
+				return config, fmt.Errorf("'%s' must be a positive number of pixels", name)
+			}
+			*target = size
+		}
+	}
+
+	if err := overrideBool(&config.HLS.Enabled, HLS, protocol[HLS]); err != nil {
+		return config, err
+	}
+	if err := overrideBool(&config.RTSP, RTSP, protocol[RTSP]); err != nil {
+		return config, err
+	}
+	if err := overrideDuration(&config.HLS.SegmentDuration, HLSSegmentDuration, protocol[HLSSegmentDuration]); err != nil {
+		return config, err
+	}
+	if str, _ := cast.ToStringE(protocol[HLSPlaylistSize]); str != "" {
+		size, err := cast.ToIntE(str)
+		if err != nil || size < 1 {
+			return config, fmt.Errorf("'%s' must be a positive number of segments", HLSPlaylistSize)
+		}
+		config.HLS.PlaylistSize = size
+	}
+	validRate := func(v float64) bool { return v > 0 && v <= 120 }
+	if err := overrideFloat(&config.HLS.FrameRate, HLSFrameRate, protocol[HLSFrameRate], validRate); err != nil {
+		return config, err
+	}
+	config.HLS.RecordDir, _ = cast.ToStringE(protocol[HLSRecordDir])
+	if config.HLS.RecordDir != "" && !filepath.IsAbs(config.HLS.RecordDir) {
+		return config, fmt.Errorf("'%s' must be an absolute path", HLSRecordDir)
+	}
+	return config, nil
+}
+
+// fit a frame size into the max size, keeping its aspect ratio
+func (c StreamConfig) fit(width int, height int) (int, int) {
+	scale := 1.0
+	if c.MaxWidth > 0 && width > c.MaxWidth {
+		scale = float64(c.MaxWidth) / float64(width)
+	}
+	if c.MaxHeight > 0 && height > c.MaxHeight {
+		scale = min(scale, float64(c.MaxHeight)/float64(height))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// mjpegStream pushes JPEG frames to its clients as multipart/x-mixed-replace.
+// A client keeps only the newest frame not sent yet, so a slow client skips frames.
+type mjpegStream struct {
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+	// gauge of the connected clients
+	gauge prometheus.Gauge
+}
+
+func newMjpegStream(gauge prometheus.Gauge) *mjpegStream {
+	return &mjpegStream{clients: make(map[chan []byte]struct{}), gauge: gauge}
+}
+
+// Clients is the number of connected clients
+func (s *mjpegStream) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Update sends a JPEG frame to the clients, the frame is not retained
+func (s *mjpegStream) Update(jpeg []byte) {
+	header := fmt.Sprintf("--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(jpeg))
+	part := make([]byte, 0, len(header)+len(jpeg)+2)
+	part = append(part, header...)
+	part = append(part, jpeg...)
+	part = append(part, "\r\n"...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		// replace the frame the client has not taken yet
+		select {
+		case <-client:
+		default:
+		}
+		client <- part
+	}
+}
+
+// Close disconnects the clients
+func (s *mjpegStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		close(client)
+		delete(s.clients, client)
+	}
+}
+
+func (s *mjpegStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client := make(chan []byte, 1)
+	s.mu.Lock()
+	s.clients[client] = struct{}{}
+	s.mu.Unlock()
+	s.gauge.Inc()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, client)
+		s.mu.Unlock()
+		s.gauge.Dec()
+	}()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case part, ok := <-client:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(part); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// deviceStreams are the live streams of a device and its latest frames, served as snapshots
+type deviceStreams struct {
+	config  StreamConfig
+	streams map[string]*mjpegStream
+	// HLS output and RTSP stream of the annotated frames, nil when disabled
+	hls  *hlsStream
+	rtsp *rtspStream
+	// latest frame of every variant, downscaled
+	mu     sync.Mutex
+	latest map[string]*gocv.Mat
+}
+
+func newDeviceStreams(config StreamConfig, clients *prometheus.GaugeVec, deviceName string) *deviceStreams {
+	s := &deviceStreams{
+		config:  config,
+		streams: make(map[string]*mjpegStream),
+		latest:  make(map[string]*gocv.Mat),
+	}
+	for _, variant := range streamVariants {
+		s.streams[variant] = newMjpegStream(clients.WithLabelValues(deviceName, variant))
+	}
+	return s
+}
+
+// Write a frame to a variant, it is encoded only when the stream has clients.
+// The encoding time is observed by 'encode'.
+func (s *deviceStreams) Write(variant string, img gocv.Mat, encode prometheus.Observer) error {
+	stream, ok := s.streams[variant]
+	if !ok {
+		return fmt.Errorf("unknown stream variant %s", variant)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest, ok := s.latest[variant]
+	if !ok {
+		mat := gocv.NewMat()
+		latest = &mat
+		s.latest[variant] = latest
+	}
+	width, height := s.config.fit(img.Cols(), img.Rows())
+	if width != img.Cols() || height != img.Rows() {
+		if err := gocv.Resize(img, latest, image.Pt(width, height), 0, 0, gocv.InterpolationArea); err != nil {
+			return err
+		}
+	} else if err := img.CopyTo(latest); err != nil {
+		return err
+	}
+	if variant == StreamAnnotated && s.hls != nil {
+		if err := s.hls.Write(*latest, time.Now()); err != nil {
+			return fmt.Errorf("failed to write HLS segment: %w", err)
+		}
+	}
+	if variant == StreamAnnotated && s.rtsp != nil && s.rtsp.Readers() > 0 {
+		if err := s.rtsp.Write(*latest, s.encode, time.Now()); err != nil {
+			return fmt.Errorf("failed to write RTSP stream: %w", err)
+		}
+	}
+
+	if stream.Clients() == 0 {
+		return nil
+	}
+	start := time.Now()
+	jpeg, err := s.encode(*latest)
+	if err != nil {
+		return err
+	}
+	observeSince(encode, start)
+	stream.Update(jpeg)
+	return nil
+}
+
+// encode a frame with the quality of the streams
+func (s *deviceStreams) encode(img gocv.Mat) ([]byte, error) {
+	buf, err := gocv.IMEncodeWithParams(gocv.JPEGFileExt, img, []int{gocv.IMWriteJpegQuality, s.config.Quality})
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Close()
+	// the bytes of the buffer are freed on close
+	return append([]byte(nil), buf.GetBytes()...), nil
+}
+
+// Snapshot encodes the latest frame of a variant, nil before the first frame
+func (s *deviceStreams) Snapshot(variant string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest, ok := s.latest[variant]
+	if !ok || latest.Empty() {
+		return nil, nil
+	}
+	return s.encode(*latest)
+}
+
+// Close disconnects the clients and frees the latest frames
+func (s *deviceStreams) Close() {
+	for _, stream := range s.streams {
+		stream.Close()
+	}
+	if s.hls != nil {
+		s.hls.Close()
+	}
+	if s.rtsp != nil {
+		s.rtsp.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for variant, latest := range s.latest {
+		latest.Close()
+		delete(s.latest, variant)
+	}
+}
+
+// ServeHTTP serves the file of the request path: <variant>.mjpeg, snapshot.jpg of the annotated
+// frames by default or of the variant of the 'variant' query parameter, or the HLS playlist and segments
+func (s *deviceStreams) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file := r.PathValue("file")
+	if file == hlsPlaylist || strings.HasSuffix(file, ".ts") {
+		if s.hls == nil {
+			http.NotFound(w, r)
+			return
+		}
+		s.hls.ServeHTTP(w, r)
+		return
+	}
+	if variant, ok := strings.CutSuffix(file, ".mjpeg"); ok {
+		if stream, found := s.streams[variant]; found {
+			stream.ServeHTTP(w, r)
+			return
+		}
+	}
+	if file != "snapshot.jpg" {
+		http.NotFound(w, r)
+		return
+	}
+
+	variant := r.URL.Query().Get("variant")
+	if variant == "" {
+		variant = StreamAnnotated
+	}
+	if _, ok := s.streams[variant]; !ok {
+		http.Error(w, "unknown variant "+variant, http.StatusBadRequest)
+		return
+	}
+	jpeg, err := s.Snapshot(variant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jpeg == nil {
+		http.Error(w, "no frame yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Content-Length", strconv.Itoa(len(jpeg)))
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	_, _ = w.Write(jpeg)
+}
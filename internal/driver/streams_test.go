@@ -0,0 +1,173 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/edgexfoundry/device-ai-openvino-ovms/internal/ovmstest"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gocv.io/x/gocv"
+)
+
+// the size of a JPEG image
+func jpegSize(t *testing.T, data []byte) (int, int) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	return config.Width, config.Height
+}
+
+// read the next frame of an MJPEG stream, by its Content-Length as a player does
+func readMjpegPart(t *testing.T, reader *bufio.Reader) []byte {
+	boundary, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "--"+mjpegBoundary+"\r\n", boundary)
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", header.Get("Content-Type"))
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	require.NoError(t, err)
+	data := make([]byte, length+2)
+	_, err = io.ReadFull(reader, data)
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", string(data[length:]))
+	return data[:length]
+}
+
+func TestParseStreamConfig(t *testing.T) {
+	config, err := parseStreamConfig(models.ProtocolProperties{})
+	require.NoError(t, err)
+	defaultHLS := HLSConfig{SegmentDuration: 2 * time.Second, PlaylistSize: 5, FrameRate: 15}
+	assert.Equal(t, StreamConfig{Quality: defaultStreamQuality, HLS: defaultHLS}, config)
+
+	config, err = parseStreamConfig(models.ProtocolProperties{StreamQuality: "70", StreamMaxWidth: "1280", StreamMaxHeight: "720"})
+	require.NoError(t, err)
+	assert.Equal(t, StreamConfig{Quality: 70, MaxWidth: 1280, MaxHeight: 720, HLS: defaultHLS}, config)
+
+	config, err = parseStreamConfig(models.ProtocolProperties{HLS: "true", HLSSegmentDuration: "4s", HLSPlaylistSize: "3", HLSFrameRate: "25", HLSRecordDir: "/var/lib/ovms"})
+	require.NoError(t, err)
+	assert.Equal(t, HLSConfig{Enabled: true, SegmentDuration: 4 * time.Second, PlaylistSize: 3, FrameRate: 25, RecordDir: "/var/lib/ovms"}, config.HLS)
+
+	for _, protocol := range []models.ProtocolProperties{
+		{StreamQuality: "0"},
+		{StreamQuality: "101"},
+		{StreamMaxWidth: "-1"},
+		{StreamMaxHeight: "wide"},
+		{HLS: "yes please"},
+		{HLSSegmentDuration: "2"},
+		{HLSPlaylistSize: "0"},
+		{HLSFrameRate: "0"},
+		{HLSRecordDir: "recordings"},
+		{RTSP: "maybe"},
+	} {
+		_, err := parseStreamConfig(protocol)
+		assert.Error(t, err, protocol)
+	}
+}
+
+func TestStreamConfigFit(t *testing.T) {
+	config := StreamConfig{MaxWidth: 1280, MaxHeight: 720}
+	width, height := config.fit(1920, 1080)
+	assert.Equal(t, []int{1280, 720}, []int{width, height})
+	width, height = config.fit(1080, 1920)
+	assert.Equal(t, []int{405, 720}, []int{width, height})
+	width, height = config.fit(640, 480)
+	assert.Equal(t, []int{640, 480}, []int{width, height})
+	width, height = StreamConfig{}.fit(1920, 1080)
+	assert.Equal(t, []int{1920, 1080}, []int{width, height})
+}
+
+func TestDeviceStreams(t *testing.T) {
+	d := newTestDriver(t)
+	d.addStream("camera", map[string]models.ProtocolProperties{Protocol: {StreamMaxWidth: "64"}})
+	server := httptest.NewServer(d.live)
+	t.Cleanup(server.Close)
+	streams := d.streams["camera"]
+
+	// no frame yet
+	resp, err := http.Get(server.URL + "/camera/snapshot.jpg")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	stream, err := http.Get(server.URL + "/camera/raw.mjpeg")
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	assert.Equal(t, "multipart/x-mixed-replace;boundary=frame", stream.Header.Get("Content-Type"))
+	require.Eventually(t, func() bool {
+		return streams.streams[StreamRaw].Clients() == 1
+	}, time.Second, time.Millisecond)
+
+	// frames are downscaled to the max width
+	frame := gocv.NewMatWithSize(96, 128, gocv.MatTypeCV8UC3)
+	defer frame.Close()
+	require.NoError(t, d.WriteStream("camera", StreamRaw, frame))
+	data := readMjpegPart(t, bufio.NewReader(stream.Body))
+	width, height := jpegSize(t, data)
+	assert.Equal(t, []int{64, 48}, []int{width, height})
+
+	// the snapshot of a variant is its latest frame
+	resp, err = http.Get(server.URL + "/camera/snapshot.jpg?variant=raw")
+	require.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	width, height = jpegSize(t, data)
+	assert.Equal(t, []int{64, 48}, []int{width, height})
+
+	for path, code := range map[string]int{
+		"/camera/snapshot.jpg?variant=events":  http.StatusServiceUnavailable,
+		"/camera/snapshot.jpg?variant=unknown": http.StatusBadRequest,
+		"/camera/unknown.mjpeg":                http.StatusNotFound,
+		"/unknown/raw.mjpeg":                   http.StatusNotFound,
+	} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, code, resp.StatusCode, path)
+	}
+
+	// the stream of a removed device ends
+	require.NoError(t, d.RemoveDevice("camera", testProtocols()))
+	_, err = io.ReadAll(stream.Body)
+	assert.NoError(t, err)
+}
+
+func TestProcessMjpegStreamVariants(t *testing.T) {
+	d := newTestDriver(t)
+	deviceName := "variants-camera"
+	d.frameSourceFactory = func(uri string) (FrameSource, error) {
+		return ovmstest.NewReplaySource(uri, false)
+	}
+	server := startFakeServer(t, d, deviceName)
+	server.SetDetections(ovmstest.Detection{Label: 3, Confidence: 0.3, XMax: 1, YMax: 1})
+	streams := d.NewStreamClient(deviceName, nil)
+	d.ovmsCh[deviceName] = make(chan OVMSResult, 1)
+
+	err := d.processMjpegStream(deviceName, d.backends[deviceName], testProtocols(), d.policy, make(chan struct{}))
+	assert.Error(t, err)
+
+	// nothing is detected, the raw and annotated streams still get every frame
+	for variant, expected := range map[string]bool{StreamRaw: true, StreamAnnotated: true, StreamEvents: false} {
+		snapshot, err := streams.Snapshot(variant)
+		require.NoError(t, err)
+		assert.Equal(t, expected, snapshot != nil, variant)
+	}
+}
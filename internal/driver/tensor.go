@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Tensor is an output tensor of an inference response with its elements converted to float32
+type Tensor struct {
+	Name     string
+	Datatype string
+	Shape    []int64
+	Data     []float32
+}
+
+// number of elements of a tensor shape
+func shapeElements(shape []int64) (int, error) {
+	count := int64(1)
+	for _, dim := range shape {
+		if dim < 0 {
+			return 0, fmt.Errorf("invalid dimension %d in shape %v", dim, shape)
+		}
+		count *= dim
+	}
+	return int(count), nil
+}
+
+// convert an IEEE 754 half precision float to float32
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exponent := uint32(h>>10) & 0x1f
+	mantissa := uint32(h) & 0x3ff
+
+	switch {
+	case exponent == 0x1f:
+		// infinity or NaN
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	case exponent != 0:
+		return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+	case mantissa == 0:
+		return math.Float32frombits(sign)
+	}
+	// subnormal half, normalized in float32
+	exponent = 127 - 15 + 1
+	for mantissa&0x400 == 0 {
+		mantissa <<= 1
+		exponent--
+	}
+	return math.Float32frombits(sign | exponent<<23 | (mantissa&0x3ff)<<13)
+}
+
+// decode little endian raw tensor data of a datatype to float32
+func decodeRawTensor(datatype string, shape []int64, raw []byte) ([]float32, error) {
+	size, err := datatypeSize(datatype)
+	if err != nil {
+		return nil, err
+	}
+	count, err := shapeElements(shape)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != count*size {
+		return nil, fmt.Errorf("%d bytes of %s data do not match shape %v, expected %d bytes", len(raw), datatype, shape, count*size)
+	}
+
+	data := make([]float32, count)
+	for i := range data {
+		b := raw[i*size : (i+1)*size]
+		switch datatype {
+		case "BOOL", "UINT8":
+			data[i] = float32(b[0])
+		case "INT8":
+			data[i] = float32(int8(b[0]))
+		case "INT16":
+			data[i] = float32(int16(binary.LittleEndian.Uint16(b)))
+		case "UINT16":
+			data[i] = float32(binary.LittleEndian.Uint16(b))
+		case "FP16":
+			data[i] = float16ToFloat32(binary.LittleEndian.Uint16(b))
+		case "INT32":
+			data[i] = float32(int32(binary.LittleEndian.Uint32(b)))
+		case "UINT32":
+			data[i] = float32(binary.LittleEndian.Uint32(b))
+		case "FP32":
+			data[i] = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case "INT64":
+			data[i] = float32(int64(binary.LittleEndian.Uint64(b)))
+		case "UINT64":
+			data[i] = float32(binary.LittleEndian.Uint64(b))
+		case "FP64":
+			data[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+	}
+	return data, nil
+}
+
+// DecodeOutputTensor decodes output 'index' of an inference response
+func DecodeOutputTensor(response *InferResponse, index int) (*Tensor, error) {
+	outputs := response.Outputs
+	if index < 0 || index >= len(outputs) {
+		return nil, fmt.Errorf("output %d not found, response has %d outputs", index, len(outputs))
+	}
+	output := outputs[index]
+	tensor := &Tensor{
+		Name:     output.Name,
+		Datatype: output.Datatype,
+		Shape:    output.Shape,
+	}
+
+	var err error
+	tensor.Data, err = decodeRawTensor(tensor.Datatype, tensor.Shape, output.Data)
+	if err != nil {
+		return nil, fmt.Errorf("output '%s': %v", tensor.Name, err)
+	}
+	return tensor, nil
+}
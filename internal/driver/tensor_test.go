@@ -0,0 +1,114 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFloat16ToFloat32(t *testing.T) {
+	tests := []struct {
+		half     uint16
+		expected float32
+	}{
+		{0x0000, 0},
+		{0x3c00, 1},
+		{0xc000, -2},
+		{0x3555, 0.33325195},
+		{0x7bff, 65504},
+		{0x0001, 5.9604645e-08},
+		{0x7c00, float32(math.Inf(1))},
+		{0xfc00, float32(math.Inf(-1))},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, float16ToFloat32(test.half), "half 0x%04x", test.half)
+	}
+	assert.True(t, math.IsNaN(float64(float16ToFloat32(0x7e00))))
+}
+
+func TestDecodeRawTensor(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name     string
+		datatype string
+		raw      []byte
+		expected []float32
+	}{
+		{"FP32", "FP32", le.AppendUint32(le.AppendUint32(nil, math.Float32bits(0.5)), math.Float32bits(-3)), []float32{0.5, -3}},
+		{"FP16", "FP16", le.AppendUint16(le.AppendUint16(nil, 0x3800), 0xc200), []float32{0.5, -3}},
+		{"INT32", "INT32", le.AppendUint32(le.AppendUint32(nil, 7), uint32(0xfffffffd)), []float32{7, -3}},
+		{"INT64", "INT64", le.AppendUint64(le.AppendUint64(nil, 7), uint64(0xfffffffffffffffd)), []float32{7, -3}},
+		{"UINT8", "UINT8", []byte{7, 255}, []float32{7, 255}},
+		{"FP64", "FP64", le.AppendUint64(le.AppendUint64(nil, math.Float64bits(0.5)), math.Float64bits(-3)), []float32{0.5, -3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := decodeRawTensor(test.datatype, []int64{1, 2}, test.raw)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, data)
+		})
+	}
+
+	_, err := decodeRawTensor("FP32", []int64{1, 3}, make([]byte, 8))
+	assert.ErrorContains(t, err, "do not match shape")
+	_, err = decodeRawTensor("FP16", []int64{1, 2}, make([]byte, 5))
+	assert.ErrorContains(t, err, "do not match shape")
+	_, err = decodeRawTensor("FP32", []int64{-1, 2}, nil)
+	assert.ErrorContains(t, err, "invalid dimension")
+	_, err = decodeRawTensor("BYTES", []int64{1}, make([]byte, 8))
+	assert.ErrorContains(t, err, "unsupported datatype")
+}
+
+func TestDecodeOutputTensor(t *testing.T) {
+	typed := &grpc_client.ModelInferResponse{
+		Outputs: []*grpc_client.ModelInferResponse_InferOutputTensor{
+			{Name: "labels", Datatype: "INT64", Shape: []int64{1, 2}, Contents: &grpc_client.InferTensorContents{Int64Contents: []int64{3, 5}}},
+			{Name: "scores", Datatype: "FP16", Shape: []int64{1, 2}, Contents: &grpc_client.InferTensorContents{Fp32Contents: []float32{0.5, 0.25}}},
+			{Name: "classes", Datatype: "UINT8", Shape: []int64{1, 2}, Contents: &grpc_client.InferTensorContents{UintContents: []uint32{1, 2}}},
+			{Name: "short", Datatype: "INT32", Shape: []int64{1, 3}, Contents: &grpc_client.InferTensorContents{IntContents: []int32{1, 2}}},
+		},
+	}
+	response, err := fromGRPCInferResponse(typed)
+	require.NoError(t, err)
+	tensor, err := DecodeOutputTensor(response, 0)
+	require.NoError(t, err)
+	assert.Equal(t, &Tensor{Name: "labels", Datatype: "INT64", Shape: []int64{1, 2}, Data: []float32{3, 5}}, tensor)
+	tensor, err = DecodeOutputTensor(response, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.5, 0.25}, tensor.Data)
+	tensor, err = DecodeOutputTensor(response, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{1, 2}, tensor.Data)
+	_, err = DecodeOutputTensor(response, 3)
+	assert.ErrorContains(t, err, "output 'short'")
+	_, err = DecodeOutputTensor(response, 4)
+	assert.Error(t, err)
+
+	// raw contents take precedence over typed contents
+	raw := &grpc_client.ModelInferResponse{
+		Outputs: []*grpc_client.ModelInferResponse_InferOutputTensor{
+			{Name: "labels", Datatype: "UINT8", Shape: []int64{2}},
+			{Name: "scores", Datatype: "FP32", Shape: []int64{1}},
+		},
+		RawOutputContents: [][]byte{{4, 2}, binary.LittleEndian.AppendUint32(nil, math.Float32bits(0.75))},
+	}
+	response, err = fromGRPCInferResponse(raw)
+	require.NoError(t, err)
+	tensor, err = DecodeOutputTensor(response, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []float32{0.75}, tensor.Data)
+
+	raw.RawOutputContents = raw.RawOutputContents[:1]
+	_, err = fromGRPCInferResponse(raw)
+	assert.ErrorContains(t, err, "raw output contents")
+}
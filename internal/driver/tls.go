@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// This package provides an example implementation of
+// OpenVINO model server interface.
+
+package driver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/spf13/cast"
+)
+
+// TLSInfo holds the TLS settings of a device, read from its protocol properties
+type TLSInfo struct {
+	Enabled    bool
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	ServerName string
+	SecretName string
+}
+
+// parse TLS settings from protocol properties, TLS is disabled when 'TLS' is absent
+func parseTLSInfo(protocol models.ProtocolProperties) (TLSInfo, error) {
+	var info TLSInfo
+	if value, ok := protocol[TLS]; ok && value != "" {
+		enabled, err := cast.ToBoolE(value)
+		if err != nil {
+			return info, fmt.Errorf("invalid value for '%s', please configure a valid boolean value (true or false)", TLS)
+		}
+		info.Enabled = enabled
+	}
+	info.CACert, _ = cast.ToStringE(protocol[CACert])
+	info.ClientCert, _ = cast.ToStringE(protocol[ClientCert])
+	info.ClientKey, _ = cast.ToStringE(protocol[ClientKey])
+	info.ServerName, _ = cast.ToStringE(protocol[ServerName])
+	info.SecretName, _ = cast.ToStringE(protocol[TLSSecretName])
+	return info, nil
+}
+
+// get a secret from the secret store, nil when no secret name is set
+func (d *Driver) getSecret(secretName string) (map[string]string, error) {
+	if secretName == "" {
+		return nil, nil
+	}
+	if d.sdk == nil {
+		return nil, fmt.Errorf("secret store is not available to read '%s'", secretName)
+	}
+	secret, err := d.sdk.SecretProvider().GetSecret(secretName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret '%s': %v", secretName, err)
+	}
+	return secret, nil
+}
+
+// load PEM material from file if a path is configured, otherwise from the secret store
+func (d *Driver) loadPEM(path string, secret map[string]string, secretKey string) ([]byte, error) {
+	if path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %v", path, err)
+		}
+		return pem, nil
+	}
+	if value, ok := secret[secretKey]; ok && value != "" {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+// NewTLSConfig builds the client TLS config of a device, returns nil if TLS is disabled
+func (d *Driver) NewTLSConfig(info TLSInfo) (*tls.Config, error) {
+	if !info.Enabled {
+		return nil, nil
+	}
+
+	secret, err := d.getSecret(info.SecretName)
+	if err != nil {
+		return nil, err
+	}
+
+	caPEM, err := d.loadPEM(info.CACert, secret, SecretCACert)
+	if err != nil {
+		return nil, err
+	}
+	// OVMS is verified with the system roots when no CA is configured
+	var pool *x509.CertPool
+	if caPEM != nil {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid PEM certificate found in CA bundle")
+		}
+	}
+
+	certPEM, err := d.loadPEM(info.ClientCert, secret, SecretClientCert)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := d.loadPEM(info.ClientKey, secret, SecretClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		ServerName: info.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	// mutual TLS requires both client certificate and key
+	if certPEM != nil || keyPEM != nil {
+		if certPEM == nil || keyPEM == nil {
+			return nil, fmt.Errorf("both client certificate and client key are required for mutual TLS")
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate or key: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
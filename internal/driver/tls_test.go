@@ -0,0 +1,155 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package driver
+
+import (
+	"crypto/tls"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/edgexfoundry/device-sdk-go/v4/pkg/interfaces"
+	bootstrapInterfaces "github.com/edgexfoundry/go-mod-bootstrap/v4/bootstrap/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v4/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// an SDK whose secret store holds the secrets by secret name, other calls panic
+type secretSDK struct {
+	interfaces.DeviceServiceSDK
+	secrets map[string]map[string]string
+}
+
+func (s secretSDK) SecretProvider() bootstrapInterfaces.SecretProvider {
+	return secretStore{secrets: s.secrets}
+}
+
+type secretStore struct {
+	bootstrapInterfaces.SecretProvider
+	secrets map[string]map[string]string
+}
+
+func (s secretStore) GetSecret(secretName string, keys ...string) (map[string]string, error) {
+	secret, ok := s.secrets[secretName]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return secret, nil
+}
+
+func TestParseTLSInfo(t *testing.T) {
+	info, err := parseTLSInfo(models.ProtocolProperties{})
+	require.NoError(t, err)
+	assert.False(t, info.Enabled)
+
+	info, err = parseTLSInfo(models.ProtocolProperties{
+		TLS:           "true",
+		CACert:        "/certs/ca.pem",
+		ClientCert:    "/certs/client.pem",
+		ClientKey:     "/certs/client.key",
+		ServerName:    "ovms.local",
+		TLSSecretName: "ovms-tls",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, TLSInfo{
+		Enabled:    true,
+		CACert:     "/certs/ca.pem",
+		ClientCert: "/certs/client.pem",
+		ClientKey:  "/certs/client.key",
+		ServerName: "ovms.local",
+		SecretName: "ovms-tls",
+	}, info)
+
+	_, err = parseTLSInfo(models.ProtocolProperties{TLS: "yes please"})
+	assert.Error(t, err)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	d := newTestDriver(t)
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeServerCert(t, dir)
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidFile, []byte("not a certificate"), 0600))
+
+	config, err := d.NewTLSConfig(TLSInfo{})
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	// the system roots verify OVMS without a CA
+	config, err = d.NewTLSConfig(TLSInfo{Enabled: true, ServerName: "ovms.local"})
+	require.NoError(t, err)
+	assert.Nil(t, config.RootCAs)
+	assert.Equal(t, "ovms.local", config.ServerName)
+
+	config, err = d.NewTLSConfig(TLSInfo{Enabled: true, CACert: certFile, ClientCert: certFile, ClientKey: keyFile})
+	require.NoError(t, err)
+	assert.NotNil(t, config.RootCAs)
+	assert.Len(t, config.Certificates, 1)
+
+	for name, info := range map[string]TLSInfo{
+		"missing CA":      {Enabled: true, CACert: filepath.Join(dir, "missing.pem")},
+		"invalid CA":      {Enabled: true, CACert: invalidFile},
+		"cert alone":      {Enabled: true, ClientCert: certFile},
+		"key alone":       {Enabled: true, ClientKey: keyFile},
+		"invalid cert":    {Enabled: true, ClientCert: invalidFile, ClientKey: keyFile},
+		"missing key":     {Enabled: true, ClientCert: certFile, ClientKey: filepath.Join(dir, "missing.key")},
+		"no secret store": {Enabled: true, SecretName: "ovms-tls"},
+		"mismatched key":  {Enabled: true, ClientCert: certFile, ClientKey: certFile},
+	} {
+		_, err := d.NewTLSConfig(info)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewTLSConfigSecret(t *testing.T) {
+	d := newTestDriver(t)
+	dir := t.TempDir()
+	certFile, keyFile, pool := writeServerCert(t, dir)
+	certPEM, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	keyPEM, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+	d.sdk = secretSDK{secrets: map[string]map[string]string{
+		"ovms-tls": {SecretCACert: string(certPEM), SecretClientCert: string(certPEM), SecretClientKey: string(keyPEM)},
+		"ovms-ca":  {SecretCACert: "not a certificate"},
+		"empty":    {},
+	}}
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	// the CA and the client certificate are read from the secret
+	config, err := d.NewTLSConfig(TLSInfo{Enabled: true, ServerName: "ovms.local", SecretName: "ovms-tls"})
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(config.RootCAs))
+	require.Len(t, config.Certificates, 1)
+	assert.Equal(t, clientCert.Certificate, config.Certificates[0].Certificate)
+	assert.Equal(t, "ovms.local", config.ServerName)
+
+	// a file path takes precedence over the secret
+	_, err = d.NewTLSConfig(TLSInfo{Enabled: true, SecretName: "ovms-ca"})
+	assert.ErrorContains(t, err, "no valid PEM certificate")
+	config, err = d.NewTLSConfig(TLSInfo{Enabled: true, CACert: certFile, SecretName: "ovms-ca"})
+	require.NoError(t, err)
+	assert.True(t, pool.Equal(config.RootCAs))
+	assert.Empty(t, config.Certificates)
+
+	// the secret completes the files, e.g. the key of a client certificate file
+	config, err = d.NewTLSConfig(TLSInfo{Enabled: true, ClientCert: certFile, SecretName: "ovms-tls"})
+	require.NoError(t, err)
+	require.Len(t, config.Certificates, 1)
+	assert.Equal(t, clientCert.Certificate, config.Certificates[0].Certificate)
+
+	// an empty secret verifies OVMS with the system roots, a missing one fails
+	config, err = d.NewTLSConfig(TLSInfo{Enabled: true, SecretName: "empty"})
+	require.NoError(t, err)
+	assert.Nil(t, config.RootCAs)
+	assert.Empty(t, config.Certificates)
+	_, err = d.NewTLSConfig(TLSInfo{Enabled: true, SecretName: "missing"})
+	assert.ErrorContains(t, err, "failed to get secret 'missing'")
+}
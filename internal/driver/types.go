@@ -9,6 +9,12 @@
 
 package driver
 
+import (
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
 type OVMSInfo struct {
 	Host     string
 	Port     int
@@ -20,6 +26,20 @@ type OVMSInfo struct {
 	Score    string
 }
 
+// inferFrame is a captured frame waiting for its inference result
+type inferFrame struct {
+	img    gocv.Mat
+	start  time.Time
+	result chan inferFrameResult
+}
+
+type inferFrameResult struct {
+	response *InferResponse
+	err      error
+	// latency of the inference request
+	latency time.Duration
+}
+
 type ImageSize struct {
 	Width  int
 	Height int
@@ -7,8 +7,6 @@
 package driver
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"strconv"
 
@@ -78,22 +76,3 @@ func (d *Driver) VerifyBoolValue(value models.ProtocolProperties, name string) e
 	}
 	return nil
 }
-
-// Convert slice of 4 bytes to int32 (assumes Little Endian)
-func (d *Driver) readFloat32(fourBytes []byte) float32 {
-	buf := bytes.NewBuffer(fourBytes)
-	var retval float32
-	binary.Read(buf, binary.LittleEndian, &retval)
-	return retval
-}
-
-// read raw bytes data to float32
-func (d *Driver) readRawDataToFloat32(inputBytes []byte) []float32 {
-	dataLen := len(inputBytes) / 4
-	outputData := make([]float32, dataLen)
-
-	for i := 0; i < dataLen; i++ {
-		outputData[i] = d.readFloat32(inputBytes[i*4 : i*4+4])
-	}
-	return outputData
-}
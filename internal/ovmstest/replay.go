@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ovmstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// ReplaySource replays the JPEG and PNG images of a directory in name order,
+// in place of a camera
+type ReplaySource struct {
+	files []string
+	loop  bool
+
+	mu     sync.Mutex
+	next   int
+	reads  int
+	closed bool
+}
+
+// NewReplaySource lists the images of 'dir', with 'loop' the images are replayed forever
+func NewReplaySource(dir string, loop bool) (*ReplaySource, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no image found in %s", dir)
+	}
+	sort.Strings(files)
+	return &ReplaySource{files: files, loop: loop}, nil
+}
+
+// Read the next image into img, false once all images are replayed without loop or after Close
+func (r *ReplaySource) Read(img *gocv.Mat) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return false
+	}
+	if r.next == len(r.files) {
+		if !r.loop {
+			return false
+		}
+		r.next = 0
+	}
+	frame := gocv.IMRead(r.files[r.next], gocv.IMReadColor)
+	r.next++
+	if frame.Empty() {
+		frame.Close()
+		return false
+	}
+	defer frame.Close()
+	if err := frame.CopyTo(img); err != nil {
+		return false
+	}
+	r.reads++
+	return true
+}
+
+// Reads returns the number of images read
+func (r *ReplaySource) Reads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reads
+}
+
+func (r *ReplaySource) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
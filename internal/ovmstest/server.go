@@ -0,0 +1,313 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+//
+// Copyright (C) 2024 YIQISOFT
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ovmstest provides an in-process OpenVINO model server and a frame
+// source replaying images from disk, for tests and offline development.
+package ovmstest
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	grpc_client "github.com/edgexfoundry/device-ai-openvino-ovms/internal/driver/grpc-client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// size of the in-memory connection buffer
+const bufSize = 1 << 20
+
+// Detection is a canned object detection returned by the fake server,
+// coordinates are relative to the frame size
+type Detection struct {
+	Label      float32
+	Confidence float32
+	XMin       float32
+	YMin       float32
+	XMax       float32
+	YMax       float32
+}
+
+// Server is a fake OVMS serving one object detection model over KServe v2 gRPC.
+// Its metadata follows the SSD models of OVMS: an NHWC image input and a
+// [1, 1, N, 7] FP32 detection output.
+type Server struct {
+	grpc_client.UnimplementedGRPCInferenceServiceServer
+
+	Model   string
+	Version string
+	Inputs  []*grpc_client.ModelMetadataResponse_TensorMetadata
+	Output  string
+
+	mu         sync.Mutex
+	ready      bool
+	detections []Detection
+	inferErr   error
+	latency    time.Duration
+
+	lastRequest *grpc_client.ModelInferRequest
+	batchSizes  []int
+
+	inferCount atomic.Int64
+	grpcServer *grpc.Server
+	listener   *bufconn.Listener
+}
+
+// NewServer returns a ready fake server for 'model' version 1, with a 300x300 input and no detection
+func NewServer(model string) *Server {
+	return &Server{
+		Model:   model,
+		Version: "1",
+		Inputs: []*grpc_client.ModelMetadataResponse_TensorMetadata{
+			{Name: "image_tensor", Datatype: "BYTES", Shape: []int64{1, 300, 300, 3}},
+		},
+		Output: "detection_out",
+		ready:  true,
+	}
+}
+
+// SetReady sets the readiness reported for the server and the model
+func (s *Server) SetReady(ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = ready
+}
+
+// SetDetections sets the detections returned by every inference
+func (s *Server) SetDetections(detections ...Detection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detections = detections
+}
+
+// SetInferError makes every inference fail with 'err', nil restores successful inferences
+func (s *Server) SetInferError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inferErr = err
+}
+
+// SetLatency delays every inference by 'latency', as a busy server
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// InferCount returns the number of inference requests received
+func (s *Server) InferCount() int64 {
+	return s.inferCount.Load()
+}
+
+// LastRequest returns the last inference request with all model inputs
+func (s *Server) LastRequest() *grpc_client.ModelInferRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastRequest
+}
+
+// BatchSizes returns the batch size of every inference request received
+func (s *Server) BatchSizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batchSizes...)
+}
+
+// Start serves on an in-memory listener, see Dial
+func (s *Server) Start() {
+	s.listener = bufconn.Listen(bufSize)
+	go s.Serve(s.listener)
+}
+
+// Serve serves on 'lis' until Stop, e.g. a TCP listener for offline development
+func (s *Server) Serve(lis net.Listener) error {
+	s.mu.Lock()
+	if s.grpcServer == nil {
+		s.grpcServer = grpc.NewServer()
+		grpc_client.RegisterGRPCInferenceServiceServer(s.grpcServer, s)
+	}
+	server := s.grpcServer
+	s.mu.Unlock()
+	return server.Serve(lis)
+}
+
+// Dial connects to the in-memory listener of a started server
+func (s *Server) Dial() (*grpc.ClientConn, error) {
+	return grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return s.listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+}
+
+// Stop closes all connections and listeners
+func (s *Server) Stop() {
+	s.mu.Lock()
+	server := s.grpcServer
+	s.mu.Unlock()
+	if server != nil {
+		server.Stop()
+	}
+}
+
+func (s *Server) isReady() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
+// check the model name and version of a request
+func (s *Server) checkModel(name string, version string) error {
+	if name != s.Model || (version != "" && version != s.Version) {
+		return status.Errorf(codes.NotFound, "model with requested name %s and version %s is not found", name, version)
+	}
+	return nil
+}
+
+func (s *Server) ServerLive(ctx context.Context, in *grpc_client.ServerLiveRequest) (*grpc_client.ServerLiveResponse, error) {
+	return &grpc_client.ServerLiveResponse{Live: true}, nil
+}
+
+func (s *Server) ServerReady(ctx context.Context, in *grpc_client.ServerReadyRequest) (*grpc_client.ServerReadyResponse, error) {
+	return &grpc_client.ServerReadyResponse{Ready: s.isReady()}, nil
+}
+
+func (s *Server) ModelReady(ctx context.Context, in *grpc_client.ModelReadyRequest) (*grpc_client.ModelReadyResponse, error) {
+	if err := s.checkModel(in.GetName(), in.GetVersion()); err != nil {
+		return nil, err
+	}
+	return &grpc_client.ModelReadyResponse{Ready: s.isReady()}, nil
+}
+
+func (s *Server) ModelMetadata(ctx context.Context, in *grpc_client.ModelMetadataRequest) (*grpc_client.ModelMetadataResponse, error) {
+	if err := s.checkModel(in.GetName(), in.GetVersion()); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	count := len(s.detections)
+	s.mu.Unlock()
+	return &grpc_client.ModelMetadataResponse{
+		Name:     s.Model,
+		Versions: []string{s.Version},
+		Platform: "OpenVINO",
+		Inputs:   s.Inputs,
+		Outputs: []*grpc_client.ModelMetadataResponse_TensorMetadata{
+			{Name: s.Output, Datatype: "FP32", Shape: []int64{1, 1, int64(count), 7}},
+		},
+	}, nil
+}
+
+func (s *Server) ModelInfer(ctx context.Context, in *grpc_client.ModelInferRequest) (*grpc_client.ModelInferResponse, error) {
+	s.inferCount.Add(1)
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err := s.checkModel(in.GetModelName(), in.GetModelVersion()); err != nil {
+		return nil, err
+	}
+	// every input of the model must be fed
+	for _, input := range s.Inputs {
+		found := false
+		for _, requested := range in.GetInputs() {
+			found = found || requested.GetName() == input.GetName()
+		}
+		if !found {
+			return nil, status.Errorf(codes.InvalidArgument, "missing input '%s' in request", input.GetName())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRequest = in
+	if s.inferErr != nil {
+		return nil, s.inferErr
+	}
+	detections := s.detections
+
+	// batched requests get the detections for every image, told apart by image_id
+	batch := 1
+	if shape := in.GetInputs()[0].GetShape(); len(shape) > 0 && shape[0] > 1 {
+		batch = int(shape[0])
+	}
+	s.batchSizes = append(s.batchSizes, batch)
+	resp := DetectionResponse(detections)
+	if batch > 1 {
+		raw := make([]byte, 0, batch*len(resp.RawOutputContents[0]))
+		for i := 0; i < batch; i++ {
+			raw = append(raw, encodeDetections(float32(i), detections)...)
+		}
+		resp.Outputs[0].Shape = []int64{1, 1, int64(batch * len(detections)), 7}
+		resp.RawOutputContents[0] = raw
+	}
+	resp.ModelName = s.Model
+	resp.ModelVersion = s.Version
+	resp.Id = in.GetId()
+	resp.Outputs[0].Name = s.Output
+	return resp, nil
+}
+
+func (s *Server) ModelStreamInfer(stream grpc_client.GRPCInferenceService_ModelStreamInferServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := &grpc_client.ModelStreamInferResponse{}
+		out, err := s.ModelInfer(stream.Context(), in)
+		if err != nil {
+			resp.ErrorMessage = err.Error()
+			resp.InferResponse = &grpc_client.ModelInferResponse{Id: in.GetId()}
+		} else {
+			resp.InferResponse = out
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// EncodeDetections encodes detections as the raw little endian FP32 content of a detection output
+func EncodeDetections(detections []Detection) []byte {
+	return encodeDetections(0, detections)
+}
+
+func encodeDetections(imageId float32, detections []Detection) []byte {
+	buf := make([]byte, 0, len(detections)*7*4)
+	for _, d := range detections {
+		for _, v := range []float32{imageId, d.Label, d.Confidence, d.XMin, d.YMin, d.XMax, d.YMax} {
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+		}
+	}
+	return buf
+}
+
+// DetectionResponse returns an inference response with the detections as raw FP32 'detection_out' output
+func DetectionResponse(detections []Detection) *grpc_client.ModelInferResponse {
+	return &grpc_client.ModelInferResponse{
+		Outputs: []*grpc_client.ModelInferResponse_InferOutputTensor{
+			{Name: "detection_out", Datatype: "FP32", Shape: []int64{1, 1, int64(len(detections)), 7}},
+		},
+		RawOutputContents: [][]byte{EncodeDetections(detections)},
+	}
+}